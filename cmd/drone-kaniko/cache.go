@@ -0,0 +1,58 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/drone-plugins/drone-plugin-lib/urfave"
+	"github.com/urfave/cli/v2"
+	"go.megpoid.dev/drone-kaniko/pkg/kaniko"
+)
+
+// cacheCommand manages the cache directory and cache repository outside a build.
+func cacheCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "cache",
+		Usage: "Manage the local cache directory and the cache repository",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "stats",
+				Usage: "Show the entries and size of the cache directory",
+				Action: func(ctx *cli.Context) error {
+					settings := settingsFromContext(ctx)
+					return kaniko.PrintCacheStats(&settings)
+				},
+			},
+			{
+				Name:  "gc",
+				Usage: "Evict the cache entries older than cache-ttl or beyond cache-max-size",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: `Only report the entries that would be evicted`,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					settings := settingsFromContext(ctx)
+					return kaniko.CollectCache(&settings, ctx.Bool("dry-run"))
+				},
+			},
+			{
+				Name:  "prune",
+				Usage: "Delete the cache repository tags older than cache-prune-age or beyond cache-prune-keep",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: `Only report the tags that would be deleted`,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					settings := settingsFromContext(ctx)
+					pipeline := urfave.PipelineFromContext(ctx)
+					return kaniko.PruneCacheRepo(&settings, &pipeline, ctx.Bool("dry-run"))
+				},
+			},
+		},
+	}
+}
@@ -5,7 +5,13 @@
 package main
 
 import (
+	"os"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/urfave/cli/v2"
+	"go.megpoid.dev/drone-kaniko/pkg/cache"
 	"go.megpoid.dev/drone-kaniko/pkg/kaniko"
 )
 
@@ -188,7 +194,7 @@ func settingsFlags() []cli.Flag {
 		&cli.BoolFlag{
 			Name:    "no-push",
 			Aliases: []string{"dry-run"},
-			Usage:   `Do not push the image to the registry`,
+			Usage:   `Do not push the image to the registry. The dry-run alias is deprecated, and will run the simulation of the simulate setting in the next release`,
 			EnvVars: []string{"PLUGIN_NO_PUSH", "PLUGIN_DRY_RUN"},
 		},
 		&cli.BoolFlag{
@@ -321,9 +327,15 @@ func settingsFlags() []cli.Flag {
 		// main flags
 		&cli.StringSliceFlag{
 			Name:    "args-from-env",
-			Usage:   "This flag allows you to pass in ARG values at build time. Read from environment",
+			Usage:   "This flag allows you to pass in ARG values at build time. Read from environment with the exact case of the variable, use ARG=ENV to rename the variable and the secret: prefix to mask its value",
 			EnvVars: []string{"PLUGIN_BUILD_ARGS_FROM_ENV"},
 		},
+		&cli.StringFlag{
+			Name:    "proxy-case",
+			Usage:   `Case of the proxy build args added from the environment, both, lower or upper`,
+			Value:   "both",
+			EnvVars: []string{"PLUGIN_PROXY_CASE"},
+		},
 		&cli.BoolFlag{
 			Name:    "debug",
 			Usage:   `Enable debug logging. Compatible with drone-docker plugin to provide 'verbosity'`,
@@ -357,6 +369,67 @@ func settingsFlags() []cli.Flag {
 			Usage:   `Force cache overwritting (warmer)`,
 			EnvVars: []string{"PLUGIN_FORCE_CACHE"},
 		},
+		&cli.StringFlag{
+			Name:    "warmer-policy",
+			Usage:   `Action to take when the warmer fails to cache an image that exists (ignore, warn, fail)`,
+			Value:   kaniko.WarmerPolicyWarn,
+			EnvVars: []string{"PLUGIN_WARMER_POLICY"},
+		},
+		&cli.IntFlag{
+			Name:    "warmer-concurrency",
+			Usage:   `Number of platforms to warm up at the same time`,
+			Value:   2,
+			EnvVars: []string{"PLUGIN_WARMER_CONCURRENCY"},
+		},
+		&cli.StringFlag{
+			Name:    "cache-gc",
+			Usage:   `Run the garbage collection of the cache directory before or after the build (pre, post)`,
+			EnvVars: []string{"PLUGIN_CACHE_GC"},
+		},
+		&cli.StringFlag{
+			Name:    "cache-gc-policy",
+			Usage:   `Eviction policy of the cache directory garbage collection (lru, age)`,
+			Value:   cache.PolicyLRU,
+			EnvVars: []string{"PLUGIN_CACHE_GC_POLICY"},
+		},
+		&cli.StringFlag{
+			Name:    "cache-max-size",
+			Usage:   `Maximum size of the cache directory (e.g. 512MiB, 10GB)`,
+			EnvVars: []string{"PLUGIN_CACHE_MAX_SIZE"},
+		},
+		&cli.StringFlag{
+			Name:    "cache-repo-template",
+			Usage:   `Template of the cache repository used if cache-repo is not set. Supports {{repo}}, {{branch}} and {{default_branch}}`,
+			Value:   "{{repo}}/cache",
+			EnvVars: []string{"PLUGIN_CACHE_REPO_TEMPLATE"},
+		},
+		&cli.BoolFlag{
+			Name:    "cache-per-branch",
+			Usage:   `Use a separate cache repository for branches other than the default one, seeded from the default branch cache`,
+			EnvVars: []string{"PLUGIN_CACHE_PER_BRANCH"},
+		},
+		&cli.IntFlag{
+			Name:    "cache-seed-limit",
+			Usage:   `Maximum number of layers copied from the default branch cache to seed a branch cache, newest first. Zero copies every layer newer than the cache-ttl`,
+			Value:   200,
+			EnvVars: []string{"PLUGIN_CACHE_SEED_LIMIT"},
+		},
+		&cli.StringFlag{
+			Name:    "cache-branch-repo-template",
+			Usage:   `Template of the cache repository used for branches other than the default one`,
+			Value:   "{{repo}}/cache/{{branch}}",
+			EnvVars: []string{"PLUGIN_CACHE_BRANCH_REPO_TEMPLATE"},
+		},
+		&cli.DurationFlag{
+			Name:    "cache-prune-age",
+			Usage:   `Delete the cache repository tags older than this duration`,
+			EnvVars: []string{"PLUGIN_CACHE_PRUNE_AGE"},
+		},
+		&cli.IntFlag{
+			Name:    "cache-prune-keep",
+			Usage:   `Maximum number of tags to keep in the cache repository`,
+			EnvVars: []string{"PLUGIN_CACHE_PRUNE_KEEP"},
+		},
 		&cli.BoolFlag{
 			Name:    "tags-auto",
 			Usage:   `Default build tags`,
@@ -388,6 +461,201 @@ func settingsFlags() []cli.Flag {
 			Usage:   `Only warn on missing images defined in platform list`,
 			EnvVars: []string{"PLUGIN_IGNORE_MISSING"},
 		},
+		&cli.BoolFlag{
+			Name:    "push-target",
+			Usage:   `Push the target stage as its own tag, with the target name as suffix, and build the final stage as the main image`,
+			EnvVars: []string{"PLUGIN_PUSH_TARGET"},
+		},
+		&cli.BoolFlag{
+			Name:    "simulate",
+			Usage:   `Validate the settings, run the preflight checks and print the planned commands without building`,
+			EnvVars: []string{"PLUGIN_SIMULATE"},
+		},
+		&cli.BoolFlag{
+			Name:    "plan",
+			Usage:   `Print the execution plan without running it`,
+			EnvVars: []string{"PLUGIN_PLAN"},
+		},
+		&cli.StringFlag{
+			Name:    "plan-format",
+			Usage:   `Format of the execution plan, text or json`,
+			Value:   "text",
+			EnvVars: []string{"PLUGIN_PLAN_FORMAT"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "mask-vars",
+			Usage:   `Names or patterns of environment variables and build args to mask in the logs, besides *TOKEN*, *PASSWORD*, *KEY* and *SECRET*`,
+			EnvVars: []string{"PLUGIN_MASK_VARS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "secrets",
+			Usage:   `Secrets available to the RUN instructions in /run/secrets/<id>, e.g. id=npm_token,env=NPM_TOKEN or id=netrc,src=/path/.netrc`,
+			EnvVars: []string{"PLUGIN_SECRETS"},
+		},
+		&cli.DurationFlag{
+			Name:    "timeout",
+			Usage:   `Maximum duration of the whole execution`,
+			EnvVars: []string{"PLUGIN_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "warm-timeout",
+			Usage:   `Maximum duration of the cache warm of each build`,
+			EnvVars: []string{"PLUGIN_WARM_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "build-timeout",
+			Usage:   `Maximum duration of the kaniko build of each platform`,
+			EnvVars: []string{"PLUGIN_BUILD_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "manifest-timeout",
+			Usage:   `Maximum duration of each manifest list push`,
+			EnvVars: []string{"PLUGIN_MANIFEST_TIMEOUT"},
+		},
+		&cli.IntFlag{
+			Name:    "retry-max-attempts",
+			Usage:   `Maximum attempts of each platform build and manifest push that fail with a network or registry error`,
+			Value:   1,
+			EnvVars: []string{"PLUGIN_RETRY_MAX_ATTEMPTS"},
+		},
+		&cli.DurationFlag{
+			Name:    "retry-backoff",
+			Usage:   `Time to wait before the first retry, doubled on every attempt`,
+			Value:   10 * time.Second,
+			EnvVars: []string{"PLUGIN_RETRY_BACKOFF"},
+		},
+		&cli.DurationFlag{
+			Name:    "retry-max-backoff",
+			Usage:   `Maximum time to wait between retries`,
+			Value:   2 * time.Minute,
+			EnvVars: []string{"PLUGIN_RETRY_MAX_BACKOFF"},
+		},
+		&cli.StringFlag{
+			Name:    "settings-file",
+			Usage:   `Path to a YAML/JSON file with the plugin settings, defaults to .kaniko.yaml if present`,
+			EnvVars: []string{"PLUGIN_SETTINGS_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "settings-target",
+			Usage:   `Name of the build target of the settings file to use`,
+			EnvVars: []string{"PLUGIN_SETTINGS_TARGET"},
+		},
+		&cli.StringFlag{
+			Name:    "builds",
+			Usage:   `List of builds (JSON/YAML), each one with the settings to override, e.g. dockerfile, context, target, build-arg or destination`,
+			EnvVars: []string{"PLUGIN_BUILDS"},
+		},
+		&cli.IntFlag{
+			Name:    "builds-concurrency",
+			Usage:   `Number of builds to run at the same time. The builds share the root filesystem of the container, so only run builds concurrently when their stages don't write to the same paths`,
+			Value:   1,
+			EnvVars: []string{"PLUGIN_BUILDS_CONCURRENCY"},
+		},
+		&cli.StringFlag{
+			Name:    "report-file",
+			Usage:   `Path to save the build report as JSON`,
+			EnvVars: []string{"PLUGIN_REPORT_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "outputs-file",
+			Usage:   `Path to save the digests and tags of the pushed images for later steps`,
+			EnvVars: []string{"PLUGIN_OUTPUTS_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "outputs-format",
+			Usage:   `Format of the outputs file (dotenv, json), guessed from the extension if not set`,
+			EnvVars: []string{"PLUGIN_OUTPUTS_FORMAT"},
+		},
+		&cli.BoolFlag{
+			Name:    "scan",
+			Usage:   `Scan the image for vulnerabilities before pushing it`,
+			EnvVars: []string{"PLUGIN_SCAN"},
+		},
+		&cli.StringFlag{
+			Name:    "scanner",
+			Usage:   `Scanner binary used to scan the image`,
+			EnvVars: []string{"PLUGIN_SCANNER"},
+			Value:   "trivy",
+		},
+		&cli.StringFlag{
+			Name:    "scan-args",
+			Usage:   `Arguments of the scanner, with {image} replaced by the image tarball and {output} by the report path (read from stdout if missing). Defaults to a SARIF report for trivy and grype`,
+			EnvVars: []string{"PLUGIN_SCAN_ARGS"},
+		},
+		&cli.StringFlag{
+			Name:    "scan-format",
+			Usage:   `Format of the scanner report (sarif, json)`,
+			EnvVars: []string{"PLUGIN_SCAN_FORMAT"},
+			Value:   "sarif",
+		},
+		&cli.StringFlag{
+			Name:    "scan-severity",
+			Usage:   `Minimum severity of the vulnerabilities that block the push (low, medium, high, critical)`,
+			EnvVars: []string{"PLUGIN_SCAN_SEVERITY"},
+			Value:   "critical",
+		},
+		&cli.StringFlag{
+			Name:    "scan-allowlist",
+			Usage:   `File with the allowed vulnerability ids, in the .trivyignore format`,
+			EnvVars: []string{"PLUGIN_SCAN_ALLOWLIST"},
+		},
+		&cli.StringFlag{
+			Name:    "policy-file",
+			Usage:   `YAML file with the policy rules the image must pass before it is pushed (non-root, required-labels, max-layers, max-size, allowed-ports, allowed-registries)`,
+			EnvVars: []string{"PLUGIN_POLICY_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "size-diff",
+			Usage:   `Compare the size and layers of the image with its previous release`,
+			EnvVars: []string{"PLUGIN_SIZE_DIFF"},
+		},
+		&cli.StringFlag{
+			Name:    "size-baseline",
+			Usage:   `Reference of the previous release to compare with, the latest tag of the first destination if not set`,
+			EnvVars: []string{"PLUGIN_SIZE_BASELINE"},
+		},
+		&cli.StringFlag{
+			Name:    "size-budget",
+			Usage:   `Fail before pushing if the compressed size of the image exceeds this size (e.g. 500MiB)`,
+			EnvVars: []string{"PLUGIN_SIZE_BUDGET"},
+		},
+		&cli.Float64Flag{
+			Name:    "size-max-growth",
+			Usage:   `Fail before pushing if the image grew more than this percentage since the previous release`,
+			EnvVars: []string{"PLUGIN_SIZE_MAX_GROWTH"},
+		},
+		&cli.StringFlag{
+			Name:    "mirrors",
+			Usage:   `List of repositories (JSON/YAML) that receive a copy of the pushed image or manifest list, each one with repo and optional username, password or password-env, and insecure`,
+			EnvVars: []string{"PLUGIN_MIRRORS"},
+		},
+		&cli.StringFlag{
+			Name:    "card-path",
+			Usage:   `Path to save the card of the step, e.g. /dev/stdout to write it in the log read by Drone. The card is disabled if empty`,
+			EnvVars: []string{"PLUGIN_CARD_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "card-schema",
+			Usage:   `URL of the card template, defaults to the card.json published with the plugin`,
+			Value:   "https://raw.githubusercontent.com/codestation/drone-kaniko/master/card.json",
+			EnvVars: []string{"PLUGIN_CARD_SCHEMA"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics-file",
+			Usage:   `Path to save the build metrics in the OpenMetrics format`,
+			EnvVars: []string{"PLUGIN_METRICS_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics-pushgateway",
+			Usage:   `URL of a Pushgateway to push the build metrics to`,
+			EnvVars: []string{"PLUGIN_METRICS_PUSHGATEWAY"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics-job",
+			Usage:   `Job name of the metrics pushed to the Pushgateway`,
+			Value:   "drone-kaniko",
+			EnvVars: []string{"PLUGIN_METRICS_JOB"},
+		},
 		&cli.StringSliceFlag{
 			Name:    "executor-extra-args",
 			Usage:   "List of extra args to pass to the Kaniko executor process",
@@ -468,20 +736,65 @@ func settingsFromContext(ctx *cli.Context) kaniko.Settings {
 		},
 		// other args
 		Main: kaniko.Main{
-			BuildArgsFromEnv: ctx.StringSlice("args-from-env"),
-			Debug:            ctx.Bool("debug"),
-			DryRun:           ctx.Bool("dry-run"),
-			ForceCache:       ctx.Bool("force-cache"),
-			Tags:             ctx.StringSlice("tags"),
-			Platforms:        ctx.StringSlice("platforms"),
-			TagsAuto:         ctx.Bool("tags-auto"),
-			TagsSuffix:       ctx.String("tags-suffix"),
-			Images:           ctx.StringSlice("image"),
-			Repo:             ctx.String("repo"),
-			LabelSchema:      ctx.StringSlice("label-schema"),
-			Mirror:           ctx.String("mirror"),
-			PushTarget:       ctx.Bool("push-target"),
-			AutoLabel:        ctx.Bool("auto-label"),
+			BuildArgsFromEnv:        ctx.StringSlice("args-from-env"),
+			ProxyCase:               ctx.String("proxy-case"),
+			Debug:                   ctx.Bool("debug"),
+			Simulate:                ctx.Bool("simulate"),
+			ForceCache:              ctx.Bool("force-cache"),
+			Tags:                    ctx.StringSlice("tags"),
+			Platforms:               ctx.StringSlice("platforms"),
+			TagsAuto:                ctx.Bool("tags-auto"),
+			TagsSuffix:              ctx.String("tags-suffix"),
+			Images:                  ctx.StringSlice("image"),
+			Repo:                    ctx.String("repo"),
+			LabelSchema:             ctx.StringSlice("label-schema"),
+			Mirror:                  ctx.String("mirror"),
+			PushTarget:              ctx.Bool("push-target"),
+			AutoLabel:               ctx.Bool("auto-label"),
+			WarmerPolicy:            ctx.String("warmer-policy"),
+			WarmerConcurrency:       ctx.Int("warmer-concurrency"),
+			CacheGC:                 ctx.String("cache-gc"),
+			CacheGCPolicy:           ctx.String("cache-gc-policy"),
+			CacheMaxSize:            ctx.String("cache-max-size"),
+			CacheRepoTemplate:       ctx.String("cache-repo-template"),
+			CacheBranchRepoTemplate: ctx.String("cache-branch-repo-template"),
+			CachePerBranch:          ctx.Bool("cache-per-branch"),
+			CacheSeedLimit:          ctx.Int("cache-seed-limit"),
+			CachePruneAge:           ctx.Duration("cache-prune-age"),
+			CachePruneKeep:          ctx.Int("cache-prune-keep"),
+			Builds:                  ctx.String("builds"),
+			BuildsConcurrency:       ctx.Int("builds-concurrency"),
+			Plan:                    ctx.Bool("plan"),
+			PlanFormat:              ctx.String("plan-format"),
+			MaskVars:                ctx.StringSlice("mask-vars"),
+			Secrets:                 ctx.StringSlice("secrets"),
+			Timeout:                 ctx.Duration("timeout"),
+			WarmTimeout:             ctx.Duration("warm-timeout"),
+			BuildTimeout:            ctx.Duration("build-timeout"),
+			ManifestTimeout:         ctx.Duration("manifest-timeout"),
+			RetryMaxAttempts:        ctx.Int("retry-max-attempts"),
+			RetryBackoff:            ctx.Duration("retry-backoff"),
+			RetryMaxBackoff:         ctx.Duration("retry-max-backoff"),
+			ReportFile:              ctx.String("report-file"),
+			CardPath:                ctx.String("card-path"),
+			OutputsFile:             ctx.String("outputs-file"),
+			OutputsFormat:           ctx.String("outputs-format"),
+			Scan:                    ctx.Bool("scan"),
+			Scanner:                 ctx.String("scanner"),
+			ScanArgs:                ctx.String("scan-args"),
+			ScanFormat:              ctx.String("scan-format"),
+			ScanSeverity:            ctx.String("scan-severity"),
+			ScanAllowlist:           ctx.String("scan-allowlist"),
+			PolicyFile:              ctx.String("policy-file"),
+			SizeDiff:                ctx.Bool("size-diff"),
+			SizeBaseline:            ctx.String("size-baseline"),
+			SizeBudget:              ctx.String("size-budget"),
+			SizeMaxGrowth:           ctx.Float64("size-max-growth"),
+			Mirrors:                 ctx.String("mirrors"),
+			CardSchema:              ctx.String("card-schema"),
+			MetricsFile:             ctx.String("metrics-file"),
+			MetricsPushgateway:      ctx.String("metrics-pushgateway"),
+			MetricsJob:              ctx.String("metrics-job"),
 		},
 		Manifest: kaniko.Manifest{
 			IgnoreMissing: ctx.Bool("ignore-missing"),
@@ -492,3 +805,20 @@ func settingsFromContext(ctx *cli.Context) kaniko.Settings {
 		},
 	}
 }
+
+// dryRunAliasUsed checks if no-push was enabled with its deprecated dry-run name. The flag parser
+// sets every name of the flag, so the environment and arguments are read directly.
+func dryRunAliasUsed(ctx *cli.Context) bool {
+	if !ctx.Bool("no-push") {
+		return false
+	}
+
+	if _, ok := os.LookupEnv("PLUGIN_DRY_RUN"); ok {
+		return true
+	}
+
+	return slices.ContainsFunc(os.Args[1:], func(arg string) bool {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		return strings.HasPrefix(arg, "-") && name == "dry-run"
+	})
+}
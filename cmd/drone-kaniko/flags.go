@@ -0,0 +1,21 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+	"go.megpoid.dev/drone-kaniko/pkg/kaniko"
+)
+
+// checkFlagsCommand verifies that the bundled kaniko supports every flag used by the plugin.
+func checkFlagsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "check-flags",
+		Usage: "Compare the plugin flags with the flags supported by the bundled kaniko",
+		Action: func(_ *cli.Context) error {
+			return kaniko.CheckFlags()
+		},
+	}
+}
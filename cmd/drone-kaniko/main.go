@@ -5,9 +5,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/drone-plugins/drone-plugin-lib/urfave"
 	"github.com/urfave/cli/v2"
@@ -29,6 +32,7 @@ func main() {
 	app.Usage = "Kaniko plugin"
 	app.Action = run
 	app.Flags = append(settingsFlags(), urfave.Flags()...)
+	app.Commands = []*cli.Command{cacheCommand(), checkFlagsCommand()}
 	app.Version = Tag
 	cli.VersionPrinter = printVersion
 
@@ -39,15 +43,45 @@ func main() {
 	}
 }
 
-func run(ctx *cli.Context) error {
+func run(ctx *cli.Context) (err error) {
 	urfave.LoggingFromContext(ctx)
 
+	// mask the secrets found while validating the settings
+	slog.SetDefault(slog.New(kaniko.Masker().NewHandler(slog.NewTextHandler(os.Stderr, nil))))
+
 	printVersion(ctx)
 
+	settings := settingsFromContext(ctx)
+
+	// the settings file has lower precedence than the flags and environment variables
+	err = kaniko.LoadSettingsFile(&settings, ctx.String("settings-file"), ctx.String("settings-target"), ctx.IsSet)
+	if err != nil {
+		return fmt.Errorf("failed to load settings: %w", err)
+	}
+
+	kaniko.ConfigureLogging(&settings)
+
+	if dryRunAliasUsed(ctx) {
+		slog.Warn("The dry-run setting is deprecated and will run a simulation without building in the next release, use no-push instead")
+	}
+
+	// stop kaniko gracefully when the step is canceled
+	network := urfave.NetworkFromContext(ctx)
+	signalCtx, stop := signalContext(network.Context)
+	defer stop()
+	network.Context = signalCtx
+
+	pipeline := urfave.PipelineFromContext(ctx)
+
+	// the step span covers the validation and the execution
+	traceCtx, finishTracing := kaniko.StartTracing(network.Context, &pipeline)
+	defer func() { finishTracing(err) }()
+	network.Context = traceCtx
+
 	plugin := kaniko.New(
-		settingsFromContext(ctx),
-		urfave.PipelineFromContext(ctx),
-		urfave.NetworkFromContext(ctx),
+		settings,
+		pipeline,
+		network,
 	)
 
 	// Validate the settings
@@ -62,3 +96,26 @@ func run(ctx *cli.Context) error {
 
 	return nil
 }
+
+// signalContext returns a context that is canceled when the process receives a SIGTERM or
+// SIGINT, with the signal as cause.
+func signalContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("Received signal, stopping kaniko", "signal", sig.String())
+			cancel(fmt.Errorf("interrupted by signal %s", sig))
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}
+}
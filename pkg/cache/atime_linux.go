@@ -0,0 +1,22 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+//go:build linux
+
+package cache
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// accessTime returns the last access time of the file, or the modification time if unavailable.
+func accessTime(info fs.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Sec, stat.Atim.Nsec)
+	}
+
+	return info.ModTime()
+}
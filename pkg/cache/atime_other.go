@@ -0,0 +1,17 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+
+package cache
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime returns the modification time of the file since the access time is not portable.
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
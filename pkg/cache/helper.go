@@ -0,0 +1,244 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Eviction policies
+const (
+	PolicyLRU = "lru"
+	PolicyAge = "age"
+)
+
+// Entry is an image stored in the kaniko cache directory. Kaniko stores every image as a
+// tarball named after its digest, plus a json file with the manifest.
+type Entry struct {
+	Name       string
+	Files      []string
+	Size       int64
+	ModTime    time.Time
+	AccessTime time.Time
+}
+
+// Stats of the cache directory.
+type Stats struct {
+	Entries int
+	Size    int64
+}
+
+type Config struct {
+	// MaxSize is the maximum size of the cache directory in bytes, zero means no limit.
+	MaxSize int64
+	// TTL evicts the entries older than this duration, zero means no limit.
+	TTL time.Duration
+	// Policy to choose the entries to evict, PolicyLRU or PolicyAge.
+	Policy string
+	DryRun bool
+}
+
+// Result of a garbage collection run.
+type Result struct {
+	Before  Stats
+	After   Stats
+	Evicted []Entry
+}
+
+// Scan returns the entries of the cache directory. A missing directory is treated as an empty cache.
+func Scan(dir string) ([]Entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	entries := make(map[string]*Entry)
+	var names []string
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			// the file was removed after listing the directory
+			continue
+		}
+
+		name := strings.TrimSuffix(file.Name(), ".json")
+		entry, ok := entries[name]
+		if !ok {
+			entry = &Entry{Name: name}
+			entries[name] = entry
+			names = append(names, name)
+		}
+
+		entry.Files = append(entry.Files, filepath.Join(dir, file.Name()))
+		entry.Size += info.Size()
+		if info.ModTime().After(entry.ModTime) {
+			entry.ModTime = info.ModTime()
+		}
+		if atime := accessTime(info); atime.After(entry.AccessTime) {
+			entry.AccessTime = atime
+		}
+	}
+
+	result := make([]Entry, 0, len(names))
+	for _, name := range names {
+		result = append(result, *entries[name])
+	}
+
+	return result, nil
+}
+
+// GetStats returns the number of entries and total size of the cache directory.
+func GetStats(dir string) (Stats, error) {
+	entries, err := Scan(dir)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return statsOf(entries), nil
+}
+
+func statsOf(entries []Entry) Stats {
+	stats := Stats{Entries: len(entries)}
+	for _, entry := range entries {
+		stats.Size += entry.Size
+	}
+
+	return stats
+}
+
+// Collect evicts the cache entries older than the TTL, then the least recently used (or oldest)
+// entries until the cache fits in the maximum size.
+func Collect(dir string, cfg Config) (*Result, error) {
+	entries, err := Scan(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	lastUsed := func(entry Entry) time.Time {
+		if cfg.Policy == PolicyAge {
+			return entry.ModTime
+		}
+		return entry.AccessTime
+	}
+
+	// oldest entries first
+	sort.SliceStable(entries, func(i, j int) bool {
+		return lastUsed(entries[i]).Before(lastUsed(entries[j]))
+	})
+
+	result := &Result{Before: statsOf(entries)}
+	size := result.Before.Size
+	now := time.Now()
+
+	var kept []Entry
+	for _, entry := range entries {
+		expired := cfg.TTL > 0 && now.Sub(lastUsed(entry)) > cfg.TTL
+		oversize := cfg.MaxSize > 0 && size > cfg.MaxSize
+
+		if !expired && !oversize {
+			kept = append(kept, entry)
+			continue
+		}
+
+		if !cfg.DryRun {
+			if err := remove(entry); err != nil {
+				return nil, err
+			}
+		}
+
+		slog.Debug("Evicted cache entry", "name", entry.Name, "size", entry.Size, "expired", expired, "dry_run", cfg.DryRun)
+
+		size -= entry.Size
+		result.Evicted = append(result.Evicted, entry)
+	}
+
+	result.After = statsOf(kept)
+
+	return result, nil
+}
+
+func remove(entry Entry) error {
+	for _, file := range entry.Files {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove cache entry %s: %w", entry.Name, err)
+		}
+	}
+
+	return nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	value  int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+// FormatSize returns the size with a binary unit suffix, e.g. 1.5MiB.
+func FormatSize(size int64) string {
+	// binary units, from KiB to TiB
+	units := sizeUnits[:4]
+	for i := len(units) - 1; i >= 0; i-- {
+		if size >= units[i].value {
+			return strconv.FormatFloat(float64(size)/float64(units[i].value), 'f', 1, 64) + units[i].suffix
+		}
+	}
+
+	return strconv.FormatInt(size, 10) + "B"
+}
+
+// ParseSize parses a size in bytes with an optional unit suffix (e.g. 512MiB, 10GB, 2G).
+func ParseSize(value string) (int64, error) {
+	original := value
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(unit.suffix)) {
+			multiplier = unit.value
+			value = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %s", original)
+	}
+
+	return int64(size * float64(multiplier)), nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// writeEntry writes the tarball and manifest of a cache entry, 100 bytes in total.
+func writeEntry(t *testing.T, dir, name string, modTime, accessTime time.Time) {
+	t.Helper()
+
+	for file, size := range map[string]int{name: 80, name + ".json": 20} {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, accessTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		wantEvicted []string
+		// the access time is only read on linux
+		lru bool
+	}{
+		{name: "no limits", cfg: Config{Policy: PolicyLRU}},
+		{name: "lru size", cfg: Config{Policy: PolicyLRU, MaxSize: 200}, wantEvicted: []string{"sha256:b"}, lru: true},
+		{name: "lru size twice", cfg: Config{Policy: PolicyLRU, MaxSize: 150}, wantEvicted: []string{"sha256:b", "sha256:c"}, lru: true},
+		{name: "lru ttl", cfg: Config{Policy: PolicyLRU, TTL: 48 * time.Hour}, wantEvicted: []string{"sha256:b"}, lru: true},
+		{name: "age size", cfg: Config{Policy: PolicyAge, MaxSize: 200}, wantEvicted: []string{"sha256:a"}},
+		{name: "age ttl", cfg: Config{Policy: PolicyAge, TTL: 24 * time.Hour}, wantEvicted: []string{"sha256:a", "sha256:b"}},
+		{name: "size and ttl", cfg: Config{Policy: PolicyAge, MaxSize: 250, TTL: 5 * 24 * time.Hour}, wantEvicted: []string{"sha256:a"}},
+		{name: "size fits", cfg: Config{Policy: PolicyAge, MaxSize: 300}},
+		{name: "dry run", cfg: Config{Policy: PolicyAge, MaxSize: 200, DryRun: true}, wantEvicted: []string{"sha256:a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.lru && runtime.GOOS != "linux" {
+				t.Skip("access time not available")
+			}
+
+			dir := t.TempDir()
+			now := time.Now()
+
+			// a is the oldest entry but was used recently, b is the least recently used one
+			writeEntry(t, dir, "sha256:a", now.Add(-10*24*time.Hour), now.Add(-time.Hour))
+			writeEntry(t, dir, "sha256:b", now.Add(-2*24*time.Hour), now.Add(-3*24*time.Hour))
+			writeEntry(t, dir, "sha256:c", now.Add(-time.Hour), now.Add(-2*time.Hour))
+
+			result, err := Collect(dir, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var evicted []string
+			for _, entry := range result.Evicted {
+				evicted = append(evicted, entry.Name)
+			}
+			if !reflect.DeepEqual(evicted, tt.wantEvicted) {
+				t.Errorf("evicted = %v, want %v", evicted, tt.wantEvicted)
+			}
+
+			kept := 3 - len(tt.wantEvicted)
+			if want := (Stats{Entries: 3, Size: 300}); result.Before != want {
+				t.Errorf("before = %+v, want %+v", result.Before, want)
+			}
+			if want := (Stats{Entries: kept, Size: int64(kept) * 100}); result.After != want {
+				t.Errorf("after = %+v, want %+v", result.After, want)
+			}
+
+			stats, err := GetStats(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the dry run only reports the entries
+			if tt.cfg.DryRun {
+				kept = 3
+			}
+			if stats.Entries != kept {
+				t.Errorf("entries left = %d, want %d", stats.Entries, kept)
+			}
+		})
+	}
+}
+
+func TestCollectMissingDir(t *testing.T) {
+	result, err := Collect(filepath.Join(t.TempDir(), "cache"), Config{MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Before.Entries != 0 || len(result.Evicted) != 0 {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "1024", want: 1024},
+		{value: "512B", want: 512},
+		{value: "512MiB", want: 512 << 20},
+		{value: "1.5GiB", want: 3 << 29},
+		{value: "10GB", want: 10e9},
+		{value: "2G", want: 2 << 30},
+		{value: " 4 kib ", want: 4096},
+		{value: "1tb", want: 1e12},
+		{value: "-1G", wantErr: true},
+		{value: "big", wantErr: true},
+		{value: "10 PiB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KiB"},
+		{3 << 19, "1.5MiB"},
+		{10e9, "9.3GiB"},
+		{2 << 40, "2.0TiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatSize(tt.size); got != tt.want {
+			t.Errorf("FormatSize(%d) = %s, want %s", tt.size, got, tt.want)
+		}
+
+		// the formatted size is parsed back, rounded to the decimal shown
+		if _, err := ParseSize(FormatSize(tt.size)); err != nil {
+			t.Errorf("ParseSize(FormatSize(%d)): %v", tt.size, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package crane
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// LayerInfo describes a layer of an image.
+type LayerInfo struct {
+	Digest string
+	// compressed size of the layer
+	Size int64
+	// instruction that created the layer, from the history of the image
+	CreatedBy string
+}
+
+// ImageDiff has the size change between two images and the layers that differ.
+type ImageDiff struct {
+	BaseSize int64
+	Size     int64
+	Added    []LayerInfo
+	Removed  []LayerInfo
+}
+
+// Delta returns the size change, negative if the image shrank.
+func (d *ImageDiff) Delta() int64 {
+	return d.Size - d.BaseSize
+}
+
+// Growth returns the size change as a percentage of the base image size.
+func (d *ImageDiff) Growth() float64 {
+	if d.BaseSize == 0 {
+		return 0
+	}
+
+	return float64(d.Delta()) * 100 / float64(d.BaseSize)
+}
+
+// Layers returns the layers of the image and its compressed size, as the sum of its config and
+// layers. Each layer is matched with its history entry, ignoring the entries of instructions
+// that don't create a layer.
+func Layers(img v1.Image) ([]LayerInfo, int64, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read the image manifest: %w", err)
+	}
+
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read the image config: %w", err)
+	}
+
+	var history []v1.History
+	for _, entry := range config.History {
+		if !entry.EmptyLayer {
+			history = append(history, entry)
+		}
+	}
+
+	// the history is unreliable if it doesn't describe every layer
+	if len(history) != len(manifest.Layers) {
+		history = nil
+	}
+
+	size := manifest.Config.Size
+	layers := make([]LayerInfo, 0, len(manifest.Layers))
+
+	for i, layer := range manifest.Layers {
+		info := LayerInfo{Digest: layer.Digest.String(), Size: layer.Size}
+		if history != nil {
+			info.CreatedBy = history[i].CreatedBy
+		}
+
+		layers = append(layers, info)
+		size += layer.Size
+	}
+
+	return layers, size, nil
+}
+
+// DiffImages compares the image with a base image, usually its previous release. Layers are
+// compared by digest so a layer rebuilt with the same content isn't reported.
+func DiffImages(base, img v1.Image) (*ImageDiff, error) {
+	baseLayers, baseSize, err := Layers(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the base image: %w", err)
+	}
+
+	layers, size, err := Layers(img)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ImageDiff{BaseSize: baseSize, Size: size}
+
+	remaining := make(map[string]int, len(baseLayers))
+	for _, layer := range baseLayers {
+		remaining[layer.Digest]++
+	}
+
+	for _, layer := range layers {
+		if remaining[layer.Digest] > 0 {
+			remaining[layer.Digest]--
+			continue
+		}
+		diff.Added = append(diff.Added, layer)
+	}
+
+	for _, layer := range baseLayers {
+		if remaining[layer.Digest] > 0 {
+			remaining[layer.Digest]--
+			diff.Removed = append(diff.Removed, layer)
+		}
+	}
+
+	return diff, nil
+}
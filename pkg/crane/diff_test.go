@@ -0,0 +1,262 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package crane
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// testLayer is a random layer with the instruction that created it.
+type testLayer struct {
+	layer     v1.Layer
+	createdBy string
+}
+
+func newTestLayer(t *testing.T, size int64, createdBy string) testLayer {
+	t.Helper()
+
+	layer, err := random.Layer(size, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testLayer{layer: layer, createdBy: createdBy}
+}
+
+// info returns the expected description of the layer.
+func (l testLayer) info(t *testing.T, withHistory bool) LayerInfo {
+	t.Helper()
+
+	digest, err := l.layer.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := l.layer.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := LayerInfo{Digest: digest.String(), Size: size}
+	if withHistory {
+		info.CreatedBy = l.createdBy
+	}
+
+	return info
+}
+
+// newTestImage builds an image with the layers, with an ENV instruction that doesn't create a
+// layer in the history after the first one.
+func newTestImage(t *testing.T, layers ...testLayer) v1.Image {
+	t.Helper()
+
+	img := empty.Image
+
+	for idx, layer := range layers {
+		var err error
+
+		img, err = mutate.Append(img, mutate.Addendum{
+			Layer:   layer.layer,
+			History: v1.History{CreatedBy: layer.createdBy},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if idx > 0 {
+			continue
+		}
+
+		config, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config = config.DeepCopy()
+		config.History = append(config.History, v1.History{CreatedBy: "ENV PATH=/usr/local/bin", EmptyLayer: true})
+
+		if img, err = mutate.ConfigFile(img, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return img
+}
+
+func imageSize(t *testing.T, img v1.Image) int64 {
+	t.Helper()
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+
+	return size
+}
+
+func TestLayers(t *testing.T) {
+	base := newTestLayer(t, 1024, "ADD rootfs.tar.gz /")
+	app := newTestLayer(t, 2048, "COPY app /usr/local/bin/app")
+
+	img := newTestImage(t, base, app)
+
+	layers, size, err := Layers(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []LayerInfo{base.info(t, true), app.info(t, true)}
+	if !reflect.DeepEqual(layers, want) {
+		t.Errorf("layers = %v, want %v", layers, want)
+	}
+
+	if want := imageSize(t, img); size != want {
+		t.Errorf("size = %d, want %d", size, want)
+	}
+}
+
+func TestLayersWithoutHistory(t *testing.T) {
+	layer := newTestLayer(t, 1024, "")
+
+	img, err := mutate.AppendLayers(empty.Image, layer.layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the history doesn't describe the layer, so it isn't used
+	config, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = config.DeepCopy()
+	config.History = nil
+	if img, err = mutate.ConfigFile(img, config); err != nil {
+		t.Fatal(err)
+	}
+
+	layers, _, err := Layers(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []LayerInfo{layer.info(t, false)}; !reflect.DeepEqual(layers, want) {
+		t.Errorf("layers = %v, want %v", layers, want)
+	}
+}
+
+func TestDiffImages(t *testing.T) {
+	rootfs := newTestLayer(t, 4096, "ADD rootfs.tar.gz /")
+	packages := newTestLayer(t, 2048, "RUN apk add ca-certificates")
+	oldApp := newTestLayer(t, 1024, "COPY app /usr/local/bin/app")
+	newApp := newTestLayer(t, 3072, "COPY app /usr/local/bin/app")
+	config := newTestLayer(t, 512, "COPY config.yaml /etc/app/")
+
+	tests := []struct {
+		name        string
+		base, image []testLayer
+		wantAdded   []testLayer
+		wantRemoved []testLayer
+	}{
+		{
+			name:  "same layers",
+			base:  []testLayer{rootfs, packages, oldApp},
+			image: []testLayer{rootfs, packages, oldApp},
+		},
+		{
+			name:        "replaced and added layers",
+			base:        []testLayer{rootfs, packages, oldApp},
+			image:       []testLayer{rootfs, packages, newApp, config},
+			wantAdded:   []testLayer{newApp, config},
+			wantRemoved: []testLayer{oldApp},
+		},
+		{
+			name:        "removed layers",
+			base:        []testLayer{rootfs, packages, oldApp},
+			image:       []testLayer{rootfs, oldApp},
+			wantRemoved: []testLayer{packages},
+		},
+		{
+			name:        "repeated layer",
+			base:        []testLayer{rootfs, config, config},
+			image:       []testLayer{rootfs, config},
+			wantRemoved: []testLayer{config},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := newTestImage(t, tt.base...)
+			img := newTestImage(t, tt.image...)
+
+			diff, err := DiffImages(base, img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			layerInfos := func(layers []testLayer) []LayerInfo {
+				var infos []LayerInfo
+				for _, layer := range layers {
+					infos = append(infos, layer.info(t, true))
+				}
+				return infos
+			}
+
+			if want := layerInfos(tt.wantAdded); !reflect.DeepEqual(diff.Added, want) {
+				t.Errorf("added = %v, want %v", diff.Added, want)
+			}
+			if want := layerInfos(tt.wantRemoved); !reflect.DeepEqual(diff.Removed, want) {
+				t.Errorf("removed = %v, want %v", diff.Removed, want)
+			}
+
+			baseSize, size := imageSize(t, base), imageSize(t, img)
+			if diff.BaseSize != baseSize || diff.Size != size {
+				t.Errorf("sizes = %d -> %d, want %d -> %d", diff.BaseSize, diff.Size, baseSize, size)
+			}
+
+			if diff.Delta() != size-baseSize {
+				t.Errorf("delta = %d, want %d", diff.Delta(), size-baseSize)
+			}
+
+			wantGrowth := float64(size-baseSize) * 100 / float64(baseSize)
+			if math.Abs(diff.Growth()-wantGrowth) > 1e-9 {
+				t.Errorf("growth = %f, want %f", diff.Growth(), wantGrowth)
+			}
+		})
+	}
+}
+
+func TestImageDiffGrowth(t *testing.T) {
+	tests := []struct {
+		diff       ImageDiff
+		wantDelta  int64
+		wantGrowth float64
+	}{
+		{ImageDiff{BaseSize: 1000, Size: 1250}, 250, 25},
+		{ImageDiff{BaseSize: 1000, Size: 900}, -100, -10},
+		{ImageDiff{BaseSize: 0, Size: 900}, 900, 0},
+	}
+
+	for _, tt := range tests {
+		if delta := tt.diff.Delta(); delta != tt.wantDelta {
+			t.Errorf("%d -> %d: delta = %d, want %d", tt.diff.BaseSize, tt.diff.Size, delta, tt.wantDelta)
+		}
+		if growth := tt.diff.Growth(); growth != tt.wantGrowth {
+			t.Errorf("%d -> %d: growth = %f, want %f", tt.diff.BaseSize, tt.diff.Size, growth, tt.wantGrowth)
+		}
+	}
+}
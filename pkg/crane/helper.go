@@ -5,18 +5,34 @@
 package crane
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"os"
+	"strings"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 )
 
 type config struct {
-	UseDigest bool
+	Context             context.Context
+	UseDigest           bool
+	Insecure            bool
+	Platform            *v1.Platform
+	Jobs                int
+	Auth                authn.Authenticator
+	Transport           http.RoundTripper
+	IgnoreImmutableTags bool
 }
 
 type Option func(settings *config)
@@ -27,12 +43,219 @@ func WithDigest() Option {
 	}
 }
 
-func Push(file string, opts ...Option) (string, error) {
+func WithInsecure() Option {
+	return func(settings *config) {
+		settings.Insecure = true
+	}
+}
+
+func WithPlatform(platform *v1.Platform) Option {
+	return func(settings *config) {
+		settings.Platform = platform
+	}
+}
+
+func WithContext(ctx context.Context) Option {
+	return func(settings *config) {
+		settings.Context = ctx
+	}
+}
+
+func WithJobs(jobs int) Option {
+	return func(settings *config) {
+		settings.Jobs = jobs
+	}
+}
+
+// WithAuth uses the given credentials instead of the ones of the docker config.
+func WithAuth(username, password string) Option {
+	return func(settings *config) {
+		settings.Auth = &authn.Basic{Username: username, Password: password}
+	}
+}
+
+// WithTransport uses the given transport to reach the registry, e.g. with custom TLS settings.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(settings *config) {
+		settings.Transport = transport
+	}
+}
+
+// WithIgnoreImmutableTags skips the tags that cannot be pushed because they already exist in a
+// repository with immutable tags.
+func WithIgnoreImmutableTags() Option {
+	return func(settings *config) {
+		settings.IgnoreImmutableTags = true
+	}
+}
+
+func newConfig(opts []Option) *config {
 	cfg := &config{}
 	for _, opt := range opts {
 		opt(cfg)
 	}
 
+	return cfg
+}
+
+func (c *config) craneOptions() []crane.Option {
+	var opts []crane.Option
+	if c.Context != nil {
+		opts = append(opts, crane.WithContext(c.Context))
+	}
+	if c.Insecure {
+		opts = append(opts, crane.Insecure)
+	}
+	if c.Platform != nil {
+		opts = append(opts, crane.WithPlatform(c.Platform))
+	}
+	if c.Jobs > 0 {
+		opts = append(opts, crane.WithJobs(c.Jobs))
+	}
+	if c.Auth != nil {
+		opts = append(opts, crane.WithAuth(c.Auth))
+	}
+	if c.Transport != nil {
+		opts = append(opts, crane.WithTransport(c.Transport))
+	}
+
+	return opts
+}
+
+// Digest returns the digest of a remote image. If a platform is set then the digest of the
+// image for that platform is returned instead of the one from the index.
+func Digest(ref string, opts ...Option) (string, error) {
+	cfg := newConfig(opts)
+
+	digest, err := crane.Digest(ref, cfg.craneOptions()...)
+	if err != nil {
+		return "", fmt.Errorf("failed to get digest of %s: %w", ref, err)
+	}
+
+	return digest, nil
+}
+
+// ListTags returns the tags of a repository. A repository that doesn't exist yet has no tags.
+func ListTags(repo string, opts ...Option) ([]string, error) {
+	cfg := newConfig(opts)
+
+	tags, err := crane.ListTags(repo, cfg.craneOptions()...)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list tags of %s: %w", repo, err)
+	}
+
+	return tags, nil
+}
+
+// IsNotFound checks if the registry returned that the image or repository doesn't exist.
+func IsNotFound(err error) bool {
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.StatusCode == http.StatusNotFound
+	}
+
+	return false
+}
+
+// IsImmutableTag checks if the registry rejected the push because the tag already exists and
+// the repository doesn't allow overwriting it.
+func IsImmutableTag(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(terr.Error()), "immutable")
+}
+
+// ImageSize returns the compressed size of a remote image, as the sum of its config and layers.
+func ImageSize(ref string, opts ...Option) (int64, error) {
+	cfg := newConfig(opts)
+
+	data, err := crane.Manifest(ref, cfg.craneOptions()...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get manifest of %s: %w", ref, err)
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse manifest of %s: %w", ref, err)
+	}
+
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+
+	return size, nil
+}
+
+// Image returns a remote image. If a platform is set then the image for that platform is
+// returned when the reference points to an index.
+func Image(ref string, opts ...Option) (v1.Image, error) {
+	cfg := newConfig(opts)
+
+	img, err := crane.Pull(ref, cfg.craneOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %s: %w", ref, err)
+	}
+
+	return img, nil
+}
+
+// LoadTarball returns the image saved in a tarball with a single image.
+func LoadTarball(file string) (v1.Image, error) {
+	img, err := tarball.ImageFromPath(file, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image from %s: %w", file, err)
+	}
+
+	return img, nil
+}
+
+// LoadLayout returns an image saved in an OCI layout. The image of the platform is returned if
+// set and present, otherwise the first image of the layout.
+func LoadLayout(path string, opts ...Option) (v1.Image, error) {
+	cfg := newConfig(opts)
+
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load layout %s: %w", path, err)
+	}
+
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index of layout %s: %w", path, err)
+	}
+
+	if len(manifest.Manifests) == 0 {
+		return nil, fmt.Errorf("layout %s has no images", path)
+	}
+
+	descriptor := manifest.Manifests[0]
+	if cfg.Platform != nil {
+		for _, desc := range manifest.Manifests {
+			if desc.Platform != nil && desc.Platform.Satisfies(*cfg.Platform) {
+				descriptor = desc
+				break
+			}
+		}
+	}
+
+	img, err := index.Image(descriptor.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image %s from layout %s: %w", descriptor.Digest, path, err)
+	}
+
+	return img, nil
+}
+
+func Push(file string, opts ...Option) (string, error) {
+	cfg := newConfig(opts)
+
 	manifest, err := tarball.LoadManifest(pathOpener(file))
 	if err != nil {
 		return "", fmt.Errorf("failed to load manifest: %w", err)
@@ -83,7 +306,11 @@ func Push(file string, opts ...Option) (string, error) {
 			target = fmt.Sprintf(repoName + ":" + tag.TagStr())
 		}
 
-		if err = crane.Push(img, target); err != nil {
+		if err = crane.Push(img, target, cfg.craneOptions()...); err != nil {
+			if cfg.IgnoreImmutableTags && IsImmutableTag(err) {
+				slog.Warn("Skipping immutable tag", "target", target, "error", err)
+				continue
+			}
 			return "", fmt.Errorf("failed to push image %s: %w", tag.String(), err)
 		}
 
@@ -0,0 +1,225 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package crane
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/sync/errgroup"
+)
+
+// PruneConfig selects the tags to delete from a repository.
+type PruneConfig struct {
+	// MaxAge deletes the tags created before this duration, zero disables it.
+	MaxAge time.Duration
+	// Keep is the maximum number of tags to keep, newest first, zero disables it.
+	Keep   int
+	DryRun bool
+}
+
+// TagInfo describes a tag of a repository.
+type TagInfo struct {
+	Tag     string
+	Digest  string
+	Created time.Time
+}
+
+// PruneResult has the tags that were kept and deleted.
+type PruneResult struct {
+	Kept    []TagInfo
+	Deleted []TagInfo
+	// Shared are the tags selected for deletion that remain because a kept tag has the same digest.
+	Shared []TagInfo
+}
+
+// Prune deletes the tags of a repository that are older than the max age or beyond the
+// number of tags to keep. Tags are deleted by digest, so a digest is only deleted if none
+// of the tags pointing to it are kept.
+func Prune(repo string, pruneCfg PruneConfig, opts ...Option) (*PruneResult, error) {
+	cfg := newConfig(opts)
+
+	tags, err := ListTags(repo, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := inspectTags(repo, tags, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sortNewest(infos)
+
+	result := &PruneResult{}
+	keptDigests := make(map[string]bool)
+	now := time.Now()
+
+	var expiredTags []TagInfo
+
+	for idx, info := range infos {
+		// images without a creation time are only pruned by count
+		expired := pruneCfg.MaxAge > 0 && !info.Created.IsZero() && now.Sub(info.Created) > pruneCfg.MaxAge
+		exceeded := pruneCfg.Keep > 0 && idx >= pruneCfg.Keep
+
+		if expired || exceeded {
+			expiredTags = append(expiredTags, info)
+		} else {
+			result.Kept = append(result.Kept, info)
+			keptDigests[info.Digest] = true
+		}
+	}
+
+	deleted := make(map[string]bool)
+	for _, info := range expiredTags {
+		if keptDigests[info.Digest] {
+			result.Shared = append(result.Shared, info)
+			continue
+		}
+
+		// deleting the digest removes every tag pointing to it
+		result.Deleted = append(result.Deleted, info)
+		if deleted[info.Digest] {
+			continue
+		}
+		deleted[info.Digest] = true
+
+		slog.Debug("Deleting tag", "repo", repo, "tag", info.Tag, "digest", info.Digest, "created", info.Created, "dry_run", pruneCfg.DryRun)
+
+		if pruneCfg.DryRun {
+			continue
+		}
+
+		if err := crane.Delete(repo+"@"+info.Digest, cfg.craneOptions()...); err != nil {
+			return nil, fmt.Errorf("failed to delete %s:%s: %w", repo, info.Tag, err)
+		}
+	}
+
+	return result, nil
+}
+
+// sortNewest sorts the tags newest first, by name if they were created at the same time so the
+// selected tags of a digest don't depend on the order of the inspection.
+func sortNewest(infos []TagInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].Created.Equal(infos[j].Created) {
+			return infos[i].Created.After(infos[j].Created)
+		}
+		return infos[i].Tag < infos[j].Tag
+	})
+}
+
+// inspectTags reads the digest and creation time of every tag.
+func inspectTags(repo string, tags []string, cfg *config) ([]TagInfo, error) {
+	jobs := cfg.Jobs
+	if jobs <= 0 {
+		jobs = 4
+	}
+
+	group := new(errgroup.Group)
+	group.SetLimit(jobs)
+
+	var mu sync.Mutex
+	infos := make([]TagInfo, 0, len(tags))
+
+	for _, tag := range tags {
+		group.Go(func() error {
+			ref := repo + ":" + tag
+
+			digest, err := crane.Digest(ref, cfg.craneOptions()...)
+			if err != nil {
+				return fmt.Errorf("failed to get digest of %s: %w", ref, err)
+			}
+
+			configFile, err := crane.Config(ref, cfg.craneOptions()...)
+			if err != nil {
+				return fmt.Errorf("failed to get config of %s: %w", ref, err)
+			}
+
+			parsed, err := v1.ParseConfigFile(bytes.NewReader(configFile))
+			if err != nil {
+				return fmt.Errorf("failed to parse config of %s: %w", ref, err)
+			}
+
+			mu.Lock()
+			infos = append(infos, TagInfo{Tag: tag, Digest: digest, Created: parsed.Created.Time})
+			mu.Unlock()
+
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+// CopyConfig selects the tags copied from a repository.
+type CopyConfig struct {
+	// MaxAge skips the tags created before this duration, zero disables it.
+	MaxAge time.Duration
+	// Limit is the maximum number of tags to copy, newest first, zero disables it.
+	Limit int
+}
+
+// CopyNewest copies the newest tags of the source repository to the destination, skipping the
+// ones older than the max age or beyond the limit. It returns the copied tags.
+func CopyNewest(src, dst string, copyCfg CopyConfig, opts ...Option) ([]string, error) {
+	cfg := newConfig(opts)
+
+	tags, err := ListTags(src, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := inspectTags(src, tags, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sortNewest(infos)
+
+	now := time.Now()
+
+	var selected []string
+	for _, info := range infos {
+		if copyCfg.Limit > 0 && len(selected) >= copyCfg.Limit {
+			break
+		}
+
+		// images without a creation time are only limited by count
+		if copyCfg.MaxAge > 0 && !info.Created.IsZero() && now.Sub(info.Created) > copyCfg.MaxAge {
+			continue
+		}
+
+		selected = append(selected, info.Tag)
+	}
+
+	group := new(errgroup.Group)
+	group.SetLimit(max(cfg.Jobs, 1))
+
+	for _, tag := range selected {
+		group.Go(func() error {
+			if err := crane.Copy(src+":"+tag, dst+":"+tag, cfg.craneOptions()...); err != nil {
+				return fmt.Errorf("failed to copy %s:%s to %s: %w", src, tag, dst, err)
+			}
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	return selected, nil
+}
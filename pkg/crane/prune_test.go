@@ -0,0 +1,233 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package crane
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// testRegistry is a registry that records the deleted digests instead of deleting them, as the
+// test registry only deletes manifests pushed by digest.
+type testRegistry struct {
+	handler http.Handler
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodDelete {
+		r.mu.Lock()
+		r.deleted = append(r.deleted, req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:])
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	r.handler.ServeHTTP(w, req)
+}
+
+// pushImage pushes a random image created at the given time under every tag and returns its digest.
+func pushImage(t *testing.T, repo string, created time.Time, tags ...string) string {
+	t.Helper()
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err = mutate.CreatedAt(img, v1.Time{Time: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range tags {
+		ref, err := name.NewTag(repo + ":" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return digest.String()
+}
+
+func tagNames(infos []TagInfo) []string {
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Tag)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		config      PruneConfig
+		wantKept    []string
+		wantDeleted []string
+		wantShared  []string
+		// images whose digest is deleted
+		wantDigests []string
+	}{
+		{
+			name:        "keep last",
+			config:      PruneConfig{Keep: 3},
+			wantKept:    []string{"latest", "v2", "v3"},
+			wantDeleted: []string{"base", "v1", "v1-old"},
+			wantDigests: []string{"base", "v1"},
+		},
+		{
+			name:        "keep last with shared digest",
+			config:      PruneConfig{Keep: 1},
+			wantKept:    []string{"latest"},
+			wantDeleted: []string{"base", "v1", "v1-old", "v2"},
+			wantShared:  []string{"v3"},
+			wantDigests: []string{"base", "v1", "v2"},
+		},
+		{
+			name:        "max age",
+			config:      PruneConfig{MaxAge: 36 * time.Hour},
+			wantKept:    []string{"base", "latest", "v2", "v3"},
+			wantDeleted: []string{"v1", "v1-old"},
+			wantDigests: []string{"v1"},
+		},
+		{
+			name:        "max age and keep last",
+			config:      PruneConfig{MaxAge: 36 * time.Hour, Keep: 2},
+			wantKept:    []string{"latest", "v3"},
+			wantDeleted: []string{"base", "v1", "v1-old", "v2"},
+			wantDigests: []string{"base", "v1", "v2"},
+		},
+		{
+			name:        "dry run",
+			config:      PruneConfig{MaxAge: 36 * time.Hour, DryRun: true},
+			wantKept:    []string{"base", "latest", "v2", "v3"},
+			wantDeleted: []string{"v1", "v1-old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := &testRegistry{handler: registry.New(registry.Logger(log.New(io.Discard, "", 0)))}
+			server := httptest.NewServer(reg)
+			defer server.Close()
+
+			repo := strings.TrimPrefix(server.URL, "http://") + "/app/cache"
+
+			digests := map[string]string{
+				"v1": pushImage(t, repo, now.Add(-72*time.Hour), "v1", "v1-old"),
+				"v2": pushImage(t, repo, now.Add(-24*time.Hour), "v2"),
+				"v3": pushImage(t, repo, now.Add(-time.Hour), "v3", "latest"),
+			}
+			// images without a creation time are the oldest, but never expire
+			digests["base"] = pushImage(t, repo, time.Time{}, "base")
+
+			result, err := Prune(repo, tt.config, WithInsecure(), WithJobs(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := tagNames(result.Kept); !reflect.DeepEqual(got, tt.wantKept) {
+				t.Errorf("kept = %q, want %q", got, tt.wantKept)
+			}
+			if got := tagNames(result.Deleted); !reflect.DeepEqual(got, tt.wantDeleted) {
+				t.Errorf("deleted = %q, want %q", got, tt.wantDeleted)
+			}
+			if got := tagNames(result.Shared); !reflect.DeepEqual(got, tt.wantShared) {
+				t.Errorf("shared = %q, want %q", got, tt.wantShared)
+			}
+
+			var wantDeleted []string
+			for _, image := range tt.wantDigests {
+				wantDeleted = append(wantDeleted, digests[image])
+			}
+			sort.Strings(wantDeleted)
+			sort.Strings(reg.deleted)
+
+			if !reflect.DeepEqual(reg.deleted, wantDeleted) {
+				t.Errorf("deleted digests = %q, want %q", reg.deleted, wantDeleted)
+			}
+		})
+	}
+}
+
+func TestCopyNewest(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		config CopyConfig
+		want   []string
+	}{
+		{name: "all", want: []string{"base", "latest", "v1", "v1-old", "v2", "v3"}},
+		{name: "limit", config: CopyConfig{Limit: 3}, want: []string{"latest", "v2", "v3"}},
+		{name: "max age", config: CopyConfig{MaxAge: 36 * time.Hour}, want: []string{"base", "latest", "v2", "v3"}},
+		{name: "max age and limit", config: CopyConfig{MaxAge: 36 * time.Hour, Limit: 1}, want: []string{"latest"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+			defer server.Close()
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			src, dst := host+"/app/cache", host+"/app/cache/feature"
+
+			pushImage(t, src, now.Add(-72*time.Hour), "v1", "v1-old")
+			pushImage(t, src, now.Add(-24*time.Hour), "v2")
+			pushImage(t, src, now.Add(-time.Hour), "v3", "latest")
+			// images without a creation time are only limited by count
+			pushImage(t, src, time.Time{}, "base")
+
+			copied, err := CopyNewest(src, dst, tt.config, WithInsecure(), WithJobs(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(copied)
+
+			if !reflect.DeepEqual(copied, tt.want) {
+				t.Errorf("copied = %q, want %q", copied, tt.want)
+			}
+
+			tags, err := ListTags(dst, WithInsecure())
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(tags)
+
+			if !reflect.DeepEqual(tags, tt.want) {
+				t.Errorf("destination tags = %q, want %q", tags, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package crane
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Replicate copies the image or index of the source reference, with every child manifest, to the
+// destination repository under the given tags, or by digest if there are no tags. The source and
+// destination have their own options so each one can use different credentials. The digest of
+// the copy is checked against the source and returned.
+func Replicate(src, dst string, tags []string, srcOpts, dstOpts []Option) (string, error) {
+	srcOptions := crane.GetOptions(newConfig(srcOpts).craneOptions()...)
+	dstOptions := crane.GetOptions(newConfig(dstOpts).craneOptions()...)
+
+	srcRef, err := name.ParseReference(src, srcOptions.Name...)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse reference %s: %w", src, err)
+	}
+
+	repo, err := name.NewRepository(dst, dstOptions.Name...)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse repository %s: %w", dst, err)
+	}
+
+	desc, err := remote.Get(srcRef, srcOptions.Remote...)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %w", src, err)
+	}
+
+	digest := desc.Digest.String()
+
+	refs := make([]name.Reference, 0, max(len(tags), 1))
+	for _, tag := range tags {
+		refs = append(refs, repo.Tag(tag))
+	}
+	if len(refs) == 0 {
+		refs = append(refs, repo.Digest(digest))
+	}
+
+	var taggable remote.Taggable
+
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return "", fmt.Errorf("failed to read index %s: %w", src, err)
+		}
+
+		if err := remote.WriteIndex(refs[0], index, dstOptions.Remote...); err != nil {
+			return "", fmt.Errorf("failed to push index %s: %w", refs[0], err)
+		}
+		taggable = index
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return "", fmt.Errorf("failed to read image %s: %w", src, err)
+		}
+
+		if err := remote.Write(refs[0], img, dstOptions.Remote...); err != nil {
+			return "", fmt.Errorf("failed to push image %s: %w", refs[0], err)
+		}
+		taggable = img
+	}
+
+	// the content is already there, the other tags only need the manifest
+	for _, ref := range refs[1:] {
+		if err := remote.Tag(ref.(name.Tag), taggable, dstOptions.Remote...); err != nil {
+			return "", fmt.Errorf("failed to tag %s: %w", ref, err)
+		}
+	}
+
+	for _, ref := range refs {
+		copied, err := remote.Head(ref, dstOptions.Remote...)
+		if err != nil {
+			return "", fmt.Errorf("failed to verify %s: %w", ref, err)
+		}
+
+		if copied.Digest.String() != digest {
+			return "", fmt.Errorf("digest mismatch for %s: expected %s, got %s", ref, digest, copied.Digest)
+		}
+
+		slog.Info("Image replicated to mirror", "target", ref.String(), "digest", digest)
+	}
+
+	return digest, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package crane
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestReplicate(t *testing.T) {
+	source := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer source.Close()
+
+	mirror := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer mirror.Close()
+
+	srcRepo := strings.TrimPrefix(source.URL, "http://") + "/app"
+	dstRepo := strings.TrimPrefix(mirror.URL, "http://") + "/mirror/app"
+
+	index, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcRef, err := name.NewTag(srcRepo + ":1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := remote.WriteIndex(srcRef, index); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := index.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := Replicate(srcRef.String(), dstRepo, []string{"1.0", "latest"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != want.String() {
+		t.Errorf("digest = %s, want %s", digest, want)
+	}
+
+	for _, tag := range []string{"1.0", "latest"} {
+		ref, err := name.NewTag(dstRepo + ":" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		copied, err := remote.Index(ref)
+		if err != nil {
+			t.Fatalf("index %s not replicated: %v", tag, err)
+		}
+
+		manifest, err := copied.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the child images are copied with the index
+		for _, child := range manifest.Manifests {
+			if _, err := remote.Image(ref.Context().Digest(child.Digest.String())); err != nil {
+				t.Errorf("child %s not replicated: %v", child.Digest, err)
+			}
+		}
+	}
+
+	// an image without tags is copied by digest
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imgRef, err := name.NewTag(srcRepo + ":single")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := remote.Write(imgRef, img); err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err = Replicate(imgRef.String(), dstRepo, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := name.NewDigest(dstRepo + "@" + digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := remote.Image(copied); err != nil {
+		t.Errorf("image not replicated by digest: %v", err)
+	}
+}
+
+func TestReplicateDigestMismatch(t *testing.T) {
+	source := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer source.Close()
+
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+
+	// the mirror stores the manifest of the latest tag with another digest, as a registry that
+	// converts the manifests would do
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || !strings.HasSuffix(r.URL.Path, "/manifests/latest") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:"+strings.Repeat("0", 64))
+		w.WriteHeader(recorder.Code)
+	}))
+	defer mirror.Close()
+
+	srcRepo := strings.TrimPrefix(source.URL, "http://") + "/app"
+	dstRepo := strings.TrimPrefix(mirror.URL, "http://") + "/app"
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := name.NewTag(srcRepo + ":1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Replicate(ref.String(), dstRepo, []string{"1.0", "latest"}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch for "+dstRepo+":latest") {
+		t.Errorf("error = %v, want digest mismatch", err)
+	}
+}
@@ -0,0 +1,582 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/estesp/manifest-tool/v2/pkg/types"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+	"go.megpoid.dev/drone-kaniko/pkg/manifest"
+	"go.megpoid.dev/drone-kaniko/pkg/policy"
+	"go.megpoid.dev/drone-kaniko/pkg/scan"
+	"go.megpoid.dev/drone-kaniko/pkg/tracing"
+	"gopkg.in/yaml.v3"
+)
+
+// build is one of the images built by the plugin, with its own copy of the settings.
+type build struct {
+	name     string
+	settings Settings
+	// cache repository used to seed the branch cache repository
+	cacheSeedRepo string
+	// target stage pushed as its own tag, if push-target is set
+	target string
+	// secrets staged as files for the RUN instructions
+	secrets []*buildSecret
+	metrics *metrics
+	// images and manifests pushed, shared with the build of the target stage
+	results *buildResults
+	// read the digest and size of the pushed images from the registry
+	inspectImages bool
+	// vulnerability scan done before pushing the images, if enabled
+	scan *scanGate
+	// rules checked against the built images, if enabled
+	policy *policy.Policy
+	// size comparison with the previous release and size budget, if enabled
+	size *sizeCheck
+	// repositories that receive a copy of the pushed image or manifest list
+	mirrors []mirror
+}
+
+// buildResults are the images and manifests pushed by a build.
+type buildResults struct {
+	Images    []ImageResult
+	Manifests []ManifestResult
+	Mirrors   []MirrorResult
+}
+
+// buildDefinition describes one of the builds of the step as overrides of the settings.
+type buildDefinition struct {
+	Name   string
+	values []*yaml.Node
+}
+
+// apply overrides the settings with the values of the build definition.
+func (d buildDefinition) apply(settings *Settings) error {
+	for i := 0; i < len(d.values); i += 2 {
+		if err := decodeSetting(settings, d.values[i], d.values[i+1]); err != nil {
+			return fmt.Errorf("invalid setting %s in build %s: %w", d.values[i].Value, d.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ParseBuilds parses a YAML or JSON list of build definitions, as given by the builds setting.
+func ParseBuilds(text string) ([]buildDefinition, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse builds: %w", err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	var unknown []string
+	definitions, err := parseBuildDefinitions(doc.Content[0], knownSettings(), &unknown)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown settings in builds: %s", strings.Join(unknown, ", "))
+	}
+
+	return definitions, nil
+}
+
+// parseBuildDefinitions reads a sequence of mappings with the settings of every build. The name
+// key identifies the build in the logs and report.
+func parseBuildDefinitions(node *yaml.Node, known map[string]bool, unknown *[]string) ([]buildDefinition, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, errors.New("builds must be a list")
+	}
+
+	names := make(map[string]bool)
+	definitions := make([]buildDefinition, 0, len(node.Content))
+
+	for idx, entry := range node.Content {
+		if entry.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("build %d must be a mapping", idx+1)
+		}
+
+		definition := buildDefinition{Name: "build-" + strconv.Itoa(idx+1)}
+
+		for i := 0; i < len(entry.Content); i += 2 {
+			key, value := entry.Content[i], entry.Content[i+1]
+
+			switch {
+			case key.Value == "name":
+				definition.Name = value.Value
+			case known[key.Value]:
+				definition.values = append(definition.values, key, value)
+			default:
+				*unknown = append(*unknown, "builds."+definition.Name+"."+key.Value)
+			}
+		}
+
+		if names[definition.Name] {
+			return nil, fmt.Errorf("duplicated build name: %s", definition.Name)
+		}
+		names[definition.Name] = true
+
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, nil
+}
+
+// clone returns a copy of the settings that doesn't share any slice with the original, so
+// every build can append to its own settings.
+func (s *Settings) clone() Settings {
+	settings := *s
+	cloneSlices(reflect.ValueOf(&settings).Elem())
+
+	return settings
+}
+
+func cloneSlices(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+
+		switch field.Kind() {
+		case reflect.Slice:
+			if !field.IsNil() && field.CanSet() {
+				field.Set(reflect.AppendSlice(reflect.MakeSlice(field.Type(), 0, field.Len()), field))
+			}
+		case reflect.Struct:
+			cloneSlices(field)
+		default:
+		}
+	}
+}
+
+// platformSettings returns a copy of the settings for the platform, with the overrides of the
+// settings file applied. The overrides may add build args from the environment, so they are
+// resolved again, and the result is validated like the top level settings.
+func (b *build) platformSettings(platform string) (*Settings, error) {
+	settings := b.settings.clone()
+	settings.CustomPlatform = platform
+
+	if settings.File != nil && settings.File.hasPlatform(platform) {
+		if err := settings.File.ApplyPlatform(&settings, platform); err != nil {
+			return nil, err
+		}
+
+		errs := resolveBuildArgs(&settings)
+		errs = append(errs, validateSettings(&settings)...)
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("platform %s: %w", platform, errors.Join(errs...))
+		}
+	}
+
+	return &settings, nil
+}
+
+// warm seeds the cache repository and warms the cache directory with the build images. The
+// digests already warmed by the previous builds are skipped.
+func (b *build) warm(ctx context.Context, tracker *warmTracker) error {
+	if b.cacheSeedRepo != "" {
+		seedCacheRepo(ctx, &b.settings, b.cacheSeedRepo)
+	}
+
+	if len(b.settings.Main.Images) == 0 {
+		return nil
+	}
+
+	// warmer is called once per platform
+	platforms := b.settings.Main.Platforms
+	if len(platforms) == 0 {
+		platforms = []string{b.settings.CustomPlatform}
+	}
+
+	return warmCache(ctx, b.warmerSettings(), platforms, tracker)
+}
+
+// warmerSettings returns the settings of the warmers. The base images of the dockerfile are
+// warmed like the other images, so they are deduped too. The warmer only reads the dockerfile
+// itself if it cannot be read here, like in a remote context.
+func (b *build) warmerSettings() *Settings {
+	settings := b.settings
+	if settings.Dockerfile == "" {
+		return &settings
+	}
+
+	if images, ok := b.readBaseImages(); ok {
+		settings.Main.Images = append(slices.Clone(b.settings.Main.Images), images...)
+		settings.Dockerfile = ""
+	}
+
+	return &settings
+}
+
+// targetDestinations returns the destinations with the target name appended to the tag.
+func targetDestinations(destinations []string, target string) []string {
+	result := make([]string, 0, len(destinations))
+	for _, destination := range destinations {
+		tag, err := name.NewTag(destination)
+		if err != nil {
+			continue
+		}
+		result = append(result, tag.Repository.Name()+":"+tag.TagStr()+"-"+target)
+	}
+
+	return result
+}
+
+// targetBuild returns the build of the target stage, pushed with the target name as tag suffix,
+// or nil if push-target isn't set. The outputs of the main image are left to the main build, as
+// are the policy and the size check: the stage isn't the released image, so it would be compared
+// with the baseline of the final image.
+func (b *build) targetBuild() *build {
+	if b.target == "" {
+		return nil
+	}
+
+	settings := b.settings.clone()
+	settings.Target = b.target
+	settings.Destinations = targetDestinations(b.settings.Destinations, b.target)
+	settings.DigestFile = ""
+	settings.ImageNameWithDigestFile = ""
+	settings.ImageNameTagWithDigestFile = ""
+	settings.OCILayoutPath = ""
+	settings.TarPath = ""
+
+	return &build{
+		name:          b.name,
+		settings:      settings,
+		metrics:       b.metrics,
+		results:       b.results,
+		inspectImages: b.inspectImages,
+		scan:          b.scan,
+	}
+}
+
+// destinations returns the destinations of the build, including the ones of the target stage.
+func (b *build) destinations() []string {
+	if b.target == "" {
+		return b.settings.Destinations
+	}
+
+	return slices.Concat(b.settings.Destinations, targetDestinations(b.settings.Destinations, b.target))
+}
+
+// run builds the target stage if requested, then the image, once per platform, and pushes the
+// manifest list if needed.
+func (b *build) run(ctx context.Context) error {
+	if target := b.targetBuild(); target != nil {
+		if err := target.run(ctx); err != nil {
+			return fmt.Errorf("failed to build target %s: %w", b.target, err)
+		}
+	}
+
+	// no platforms, just build and push directly without a manifest
+	if len(b.settings.Main.Platforms) == 0 {
+		settings, err := b.platformSettings(b.settings.CustomPlatform)
+		if err != nil {
+			return err
+		}
+
+		// kaniko build/push
+		if err := b.runPlatform(ctx, settings); err != nil {
+			return err
+		}
+
+		var digest string
+		if images := b.results.Images; len(images) > 0 {
+			digest = images[len(images)-1].Digest
+		}
+
+		return b.replicate(ctx, digest)
+	}
+
+	lists, err := b.manifestLists()
+	if err != nil {
+		return err
+	}
+
+	for _, platform := range b.settings.Main.Platforms {
+		settings, err := b.platformSettings(platform)
+		if err != nil {
+			return err
+		}
+
+		// kaniko is called once per platform
+		if err := b.runPlatform(ctx, settings); err != nil {
+			return err
+		}
+	}
+
+	cfg := manifest.Config{
+		Insecure: b.settings.Insecure,
+	}
+
+	retry := newRetryPolicy(&b.settings, b.metrics)
+
+	// digest of the manifest list of the first repository, copied to the mirrors
+	var mirrorDigest string
+
+	// push the manifest to the registry, per repository
+	for idx, list := range lists {
+		phase := phaseManifest + " " + list.Target
+
+		spanCtx, span := tracing.Start(ctx, phaseManifest, "destination", list.Target, "tags", list.Tags)
+
+		var digest string
+		start := time.Now()
+		err := retry.do(spanCtx, phase, func() error {
+			pushCtx, cancel := withPhaseTimeout(spanCtx, phase, b.settings.Main.ManifestTimeout)
+			defer cancel()
+
+			var err error
+			digest, err = manifest.Push(pushCtx, list.Target, list.Tags, list.Images, cfg)
+
+			return phaseError(pushCtx, err)
+		})
+		span.SetAttributes("digest", digest)
+		span.End(err)
+
+		if err != nil {
+			return fmt.Errorf("failed to push manifest: %w", err)
+		}
+
+		if idx == 0 {
+			mirrorDigest = digest
+		}
+
+		b.results.Manifests = append(b.results.Manifests, ManifestResult{
+			Destination: list.Target,
+			Tags:        list.Tags,
+			Digest:      digest,
+		})
+
+		slog.Info("Phase finished", "phase", phaseManifest, "destination", list.Target, "duration", time.Since(start))
+		b.metrics.phaseDuration(phaseManifest, "", time.Since(start))
+	}
+
+	return b.replicate(ctx, mirrorDigest)
+}
+
+// runPlatform runs kaniko for a single platform. Every attempt has its own build timeout.
+func (b *build) runPlatform(ctx context.Context, settings *Settings) (err error) {
+	phase := phaseBuild
+	if settings.CustomPlatform != "" {
+		phase += " " + settings.CustomPlatform
+	}
+
+	destinations := platformDestinations(settings)
+
+	ctx, span := tracing.Start(ctx, phaseBuild, "platform", settings.CustomPlatform, "destination", destinations)
+	defer func() { span.End(err) }()
+
+	// with the scan gate, the policy or the size limits the image is saved as a tarball, and pushed
+	// after the checks
+	buildSettings := settings
+	var tarball string
+	if b.checkedBeforePush() {
+		var temporary bool
+		if tarball, temporary = tarballPath(b.name, settings); temporary {
+			if err := os.MkdirAll(filepath.Dir(tarball), 0o755); err != nil {
+				return fmt.Errorf("failed to create the tarball directory: %w", err)
+			}
+			defer removeTarball(tarball)
+		}
+		buildSettings = tarballSettings(settings, tarball)
+	}
+
+	// the baseline is fetched before the build as the build may push over its tag
+	var baseline v1.Image
+	var baselineRef string
+	if b.size != nil {
+		baseline, baselineRef = b.size.baselineImage(ctx, settings, destinations)
+	}
+
+	attrs := []any{"phase", phaseBuild, "platform", settings.CustomPlatform}
+	start := time.Now()
+
+	var pushStart time.Time
+
+	err = newRetryPolicy(&b.settings, b.metrics).do(ctx, phase, func() error {
+		buildCtx, cancel := withPhaseTimeout(ctx, phase, b.settings.Main.BuildTimeout)
+		defer cancel()
+
+		pushStart = time.Time{}
+		observer := b.metrics.outputObserver(settings.CustomPlatform, &pushStart)
+
+		return phaseError(buildCtx, runCmdWithOutput(commandBuild(buildCtx, buildSettings), observer, attrs...))
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Phase finished", append(attrs, "destination", destinations, "duration", time.Since(start))...)
+
+	b.metrics.phaseDuration(phaseBuild, settings.CustomPlatform, time.Since(start))
+
+	var scanResult *scan.Result
+	if b.scan != nil {
+		if scanResult, err = b.scan.check(ctx, settings.CustomPlatform, tarball); err != nil {
+			return err
+		}
+	}
+
+	if b.policy != nil {
+		if err = b.checkPolicy(ctx, settings, tarball, destinations); err != nil {
+			return err
+		}
+	}
+
+	var sizeDiff *SizeDiff
+	if b.size != nil {
+		if sizeDiff, err = b.checkSize(ctx, settings, tarball, destinations, baseline, baselineRef); err != nil {
+			return err
+		}
+	}
+
+	if b.checkedBeforePush() && !settings.NoPush {
+		pushStart = time.Now()
+		if err = b.pushTarball(ctx, settings, tarball); err != nil {
+			return err
+		}
+	}
+
+	if !pushStart.IsZero() {
+		b.metrics.phaseDuration(phasePush, settings.CustomPlatform, time.Since(pushStart))
+	}
+
+	result := ImageResult{Platform: settings.CustomPlatform, Target: settings.Target, Destinations: destinations, SizeDiff: sizeDiff}
+	if scanResult != nil {
+		result.Vulnerabilities = scanResult.Counts()
+	}
+
+	if !settings.NoPush && len(destinations) > 0 && (b.inspectImages || b.metrics != nil || span.IsRecording()) {
+		result.Digest, result.Size = inspectImage(ctx, settings, destinations[0])
+		span.SetAttributes("digest", result.Digest)
+
+		if result.Size > 0 {
+			b.metrics.imageSize(settings.CustomPlatform, destinations[0], result.Size)
+		}
+	}
+
+	b.results.Images = append(b.results.Images, result)
+
+	return nil
+}
+
+// builtImage loads the built image to check it after the build. The image is read from the
+// scanned tarball, the tar-path or the oci-layout-path if any, otherwise from the registry. The
+// source is empty if the image wasn't saved anywhere.
+func builtImage(ctx context.Context, settings *Settings, tarball string, destinations []string) (v1.Image, string, error) {
+	if tarball == "" {
+		tarball = settings.TarPath
+	}
+
+	switch {
+	case tarball != "":
+		img, err := crane.LoadTarball(tarball)
+		return img, tarball, err
+	case settings.OCILayoutPath != "":
+		img, err := crane.LoadLayout(settings.OCILayoutPath, crane.WithPlatform(currentPlatform(settings.CustomPlatform)))
+		return img, settings.OCILayoutPath, err
+	case !settings.NoPush && len(destinations) > 0:
+		opts := append(craneOptions(settings, destinations[0], false), crane.WithContext(ctx), crane.WithPlatform(currentPlatform(settings.CustomPlatform)))
+		img, err := crane.Image(destinations[0], opts...)
+		return img, destinations[0], err
+	default:
+		return nil, "", nil
+	}
+}
+
+// inspectImage returns the digest and compressed size of the pushed image. Any value that cannot
+// be read from the registry is left empty.
+func inspectImage(ctx context.Context, settings *Settings, destination string) (string, int64) {
+	opts := append(craneOptions(settings, destination, false), crane.WithContext(ctx))
+
+	digest, err := crane.Digest(destination, opts...)
+	if err != nil {
+		slog.Warn("Failed to get the image digest", "destination", destination, "error", err)
+	}
+
+	size, err := crane.ImageSize(destination, opts...)
+	if err != nil {
+		slog.Warn("Failed to get the image size", "destination", destination, "error", err)
+	}
+
+	return digest, size
+}
+
+// manifestList is the manifest pushed to a repository after building every platform.
+type manifestList struct {
+	Target string
+	Tags   []string
+	Images []types.ManifestEntry
+}
+
+// manifestLists returns the manifests to push, one per repository of the destinations. The
+// destinations are updated with the default tag, if missing.
+func (b *build) manifestLists() ([]manifestList, error) {
+	var repositories []string
+	// list of repositories with their tags
+	tags := make(map[string][]string)
+
+	for idx, destination := range b.settings.Destinations {
+		tag, err := name.NewTag(destination)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination: %s", destination)
+		}
+
+		repoName := tag.Repository.Name()
+		if _, ok := tags[repoName]; !ok {
+			repositories = append(repositories, repoName)
+		}
+		tags[repoName] = append(tags[repoName], tag.TagStr())
+
+		b.settings.Destinations[idx] = tag.Name()
+	}
+
+	lists := make([]manifestList, 0, len(repositories))
+
+	for _, repoName := range repositories {
+		repoTags := tags[repoName]
+		list := manifestList{
+			Target: repoName + ":" + repoTags[0],
+			Tags:   repoTags[1:],
+		}
+
+		for _, platform := range b.settings.Main.Platforms {
+			OS, arch, found := strings.Cut(platform, "/")
+			if !found {
+				return nil, fmt.Errorf("invalid platform: %s", platform)
+			}
+
+			list.Images = append(list.Images, types.ManifestEntry{
+				Image: repoName + ":" + repoTags[0] + "-" + arch,
+				Platform: ocispec.Platform{
+					Architecture: arch,
+					OS:           OS,
+				},
+			})
+		}
+
+		lists = append(lists, list)
+	}
+
+	return lists, nil
+}
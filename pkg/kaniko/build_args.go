@@ -0,0 +1,201 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Case of the proxy build args added from the environment
+const (
+	ProxyCaseBoth  = "both"
+	ProxyCaseLower = "lower"
+	ProxyCaseUpper = "upper"
+)
+
+// proxyVars are the proxy variables passed to the build if set in the environment.
+var proxyVars = []string{"http_proxy", "https_proxy", "no_proxy"}
+
+// secretArgPrefix marks an args-from-env entry as secret, so its value is masked.
+const secretArgPrefix = "secret:"
+
+// buildArgList is an ordered set of build args indexed by their exact key.
+type buildArgList struct {
+	keys   []string
+	values map[string]string
+}
+
+// parseBuildArgList parses entries with the format KEY=VALUE. Entries without a value are kept
+// as is, so kaniko can read them from its environment.
+func parseBuildArgList(entries []string) *buildArgList {
+	args := &buildArgList{values: make(map[string]string, len(entries))}
+	for _, entry := range entries {
+		key, value, found := strings.Cut(entry, "=")
+		if !found {
+			args.setRaw(key, entry)
+			continue
+		}
+		args.setRaw(key, key+"="+value)
+	}
+
+	return args
+}
+
+func (a *buildArgList) setRaw(key, entry string) {
+	if _, ok := a.values[key]; !ok {
+		a.keys = append(a.keys, key)
+	}
+	a.values[key] = entry
+}
+
+// has checks if the build arg is set, matching the exact key.
+func (a *buildArgList) has(key string) bool {
+	_, ok := a.values[key]
+	return ok
+}
+
+// add sets the build arg if it isn't already set.
+func (a *buildArgList) add(key, value string) {
+	if !a.has(key) {
+		a.setRaw(key, key+"="+value)
+	}
+}
+
+// list returns the build args in the kaniko format, in the order they were added.
+func (a *buildArgList) list() []string {
+	entries := make([]string, 0, len(a.keys))
+	for _, key := range a.keys {
+		entries = append(entries, a.values[key])
+	}
+
+	return entries
+}
+
+// isProxyVar checks if the name is one of the proxy variables, in any case.
+func isProxyVar(name string) bool {
+	return slices.Contains(proxyVars, strings.ToLower(name))
+}
+
+// proxyKeys returns the keys used for a proxy variable according to the case policy.
+func proxyKeys(name, policy string) []string {
+	lower, upper := strings.ToLower(name), strings.ToUpper(name)
+
+	switch policy {
+	case ProxyCaseLower:
+		return []string{lower}
+	case ProxyCaseUpper:
+		return []string{upper}
+	default:
+		return []string{lower, upper}
+	}
+}
+
+// addProxyArg adds a proxy value, unless the build args already have it in any case.
+func addProxyArg(args *buildArgList, name, value, policy string) {
+	if args.has(strings.ToLower(name)) || args.has(strings.ToUpper(name)) {
+		return
+	}
+
+	for _, key := range proxyKeys(name, policy) {
+		args.add(key, value)
+	}
+}
+
+// envArg is an args-from-env entry, with the format [secret:]ARG[=ENV].
+type envArg struct {
+	Key    string
+	Env    string
+	Secret bool
+}
+
+func parseEnvArg(entry string) (envArg, error) {
+	arg := envArg{}
+
+	if rest, found := strings.CutPrefix(entry, secretArgPrefix); found {
+		arg.Secret = true
+		entry = rest
+	}
+
+	key, env, found := strings.Cut(entry, "=")
+	if !found {
+		env = key
+	}
+
+	if key == "" || env == "" {
+		return arg, fmt.Errorf("invalid args-from-env: %s", entry)
+	}
+
+	arg.Key = key
+	arg.Env = env
+
+	return arg, nil
+}
+
+// lookupProxyValue returns the value of a proxy variable, checking the lower case version
+// first.
+func lookupProxyValue(name string) string {
+	if value := os.Getenv(strings.ToLower(name)); value != "" {
+		return value
+	}
+
+	return os.Getenv(strings.ToUpper(name))
+}
+
+// resolveBuildArgs adds the proxy values and the args-from-env to the build args. Build args
+// set explicitly always take precedence.
+//
+// Only the proxy variables are read in both cases. The other args-from-env are read with the
+// exact name of the variable and added once with the name of the arg, while they used to fall
+// back to the upper case variable and be added in both cases too.
+func resolveBuildArgs(settings *Settings) []error {
+	var errs []error
+
+	args := parseBuildArgList(settings.BuildArgs)
+
+	for _, name := range proxyVars {
+		if value := lookupProxyValue(name); value != "" {
+			addProxyArg(args, name, value, settings.Main.ProxyCase)
+		}
+	}
+
+	for _, entry := range settings.Main.BuildArgsFromEnv {
+		arg, err := parseEnvArg(entry)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if isProxyVar(arg.Key) && arg.Key == arg.Env {
+			if value := lookupProxyValue(arg.Env); value != "" {
+				addProxyArg(args, arg.Key, value, settings.Main.ProxyCase)
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(arg.Env)
+		if !ok {
+			if upper := strings.ToUpper(arg.Env); upper != arg.Env && os.Getenv(upper) != "" {
+				slog.Warn("Environment variable of build arg not set, the lookup is case-sensitive", "arg", arg.Key, "env", arg.Env, "hint", "use "+arg.Key+"="+upper)
+				continue
+			}
+			slog.Warn("Environment variable of build arg not set", "arg", arg.Key, "env", arg.Env)
+			continue
+		}
+
+		if arg.Secret {
+			secrets.Add(value)
+		}
+
+		args.add(arg.Key, value)
+	}
+
+	settings.BuildArgs = args.list()
+
+	return errs
+}
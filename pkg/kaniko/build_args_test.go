@@ -0,0 +1,154 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveBuildArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		buildArgs []string
+		fromEnv   []string
+		proxyCase string
+		want      []string
+		wantErrs  int
+	}{
+		{
+			name:      "proxy both",
+			env:       map[string]string{"http_proxy": "http://proxy:3128"},
+			proxyCase: ProxyCaseBoth,
+			want:      []string{"http_proxy=http://proxy:3128", "HTTP_PROXY=http://proxy:3128"},
+		},
+		{
+			name:      "proxy lower",
+			env:       map[string]string{"HTTPS_PROXY": "http://proxy:3128", "no_proxy": "localhost"},
+			proxyCase: ProxyCaseLower,
+			want:      []string{"https_proxy=http://proxy:3128", "no_proxy=localhost"},
+		},
+		{
+			name:      "proxy upper",
+			env:       map[string]string{"http_proxy": "http://proxy:3128", "NO_PROXY": "localhost"},
+			proxyCase: ProxyCaseUpper,
+			want:      []string{"HTTP_PROXY=http://proxy:3128", "NO_PROXY=localhost"},
+		},
+		{
+			name:      "lower proxy value first",
+			env:       map[string]string{"http_proxy": "http://lower:3128", "HTTP_PROXY": "http://upper:3128"},
+			proxyCase: ProxyCaseBoth,
+			want:      []string{"http_proxy=http://lower:3128", "HTTP_PROXY=http://lower:3128"},
+		},
+		{
+			name:      "explicit proxy",
+			env:       map[string]string{"http_proxy": "http://proxy:3128"},
+			buildArgs: []string{"HTTP_PROXY=http://other:3128"},
+			proxyCase: ProxyCaseBoth,
+			want:      []string{"HTTP_PROXY=http://other:3128"},
+		},
+		{
+			// the keys used to match by prefix, so an arg like this one dropped the proxy
+			name:      "arg with proxy prefix",
+			env:       map[string]string{"http_proxy": "http://proxy:3128"},
+			buildArgs: []string{"http_proxy_extra=1", "HTTP_PROXY_PORT=3128"},
+			proxyCase: ProxyCaseBoth,
+			want:      []string{"http_proxy_extra=1", "HTTP_PROXY_PORT=3128", "http_proxy=http://proxy:3128", "HTTP_PROXY=http://proxy:3128"},
+		},
+		{
+			name:      "proxy from env",
+			env:       map[string]string{"HTTPS_PROXY": "http://proxy:3128"},
+			fromEnv:   []string{"https_proxy"},
+			proxyCase: ProxyCaseUpper,
+			want:      []string{"HTTPS_PROXY=http://proxy:3128"},
+		},
+		{
+			name:    "from env",
+			env:     map[string]string{"VERSION": "1.0"},
+			fromEnv: []string{"VERSION"},
+			want:    []string{"VERSION=1.0"},
+		},
+		{
+			name:    "renamed",
+			env:     map[string]string{"DRONE_TAG": "v1.0"},
+			fromEnv: []string{"VERSION=DRONE_TAG", "PROXY_URL=DRONE_TAG"},
+			want:    []string{"VERSION=v1.0", "PROXY_URL=v1.0"},
+		},
+		{
+			name:    "case-sensitive lookup",
+			env:     map[string]string{"VERSION": "1.0"},
+			fromEnv: []string{"version"},
+		},
+		{
+			name:      "explicit arg",
+			env:       map[string]string{"VERSION": "1.0"},
+			buildArgs: []string{"VERSION=2.0", "EMPTY"},
+			fromEnv:   []string{"VERSION", "EMPTY=VERSION"},
+			want:      []string{"VERSION=2.0", "EMPTY"},
+		},
+		{
+			name:    "missing env",
+			fromEnv: []string{"VERSION"},
+		},
+		{
+			name:     "invalid",
+			env:      map[string]string{"VERSION": "1.0"},
+			fromEnv:  []string{"=VERSION", "VERSION=", "secret:", "VERSION"},
+			want:     []string{"VERSION=1.0"},
+			wantErrs: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the lookup tells unset and empty variables apart
+			for _, name := range append(proxyVars, "VERSION", "version", "DRONE_TAG") {
+				for _, key := range []string{name, strings.ToUpper(name)} {
+					t.Setenv(key, "")
+					_ = os.Unsetenv(key)
+				}
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			settings := &Settings{BuildArgs: tt.buildArgs}
+			settings.Main.BuildArgsFromEnv = tt.fromEnv
+			settings.Main.ProxyCase = tt.proxyCase
+
+			errs := resolveBuildArgs(settings)
+			if len(errs) != tt.wantErrs {
+				t.Errorf("errors = %v, want %d", errs, tt.wantErrs)
+			}
+			if (len(settings.BuildArgs) > 0 || len(tt.want) > 0) && !reflect.DeepEqual(settings.BuildArgs, tt.want) {
+				t.Errorf("build args = %v, want %v", settings.BuildArgs, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveBuildArgsSecret(t *testing.T) {
+	t.Setenv("GIT_TOKEN", "build-args-s3cr3t")
+	t.Setenv("GIT_USER", "build-args-user")
+
+	settings := &Settings{}
+	settings.Main.BuildArgsFromEnv = []string{"secret:TOKEN=GIT_TOKEN", "GIT_USER"}
+
+	if errs := resolveBuildArgs(settings); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	if want := []string{"TOKEN=build-args-s3cr3t", "GIT_USER=build-args-user"}; !reflect.DeepEqual(settings.BuildArgs, want) {
+		t.Errorf("build args = %v, want %v", settings.BuildArgs, want)
+	}
+
+	// only the values of the secret entries are masked
+	if got := secrets.Mask(strings.Join(settings.BuildArgs, " ")); got != "TOKEN=******** GIT_USER=build-args-user" {
+		t.Errorf("masked build args = %s", got)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// buildSecretsDir is where the secrets are staged, the default target of
+// `RUN --mount=type=secret,id=<id>` in BuildKit.
+const buildSecretsDir = "/run/secrets"
+
+var secretIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// buildSecret is a secret available to the RUN instructions of the build as a file.
+type buildSecret struct {
+	ID     string
+	Source string
+	value  []byte
+}
+
+// parseBuildSecret reads a secret definition with the same format as docker buildx,
+// e.g. id=npm_token,env=NPM_TOKEN or id=netrc,src=/root/.netrc. If no source is given then
+// the environment variable with the same name as the id is used.
+func parseBuildSecret(entry string) (*buildSecret, error) {
+	var id, env, src string
+
+	for _, field := range strings.Split(entry, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid secret %s: missing value of %s", entry, key)
+		}
+
+		switch key {
+		case "id":
+			id = value
+		case "env":
+			env = value
+		case "src", "source":
+			src = value
+		case "type":
+			if value != "env" && value != "file" {
+				return nil, fmt.Errorf("invalid secret %s: unsupported type %s", entry, value)
+			}
+		default:
+			return nil, fmt.Errorf("invalid secret %s: unknown key %s", entry, key)
+		}
+	}
+
+	if !secretIDRegexp.MatchString(id) {
+		return nil, fmt.Errorf("invalid secret %s: invalid id", entry)
+	}
+
+	if env != "" && src != "" {
+		return nil, fmt.Errorf("invalid secret %s: env and src cannot be used together", id)
+	}
+
+	secret := &buildSecret{ID: id}
+
+	if src != "" {
+		value, err := os.ReadFile(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret %s: %w", id, err)
+		}
+		secret.Source = "file " + src
+		secret.value = value
+
+		return secret, nil
+	}
+
+	if env == "" {
+		env = id
+	}
+
+	value, ok := os.LookupEnv(env)
+	if !ok {
+		return nil, fmt.Errorf("secret %s: environment variable %s not set", id, env)
+	}
+	secret.Source = "env " + env
+	secret.value = []byte(value)
+
+	return secret, nil
+}
+
+// maskBuildSecret registers the secret value, and every line of it, to be masked.
+func maskBuildSecret(secret *buildSecret) {
+	secrets.Add(string(secret.value))
+	secrets.Add(strings.Split(string(secret.value), "\n")...)
+}
+
+// parseBuildSecrets reads the secrets of the build. The secrets directory is added to the
+// ignored paths so the files never end in a snapshot of the image.
+func parseBuildSecrets(settings *Settings) ([]*buildSecret, []error) {
+	var errs []error
+	var result []*buildSecret
+
+	seen := make(map[string]bool)
+
+	for _, entry := range settings.Main.Secrets {
+		secret, err := parseBuildSecret(entry)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if seen[secret.ID] {
+			errs = append(errs, fmt.Errorf("duplicated secret: %s", secret.ID))
+			continue
+		}
+		seen[secret.ID] = true
+
+		maskBuildSecret(secret)
+		result = append(result, secret)
+	}
+
+	if len(result) > 0 {
+		settings.IgnorePath = uniqueStrings(append(settings.IgnorePath, buildSecretsDir))
+	}
+
+	return result, errs
+}
+
+// checkBuildSecrets checks that builds sharing a secret id use the same value, as the secrets
+// of every build are staged in the same directory.
+func checkBuildSecrets(builds []*build) error {
+	var errs []error
+	values := make(map[string]string)
+
+	for _, b := range builds {
+		for _, secret := range b.secrets {
+			value, ok := values[secret.ID]
+			if ok && value != string(secret.value) {
+				errs = append(errs, fmt.Errorf("secret %s has different values between builds", secret.ID))
+				continue
+			}
+			values[secret.ID] = string(secret.value)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// stageBuildSecrets writes the secrets of every build in the directory, readable only by the
+// current user. The returned function removes them.
+func stageBuildSecrets(dir string, builds []*build) (func(), error) {
+	var staged []string
+
+	cleanup := func() {
+		for _, path := range staged {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Failed to remove secret", "path", path, "error", err)
+			}
+		}
+	}
+
+	for _, b := range builds {
+		for _, secret := range b.secrets {
+			path := filepath.Join(dir, secret.ID)
+			if slices.Contains(staged, path) {
+				continue
+			}
+
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				cleanup()
+				return nil, fmt.Errorf("failed to create the secrets directory: %w", err)
+			}
+
+			// replace any leftover of a previous run, the file is read-only
+			_ = os.Remove(path)
+
+			if err := os.WriteFile(path, secret.value, 0o400); err != nil {
+				cleanup()
+				return nil, fmt.Errorf("failed to stage secret %s: %w", secret.ID, err)
+			}
+
+			staged = append(staged, path)
+			slog.Info("Staged build secret", "id", secret.ID, "source", secret.Source, "path", path)
+		}
+	}
+
+	return cleanup, nil
+}
@@ -0,0 +1,245 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.megpoid.dev/drone-kaniko/pkg/policy"
+)
+
+func TestTargetBuild(t *testing.T) {
+	b := &build{
+		name:   "app",
+		target: "test",
+		scan:   &scanGate{},
+		policy: &policy.Policy{},
+		size:   &sizeCheck{diff: true, maxGrowth: 10},
+	}
+	b.settings.Destinations = []string{"registry.example.com/app:1.0", "registry.example.com/app:latest"}
+	b.settings.DigestFile = "/tmp/digest"
+
+	if (&build{}).targetBuild() != nil {
+		t.Fatal("target build without push-target")
+	}
+
+	target := b.targetBuild()
+	if target == nil {
+		t.Fatal("missing target build")
+	}
+
+	want := []string{"registry.example.com/app:1.0-test", "registry.example.com/app:latest-test"}
+	if !reflect.DeepEqual(target.settings.Destinations, want) {
+		t.Errorf("destinations = %v, want %v", target.settings.Destinations, want)
+	}
+
+	if target.settings.Target != "test" || target.settings.DigestFile != "" {
+		t.Errorf("target = %s, digest file = %s", target.settings.Target, target.settings.DigestFile)
+	}
+
+	// the stage is scanned, but not compared with the final image
+	if target.scan == nil || target.policy != nil || target.size != nil {
+		t.Errorf("checks = scan %v, policy %v, size %v", target.scan, target.policy, target.size)
+	}
+
+	if len(b.settings.Destinations) != 2 || b.size == nil {
+		t.Error("targetBuild modified the build")
+	}
+}
+
+func TestWarmerSettings(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile.app"), []byte("FROM golang:1.23 AS build\nFROM alpine:3.20\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		update         func(s *Settings)
+		wantImages     []string
+		wantDockerfile string
+	}{
+		{
+			name:       "no dockerfile",
+			update:     func(s *Settings) {},
+			wantImages: []string{"alpine:3.20"},
+		},
+		{
+			name:       "dockerfile",
+			update:     func(s *Settings) { s.Dockerfile = "Dockerfile.app" },
+			wantImages: []string{"alpine:3.20", "golang:1.23", "alpine:3.20"},
+		},
+		{
+			name:           "remote context",
+			update:         func(s *Settings) { s.Dockerfile = "Dockerfile.app"; s.Context = "git://github.com/example/app.git" },
+			wantImages:     []string{"alpine:3.20"},
+			wantDockerfile: "Dockerfile.app",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &build{}
+			b.settings.Context = dir
+			b.settings.Main.Images = []string{"alpine:3.20"}
+			tt.update(&b.settings)
+
+			settings := b.warmerSettings()
+			if !reflect.DeepEqual(settings.Main.Images, tt.wantImages) || settings.Dockerfile != tt.wantDockerfile {
+				t.Errorf("warmer settings = %v and %q, want %v and %q", settings.Main.Images, settings.Dockerfile, tt.wantImages, tt.wantDockerfile)
+			}
+
+			if len(b.settings.Main.Images) != 1 {
+				t.Errorf("warmerSettings modified the images: %v", b.settings.Main.Images)
+			}
+		})
+	}
+}
+
+func TestParseBuilds(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name:      "yaml",
+			text:      "- name: api\n  target: api\n  destination: [registry.example.com/api:1.0]\n- name: worker\n  dockerfile: Dockerfile.worker\n  build-arg: [MODE=worker]\n",
+			wantNames: []string{"api", "worker"},
+		},
+		{
+			name:      "json",
+			text:      `[{"name": "api", "target": "api"}, {"dockerfile": "Dockerfile.migrations", "platforms": ["linux/amd64"]}]`,
+			wantNames: []string{"api", "build-2"},
+		},
+		{
+			name: "empty",
+			text: "",
+		},
+		{
+			name:    "not a list",
+			text:    "name: api",
+			wantErr: "builds must be a list",
+		},
+		{
+			name:    "entry not a mapping",
+			text:    "- name: api\n- worker\n",
+			wantErr: "build 2 must be a mapping",
+		},
+		{
+			name:    "duplicated name",
+			text:    "- name: api\n- target: worker\n  name: api\n",
+			wantErr: "duplicated build name: api",
+		},
+		{
+			name:    "default name taken",
+			text:    "- target: api\n- name: build-1\n",
+			wantErr: "duplicated build name: build-1",
+		},
+		{
+			name:    "unknown settings",
+			text:    "- name: api\n  dockerfiles: Dockerfile\n  auth: secret\n- target: worker\n  tag: latest\n",
+			wantErr: "unknown settings in builds: builds.api.dockerfiles, builds.api.auth, builds.build-2.tag",
+		},
+		{
+			name:    "invalid yaml",
+			text:    "- name: [api",
+			wantErr: "failed to parse builds",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			definitions, err := ParseBuilds(tt.text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, definition := range definitions {
+				names = append(names, definition.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestBuildDefinitionApply(t *testing.T) {
+	definitions, err := ParseBuilds("- name: worker\n  dockerfile: Dockerfile.worker\n  target: worker\n  build-arg: [MODE=worker]\n  platforms: [linux/arm64]\n- name: broken\n  push-retry: many\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := &Settings{Dockerfile: "Dockerfile", Context: "app", BuildArgs: []string{"VERSION=1.0"}}
+	settings.Main.Platforms = []string{"linux/amd64"}
+
+	if err := definitions[0].apply(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	// the values of the definition replace the settings, the rest is kept
+	if settings.Dockerfile != "Dockerfile.worker" || settings.Target != "worker" || settings.Context != "app" {
+		t.Errorf("settings = dockerfile %s, target %s, context %s", settings.Dockerfile, settings.Target, settings.Context)
+	}
+	if want := []string{"MODE=worker"}; !reflect.DeepEqual(settings.BuildArgs, want) {
+		t.Errorf("build args = %v, want %v", settings.BuildArgs, want)
+	}
+	if want := []string{"linux/arm64"}; !reflect.DeepEqual(settings.Main.Platforms, want) {
+		t.Errorf("platforms = %v, want %v", settings.Main.Platforms, want)
+	}
+
+	err = definitions[1].apply(settings)
+	if err == nil || !strings.Contains(err.Error(), "invalid setting push-retry in build broken") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestSettingsClone(t *testing.T) {
+	settings := &Settings{
+		Destinations: []string{"registry.example.com/app:1.0"},
+		BuildArgs:    make([]string, 1, 4),
+		File:         &SettingsFile{},
+	}
+	settings.BuildArgs[0] = "VERSION=1.0"
+	settings.Main.Platforms = []string{"linux/amd64", "linux/arm64"}
+
+	clone := settings.clone()
+
+	// appending to a slice with spare capacity would write into the original array
+	clone.BuildArgs = append(clone.BuildArgs, "MODE=worker")
+	clone.Destinations[0] = "registry.example.com/worker:1.0"
+	clone.Main.Platforms[1] = "linux/riscv64"
+
+	if want := []string{"VERSION=1.0"}; !reflect.DeepEqual(settings.BuildArgs, want) || settings.BuildArgs[:2][1] != "" {
+		t.Errorf("build args = %v", settings.BuildArgs[:2])
+	}
+	if settings.Destinations[0] != "registry.example.com/app:1.0" {
+		t.Errorf("destinations = %v", settings.Destinations)
+	}
+	if want := []string{"linux/amd64", "linux/arm64"}; !reflect.DeepEqual(settings.Main.Platforms, want) {
+		t.Errorf("platforms = %v, want %v", settings.Main.Platforms, want)
+	}
+
+	// unset slices stay unset, and the settings file is shared since it's only read
+	if clone.Labels != nil || clone.Main.Images != nil {
+		t.Errorf("labels = %#v, images = %#v", clone.Labels, clone.Main.Images)
+	}
+	if clone.File != settings.File {
+		t.Error("settings file copied")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"fmt"
+	"log/slog"
+
+	"go.megpoid.dev/drone-kaniko/pkg/cache"
+)
+
+// Phases where the cache garbage collection can run
+const (
+	CacheGCPre  = "pre"
+	CacheGCPost = "post"
+)
+
+func cacheDirectory(settings *Settings) string {
+	if settings.CacheDir != "" {
+		return settings.CacheDir
+	}
+
+	return defaultCacheDir
+}
+
+// CollectCache evicts the entries of the cache directory that are older than the cache TTL
+// or don't fit in the maximum cache size.
+func CollectCache(settings *Settings, dryRun bool) error {
+	maxSize, err := cache.ParseSize(settings.Main.CacheMaxSize)
+	if err != nil {
+		return fmt.Errorf("invalid cache-max-size: %w", err)
+	}
+
+	dir := cacheDirectory(settings)
+	result, err := cache.Collect(dir, cache.Config{
+		MaxSize: maxSize,
+		TTL:     settings.CacheTTL,
+		Policy:  settings.Main.CacheGCPolicy,
+		DryRun:  dryRun,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to collect cache: %w", err)
+	}
+
+	slog.Info("Cache garbage collection finished",
+		"path", dir,
+		"evicted", len(result.Evicted),
+		"entries_before", result.Before.Entries,
+		"size_before", result.Before.Size,
+		"entries", result.After.Entries,
+		"size", result.After.Size,
+		"dry_run", dryRun,
+	)
+
+	return nil
+}
+
+// PrintCacheStats logs the size and entries of the cache directory.
+func PrintCacheStats(settings *Settings) error {
+	dir := cacheDirectory(settings)
+
+	entries, err := cache.Scan(dir)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+		slog.Info("Cache entry",
+			"name", entry.Name,
+			"size", entry.Size,
+			"modified", entry.ModTime,
+			"accessed", entry.AccessTime,
+		)
+	}
+
+	slog.Info("Cache stats", "path", dir, "entries", len(entries), "size", size)
+
+	return nil
+}
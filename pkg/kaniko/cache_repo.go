@@ -0,0 +1,229 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+	"github.com/google/go-containerregistry/pkg/name"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+)
+
+const (
+	defaultCacheRepoTemplate       = "{{repo}}/cache"
+	defaultCacheBranchRepoTemplate = "{{repo}}/cache/{{branch}}"
+	cacheSeedJobs                  = 4
+	// kaniko ignores the cached layers older than the cache ttl, two weeks by default
+	defaultCacheTTL = 14 * 24 * time.Hour
+)
+
+var invalidBranchChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// sanitizeBranch converts a branch name to a valid repository path component.
+func sanitizeBranch(branch string) string {
+	branch = invalidBranchChars.ReplaceAllString(strings.ToLower(branch), "-")
+	branch = strings.Trim(branch, "-._")
+	if len(branch) > 128 {
+		branch = strings.TrimRight(branch[:128], "-._")
+	}
+
+	return branch
+}
+
+// currentBranch returns the branch being built, using the source branch for pull requests.
+func currentBranch(pipeline *drone.Pipeline) string {
+	if pipeline.Build.PullRequest > 0 && pipeline.Build.SourceBranch != "" {
+		return pipeline.Build.SourceBranch
+	}
+
+	if pipeline.Build.Branch != "" {
+		return pipeline.Build.Branch
+	}
+
+	return pipeline.Commit.Branch
+}
+
+// renderCacheRepo evaluates a cache repository template. The template can use {{repo}} for the
+// repository of the first destination, {{branch}} for the branch and {{default_branch}}.
+func renderCacheRepo(text, repo, branch, defaultBranch string) (string, error) {
+	tmpl, err := template.New("cache-repo").Funcs(template.FuncMap{
+		"repo":           func() string { return repo },
+		"branch":         func() string { return sanitizeBranch(branch) },
+		"default_branch": func() string { return sanitizeBranch(defaultBranch) },
+	}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid cache repository template %q: %w", text, err)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, nil); err != nil {
+		return "", fmt.Errorf("failed to render cache repository template %q: %w", text, err)
+	}
+
+	cacheRepo := sb.String()
+	if _, err := name.NewRepository(cacheRepo); err != nil {
+		return "", fmt.Errorf("invalid cache repository %q: %w", cacheRepo, err)
+	}
+
+	return cacheRepo, nil
+}
+
+// resolveCacheRepo derives the cache repository from the templates if none was provided. When
+// per-branch caches are enabled and the branch isn't the default one, the cache repository of
+// the default branch is returned so it can be used to seed the branch cache.
+func resolveCacheRepo(settings *Settings, pipeline *drone.Pipeline) (string, error) {
+	if !settings.Cache || settings.CacheRepo != "" || len(settings.Destinations) == 0 {
+		return "", nil
+	}
+
+	tag, err := name.NewTag(settings.Destinations[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid destination: %s", settings.Destinations[0])
+	}
+
+	repo := tag.Repository.Name()
+	defaultBranch := pipeline.Repo.Branch
+	branch := currentBranch(pipeline)
+	if branch == "" {
+		branch = defaultBranch
+	}
+
+	repoTemplate := settings.Main.CacheRepoTemplate
+	if repoTemplate == "" {
+		repoTemplate = defaultCacheRepoTemplate
+	}
+
+	defaultCacheRepo, err := renderCacheRepo(repoTemplate, repo, defaultBranch, defaultBranch)
+	if err != nil {
+		return "", err
+	}
+
+	if !settings.Main.CachePerBranch || branch == defaultBranch {
+		settings.CacheRepo = defaultCacheRepo
+		return "", nil
+	}
+
+	branchTemplate := settings.Main.CacheBranchRepoTemplate
+	if branchTemplate == "" {
+		branchTemplate = defaultCacheBranchRepoTemplate
+	}
+
+	settings.CacheRepo, err = renderCacheRepo(branchTemplate, repo, branch, defaultBranch)
+	if err != nil {
+		return "", err
+	}
+
+	if settings.CacheRepo == defaultCacheRepo {
+		return "", nil
+	}
+
+	return defaultCacheRepo, nil
+}
+
+// seedCacheRepo copies the layer cache of the default branch to an empty branch cache, so the
+// first build of a branch still gets cache hits. Only the newest layers are copied, up to the
+// cache-seed-limit, skipping the ones kaniko would ignore for being older than the cache ttl.
+// Failures aren't fatal since the cache is optional.
+func seedCacheRepo(ctx context.Context, settings *Settings, source string) {
+	opts := append(craneOptions(settings, settings.CacheRepo, false), crane.WithContext(ctx))
+
+	tags, err := crane.ListTags(settings.CacheRepo, opts...)
+	if err != nil {
+		slog.Warn("Cannot check the branch cache repository", "cache_repo", settings.CacheRepo, "error", err)
+		return
+	}
+
+	if len(tags) > 0 {
+		slog.Info("Using branch cache repository", "cache_repo", settings.CacheRepo)
+		return
+	}
+
+	sourceTags, err := crane.ListTags(source, opts...)
+	if err != nil {
+		slog.Warn("Cannot check the default branch cache repository", "cache_repo", source, "error", err)
+		return
+	}
+
+	if len(sourceTags) == 0 {
+		slog.Info("The default branch cache repository is empty, skipping seed", "cache_repo", source)
+		return
+	}
+
+	maxAge := settings.CacheTTL
+	if maxAge <= 0 {
+		maxAge = defaultCacheTTL
+	}
+
+	slog.Info("Seeding branch cache repository from the default branch",
+		"cache_repo", settings.CacheRepo,
+		"source", source,
+		"tags", len(sourceTags),
+		"limit", settings.Main.CacheSeedLimit,
+		"max_age", maxAge,
+	)
+
+	copied, err := crane.CopyNewest(source, settings.CacheRepo, crane.CopyConfig{
+		MaxAge: maxAge,
+		Limit:  settings.Main.CacheSeedLimit,
+	}, append(opts, crane.WithJobs(cacheSeedJobs))...)
+	if err != nil {
+		slog.Warn("Failed to seed the branch cache repository", "cache_repo", settings.CacheRepo, "error", err)
+		return
+	}
+
+	slog.Info("Branch cache repository seeded", "cache_repo", settings.CacheRepo, "copied", len(copied))
+}
+
+// PruneCacheRepo deletes the layer cache tags of the cache repository that are older than the
+// retention window or beyond the maximum number of tags.
+func PruneCacheRepo(settings *Settings, pipeline *drone.Pipeline, dryRun bool) error {
+	if settings.Main.CachePruneAge <= 0 && settings.Main.CachePruneKeep <= 0 {
+		return errors.New("cache-prune-age or cache-prune-keep must be set")
+	}
+
+	if settings.CacheRepo == "" {
+		if err := enableCompatibilityMode(settings, pipeline); err != nil {
+			return err
+		}
+
+		settings.Cache = true
+		if _, err := resolveCacheRepo(settings, pipeline); err != nil {
+			return err
+		}
+
+		if settings.CacheRepo == "" {
+			return errors.New("must provide either cache-repo or at least one repo/destination")
+		}
+	}
+
+	opts := append(craneOptions(settings, settings.CacheRepo, false), crane.WithJobs(cacheSeedJobs))
+
+	result, err := crane.Prune(settings.CacheRepo, crane.PruneConfig{
+		MaxAge: settings.Main.CachePruneAge,
+		Keep:   settings.Main.CachePruneKeep,
+		DryRun: dryRun,
+	}, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to prune cache repository: %w", err)
+	}
+
+	slog.Info("Cache repository pruned",
+		"cache_repo", settings.CacheRepo,
+		"kept", len(result.Kept),
+		"deleted", len(result.Deleted),
+		"shared", len(result.Shared),
+		"dry_run", dryRun,
+	)
+
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+)
+
+func TestSanitizeBranch(t *testing.T) {
+	tests := []struct {
+		branch string
+		want   string
+	}{
+		{"main", "main"},
+		{"feature/Login-Page", "feature-login-page"},
+		{"fix/#123 crash", "fix-123-crash"},
+		{"release-1.2.x", "release-1.2.x"},
+		{"-_.dots._-", "dots"},
+		{"renovate/golang.org-x-net-0.x", "renovate-golang.org-x-net-0.x"},
+		{strings.Repeat("a", 127) + "/b", strings.Repeat("a", 127)},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeBranch(tt.branch); got != tt.want {
+			t.Errorf("sanitizeBranch(%q) = %q, want %q", tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestResolveCacheRepo(t *testing.T) {
+	tests := []struct {
+		name          string
+		update        func(s *Settings, p *drone.Pipeline)
+		wantCacheRepo string
+		wantSeed      string
+		wantErr       bool
+	}{
+		{
+			name:          "default",
+			update:        func(s *Settings, p *drone.Pipeline) {},
+			wantCacheRepo: "registry.example.com/team/app/cache",
+		},
+		{
+			name:          "cache repo set",
+			update:        func(s *Settings, p *drone.Pipeline) { s.CacheRepo = "registry.example.com/cache" },
+			wantCacheRepo: "registry.example.com/cache",
+		},
+		{
+			name:   "cache disabled",
+			update: func(s *Settings, p *drone.Pipeline) { s.Cache = false },
+		},
+		{
+			name:          "per branch on default branch",
+			update:        func(s *Settings, p *drone.Pipeline) { s.Main.CachePerBranch = true; p.Build.Branch = "main" },
+			wantCacheRepo: "registry.example.com/team/app/cache",
+		},
+		{
+			name:          "per branch",
+			update:        func(s *Settings, p *drone.Pipeline) { s.Main.CachePerBranch = true },
+			wantCacheRepo: "registry.example.com/team/app/cache/feature-login",
+			wantSeed:      "registry.example.com/team/app/cache",
+		},
+		{
+			name: "pull request",
+			update: func(s *Settings, p *drone.Pipeline) {
+				s.Main.CachePerBranch = true
+				p.Build.Branch = "main"
+				p.Build.PullRequest = 42
+				p.Build.SourceBranch = "Fix/Crash"
+			},
+			wantCacheRepo: "registry.example.com/team/app/cache/fix-crash",
+			wantSeed:      "registry.example.com/team/app/cache",
+		},
+		{
+			name: "invalid branch template",
+			update: func(s *Settings, p *drone.Pipeline) {
+				s.Main.CachePerBranch = true
+				s.Main.CacheRepoTemplate = "registry.example.com/cache/{{default_branch}}"
+				s.Main.CacheBranchRepoTemplate = "{{repo}}-cache:{{branch}}"
+			},
+			wantErr: true,
+		},
+		{
+			name: "branch templates",
+			update: func(s *Settings, p *drone.Pipeline) {
+				s.Main.CachePerBranch = true
+				s.Main.CacheRepoTemplate = "registry.example.com/cache/{{default_branch}}"
+				s.Main.CacheBranchRepoTemplate = "registry.example.com/cache/{{branch}}"
+			},
+			wantCacheRepo: "registry.example.com/cache/feature-login",
+			wantSeed:      "registry.example.com/cache/main",
+		},
+		{
+			// the template doesn't use the branch, so there is nothing to seed
+			name: "same repository",
+			update: func(s *Settings, p *drone.Pipeline) {
+				s.Main.CachePerBranch = true
+				s.Main.CacheBranchRepoTemplate = "{{repo}}/cache"
+			},
+			wantCacheRepo: "registry.example.com/team/app/cache",
+		},
+		{
+			name:    "invalid template",
+			update:  func(s *Settings, p *drone.Pipeline) { s.Main.CacheRepoTemplate = "{{repo}/cache" },
+			wantErr: true,
+		},
+		{
+			name:    "unknown function",
+			update:  func(s *Settings, p *drone.Pipeline) { s.Main.CacheRepoTemplate = "{{repo}}/{{commit}}" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := &Settings{Cache: true, Destinations: []string{"registry.example.com/team/app:1.0"}}
+			pipeline := testPipeline()
+			pipeline.Repo.Branch = "main"
+			tt.update(settings, pipeline)
+
+			seed, err := resolveCacheRepo(settings, pipeline)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if settings.CacheRepo != tt.wantCacheRepo || seed != tt.wantSeed {
+				t.Errorf("cache repo = %q, seed %q, want %q and %q", settings.CacheRepo, seed, tt.wantCacheRepo, tt.wantSeed)
+			}
+		})
+	}
+}
+
+func TestSeedCacheRepo(t *testing.T) {
+	server := httptest.NewServer(newTestRegistry())
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	source, cacheRepo := host+"/app/cache", host+"/app/cache/feature"
+
+	// the layers older than the cache ttl are ignored by kaniko, so they aren't copied
+	for tag, age := range map[string]time.Duration{"old": 30 * 24 * time.Hour, "recent": time.Hour, "newest": time.Minute, "day": 24 * time.Hour} {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img, err = mutate.CreatedAt(img, v1.Time{Time: time.Now().Add(-age)}); err != nil {
+			t.Fatal(err)
+		}
+
+		ref, err := name.NewTag(source + ":" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	settings := &Settings{CacheRepo: cacheRepo}
+	settings.Main.CacheSeedLimit = 2
+
+	seedCacheRepo(context.Background(), settings, source)
+
+	// only the newest layers are copied
+	tags, err := crane.ListTags(cacheRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tags)
+
+	if want := []string{"newest", "recent"}; strings.Join(tags, ",") != strings.Join(want, ",") {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+
+	settings.CacheRepo = host + "/app/cache/other"
+	settings.Main.CacheSeedLimit = 0
+
+	seedCacheRepo(context.Background(), settings, source)
+
+	tags, err = crane.ListTags(settings.CacheRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tags)
+
+	if want := []string{"day", "newest", "recent"}; strings.Join(tags, ",") != strings.Join(want, ",") {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+
+	// a branch cache in use isn't seeded again
+	settings.CacheRepo = cacheRepo
+	seedCacheRepo(context.Background(), settings, source)
+
+	if tags, _ := crane.ListTags(cacheRepo); len(tags) != 2 {
+		t.Errorf("tags = %v after seeding again", tags)
+	}
+}
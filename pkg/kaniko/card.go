@@ -0,0 +1,133 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.megpoid.dev/drone-kaniko/pkg/cache"
+)
+
+// cardData is the summary of the step shown by the card template, with the values already
+// formatted since adaptive cards cannot format them.
+type cardData struct {
+	Status   string      `json:"status"`
+	Duration string      `json:"duration"`
+	Builds   []cardBuild `json:"builds"`
+}
+
+type cardBuild struct {
+	Name       string         `json:"name"`
+	Status     string         `json:"status"`
+	Duration   string         `json:"duration"`
+	Error      string         `json:"error,omitempty"`
+	BaseImages string         `json:"base_images,omitempty"`
+	Images     []cardImage    `json:"images"`
+	Manifests  []cardManifest `json:"manifests"`
+}
+
+type cardImage struct {
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Tags   string `json:"tags"`
+	Digest string `json:"digest"`
+	Size   string `json:"size"`
+}
+
+type cardManifest struct {
+	Image  string `json:"image"`
+	Tags   string `json:"tags"`
+	Digest string `json:"digest"`
+}
+
+func newCardData(report *Report) cardData {
+	data := cardData{Status: report.Status, Duration: report.Duration}
+
+	for _, entry := range report.Builds {
+		card := cardBuild{
+			Name:       entry.Name,
+			Status:     entry.Status,
+			Duration:   entry.Duration,
+			Error:      entry.Error,
+			BaseImages: strings.Join(entry.BaseImages, ", "),
+			Images:     []cardImage{},
+			Manifests:  []cardManifest{},
+		}
+
+		if card.Name == "" {
+			card.Name = "image"
+		}
+
+		for _, image := range entry.Images {
+			item := cardImage{Name: image.Platform, Digest: image.Digest, Size: "-"}
+			if image.Target != "" {
+				item.Name = strings.TrimSpace(image.Target + " " + image.Platform)
+			} else if item.Name == "" {
+				item.Name = "image"
+			}
+			if image.Size > 0 {
+				item.Size = cache.FormatSize(image.Size)
+			}
+			if len(image.Destinations) > 0 {
+				item.Image = image.Destinations[0]
+				item.Tags = strings.Join(image.Destinations, ", ")
+			}
+			card.Images = append(card.Images, item)
+		}
+
+		for _, list := range entry.Manifests {
+			card.Manifests = append(card.Manifests, cardManifest{
+				Image:  list.Destination,
+				Tags:   strings.Join(list.Tags, ", "),
+				Digest: list.Digest,
+			})
+		}
+
+		data.Builds = append(data.Builds, card)
+	}
+
+	return data
+}
+
+// writeCard writes the card of the step, in the format read by Drone. The special paths
+// /dev/stdout and /dev/stderr write the card encoded in the log, for runners that don't
+// support card files.
+func writeCard(path, schema string, report *Report) error {
+	data, err := json.Marshal(map[string]any{
+		"schema": schema,
+		"data":   secrets.MaskValue(newCardData(report)),
+	})
+	if err != nil {
+		return err
+	}
+
+	switch path {
+	case "/dev/stdout":
+		err = writeCardTo(os.Stdout, data)
+	case "/dev/stderr":
+		err = writeCardTo(os.Stderr, data)
+	default:
+		err = os.WriteFile(path, data, 0o644)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write card: %w", err)
+	}
+
+	slog.Debug("Card saved", "path", path)
+
+	return nil
+}
+
+func writeCardTo(w io.Writer, data []byte) error {
+	_, err := io.WriteString(w, "\u001B]1338;"+base64.StdEncoding.EncodeToString(data)+"\u001B]0m\n")
+	return err
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testReport() *Report {
+	return &Report{
+		Status:   "failure",
+		Duration: "1m30s",
+		Builds: []BuildReport{
+			{
+				Status:     "success",
+				Duration:   "1m0s",
+				BaseImages: []string{"golang:1.23", "alpine:3.20"},
+				Images: []ImageResult{
+					{Platform: "linux/amd64", Destinations: []string{"registry.example.com/app:1.0", "registry.example.com/app:latest"}, Digest: "sha256:amd64", Size: 1536},
+					{Platform: "linux/arm64", Destinations: []string{"registry.example.com/app:1.0"}, Digest: "sha256:arm64"},
+					{Target: "test", Destinations: []string{"registry.example.com/app:1.0-test"}},
+				},
+				Manifests: []ManifestResult{
+					{Destination: "registry.example.com/app", Tags: []string{"1.0", "latest"}, Digest: "sha256:index"},
+				},
+			},
+			{
+				Name:     "worker",
+				Status:   "failure",
+				Duration: "30s",
+				Error:    "build failed",
+			},
+		},
+	}
+}
+
+func TestNewCardData(t *testing.T) {
+	want := cardData{
+		Status:   "failure",
+		Duration: "1m30s",
+		Builds: []cardBuild{
+			{
+				Name:       "image",
+				Status:     "success",
+				Duration:   "1m0s",
+				BaseImages: "golang:1.23, alpine:3.20",
+				Images: []cardImage{
+					{Name: "linux/amd64", Image: "registry.example.com/app:1.0", Tags: "registry.example.com/app:1.0, registry.example.com/app:latest", Digest: "sha256:amd64", Size: "1.5KiB"},
+					{Name: "linux/arm64", Image: "registry.example.com/app:1.0", Tags: "registry.example.com/app:1.0", Digest: "sha256:arm64", Size: "-"},
+					{Name: "test", Image: "registry.example.com/app:1.0-test", Tags: "registry.example.com/app:1.0-test", Size: "-"},
+				},
+				Manifests: []cardManifest{
+					{Image: "registry.example.com/app", Tags: "1.0, latest", Digest: "sha256:index"},
+				},
+			},
+			{
+				Name:      "worker",
+				Status:    "failure",
+				Duration:  "30s",
+				Error:     "build failed",
+				Images:    []cardImage{},
+				Manifests: []cardManifest{},
+			},
+		},
+	}
+
+	if got := newCardData(testReport()); !reflect.DeepEqual(got, want) {
+		t.Errorf("newCardData() = %+v, want %+v", got, want)
+	}
+}
+
+// readCard decodes the card file written by writeCard.
+func readCard(t *testing.T, data []byte) (string, cardData) {
+	t.Helper()
+
+	var card struct {
+		Schema string   `json:"schema"`
+		Data   cardData `json:"data"`
+	}
+	if err := json.Unmarshal(data, &card); err != nil {
+		t.Fatal(err)
+	}
+
+	return card.Schema, card.Data
+}
+
+func TestWriteCard(t *testing.T) {
+	const schema = "https://example.com/card.json"
+
+	path := filepath.Join(t.TempDir(), "card.json")
+	if err := writeCard(path, schema, testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotSchema, got := readCard(t, data)
+	if gotSchema != schema {
+		t.Errorf("schema = %s, want %s", gotSchema, schema)
+	}
+	if want := newCardData(testReport()); !reflect.DeepEqual(got, want) {
+		t.Errorf("card = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteCardStdout(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	err = writeCard("/dev/stdout", "https://example.com/card.json", testReport())
+	os.Stdout = stdout
+	_ = writer.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the card is encoded in base64 between the escape sequences read by the runner
+	line := string(output)
+	if !strings.HasPrefix(line, "\u001B]1338;") || !strings.HasSuffix(line, "\u001B]0m\n") {
+		t.Fatalf("unexpected card output %q", line)
+	}
+
+	encoded := strings.TrimSuffix(strings.TrimPrefix(line, "\u001B]1338;"), "\u001B]0m\n")
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, got := readCard(t, data); !reflect.DeepEqual(got, newCardData(testReport())) {
+		t.Errorf("card = %+v", got)
+	}
+}
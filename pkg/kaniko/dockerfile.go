@@ -0,0 +1,153 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// readInstructions returns the instructions of the dockerfile, with the line continuations
+// joined and the comments removed.
+func readInstructions(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var instructions []string
+	var current strings.Builder
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if rest, found := strings.CutSuffix(line, "\\"); found {
+			current.WriteString(rest + " ")
+			continue
+		}
+
+		current.WriteString(line)
+		if instruction := strings.TrimSpace(current.String()); instruction != "" {
+			instructions = append(instructions, instruction)
+		}
+		current.Reset()
+	}
+
+	if instruction := strings.TrimSpace(current.String()); instruction != "" {
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, scanner.Err()
+}
+
+// expandArgs replaces the $VAR, ${VAR} and ${VAR:-default} references with the arg values.
+func expandArgs(text string, args map[string]string) string {
+	return os.Expand(text, func(name string) string {
+		name, fallback, found := strings.Cut(name, ":-")
+		if value := args[name]; value != "" || !found {
+			return value
+		}
+
+		return fallback
+	})
+}
+
+// baseImages returns the base images of the build, if the dockerfile is in a local context.
+func (b *build) baseImages() []string {
+	images, _ := b.readBaseImages()
+	return images
+}
+
+// readBaseImages returns the base images of the build, or false if the dockerfile cannot be
+// read, like when the context is remote.
+func (b *build) readBaseImages() ([]string, bool) {
+	if !isLocalContext(&b.settings) {
+		return nil, false
+	}
+
+	path := resolveDockerfile(&b.settings)
+	if path == "" {
+		return nil, false
+	}
+
+	images, err := parseBaseImages(path, b.settings.BuildArgs)
+	if err != nil {
+		slog.Debug("Cannot read the base images", "dockerfile", path, "error", err)
+		return nil, false
+	}
+
+	return images, true
+}
+
+// parseBaseImages returns the external images used by the FROM instructions of the dockerfile,
+// with the global args replaced by their default or build arg value. Stages used as the base
+// of other stages and scratch are skipped.
+func parseBaseImages(path string, buildArgs []string) ([]string, error) {
+	instructions, err := readInstructions(path)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := make(map[string]string)
+	for _, entry := range buildArgs {
+		if key, value, found := strings.Cut(entry, "="); found {
+			overrides[key] = value
+		}
+	}
+
+	args := make(map[string]string)
+	stages := make(map[string]bool)
+
+	var images []string
+	seenFrom := false
+
+	for _, instruction := range instructions {
+		fields := strings.Fields(instruction)
+
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			// only the args declared before the first FROM can be used in FROM
+			if seenFrom || len(fields) < 2 {
+				continue
+			}
+			key, value, _ := strings.Cut(fields[1], "=")
+			if override, ok := overrides[key]; ok {
+				value = override
+			}
+			args[key] = strings.Trim(value, `"'`)
+		case "FROM":
+			seenFrom = true
+
+			var params []string
+			for _, field := range fields[1:] {
+				if !strings.HasPrefix(field, "--") {
+					params = append(params, field)
+				}
+			}
+
+			if len(params) == 0 {
+				continue
+			}
+
+			image := expandArgs(params[0], args)
+			if image != "" && image != "scratch" && !stages[strings.ToLower(image)] {
+				images = append(images, image)
+			}
+
+			if len(params) == 3 && strings.EqualFold(params[1], "AS") {
+				stages[strings.ToLower(params[2])] = true
+			}
+		}
+	}
+
+	return uniqueStrings(images), nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+)
+
+// dryRun runs the preflight checks and prints the commands that every build would run, without
+// building or pushing anything.
+func (p *pluginImpl) dryRun() error {
+	if err := preflight(p.builds); err != nil {
+		return fmt.Errorf("preflight checks failed: %w", err)
+	}
+
+	if err := p.printPlan(); err != nil {
+		return err
+	}
+
+	slog.Info("Dry run finished, nothing was built", "builds", len(p.builds))
+
+	return nil
+}
+
+// printPlan resolves the plan of every build and prints it to stdout.
+func (p *pluginImpl) printPlan() error {
+	plan, err := newPlan(p.builds)
+	if err != nil {
+		return err
+	}
+
+	return plan.write(os.Stdout, p.settings.Main.PlanFormat)
+}
+
+// preflight checks that the kaniko binaries and the scanner are present and that the registries of the
+// destinations and cache repositories can be reached with the current credentials.
+func preflight(builds []*build) error {
+	var errs []error
+
+	for _, path := range []string{kanikoExecutor, kanikoWarmer} {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("kaniko binary not found: %w", err))
+		}
+	}
+
+	checked := make(map[string]bool)
+
+	for _, b := range builds {
+		if command := b.scanCommand(); command != "" && !checked[command] {
+			checked[command] = true
+			if _, err := exec.LookPath(command); err != nil {
+				errs = append(errs, fmt.Errorf("scanner not found: %w", err))
+			}
+		}
+
+		var repos []string
+
+		if !b.settings.NoPush {
+			for _, destination := range b.destinations() {
+				tag, err := name.NewTag(destination)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("invalid destination: %s", destination))
+					continue
+				}
+				repos = append(repos, tag.Repository.Name())
+			}
+		}
+
+		if b.settings.Cache && b.settings.CacheRepo != "" {
+			repos = append(repos, b.settings.CacheRepo)
+		}
+
+		for _, repo := range repos {
+			if checked[repo] {
+				continue
+			}
+			checked[repo] = true
+
+			if _, err := crane.ListTags(repo, craneOptions(&b.settings, repo, false)...); err != nil {
+				errs = append(errs, fmt.Errorf("cannot access repository %s: %w", repo, err))
+				continue
+			}
+
+			slog.Info("Repository is reachable", "repo", repo)
+		}
+	}
+
+	return errors.Join(errs...)
+}
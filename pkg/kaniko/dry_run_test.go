@@ -0,0 +1,110 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.megpoid.dev/drone-kaniko/pkg/scan"
+)
+
+func TestPreflight(t *testing.T) {
+	var mu sync.Mutex
+	listed := make(map[string]int)
+
+	handler := newTestRegistry()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if repo, ok := strings.CutSuffix(r.URL.Path, "/tags/list"); ok {
+			mu.Lock()
+			listed[strings.TrimPrefix(repo, "/v2/")]++
+			mu.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	closed := httptest.NewServer(newTestRegistry())
+	closed.Close()
+	closedHost := strings.TrimPrefix(closed.URL, "http://")
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := name.NewTag(host + "/app:previous")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &build{
+		name: "app",
+		scan: &scanGate{scanner: &scan.CommandScanner{Command: "preflight-missing-scanner", Args: []string{scan.PlaceholderImage}}},
+	}
+	app.settings.Destinations = []string{host + "/app:1.0", host + "/app:latest"}
+	// a cache repository without layers yet is reachable
+	app.settings.Cache = true
+	app.settings.CacheRepo = host + "/app/cache"
+
+	// the repositories and scanner already checked aren't checked again
+	worker := &build{name: "worker", scan: app.scan}
+	worker.settings.Destinations = []string{host + "/app:worker", "registry.example.com/INVALID:1.0"}
+	worker.settings.Cache = true
+	worker.settings.CacheRepo = closedHost + "/worker/cache"
+
+	// nothing is pushed, so the destination isn't checked
+	local := &build{name: "local"}
+	local.settings.Destinations = []string{"unreachable.invalid/app:1.0"}
+	local.settings.NoPush = true
+
+	err = preflight([]*build{app, worker, local})
+	if err == nil {
+		t.Fatal("preflight passed")
+	}
+
+	message := err.Error()
+	for _, want := range []string{
+		"scanner not found: exec: \"preflight-missing-scanner\"",
+		"cannot access repository " + closedHost + "/worker/cache",
+		"invalid destination: registry.example.com/INVALID:1.0",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("error %q doesn't contain %q", message, want)
+		}
+	}
+
+	for _, unexpected := range []string{host + "/", "unreachable.invalid"} {
+		if strings.Contains(message, unexpected) {
+			t.Errorf("error %q contains %q", message, unexpected)
+		}
+	}
+
+	if strings.Count(message, "scanner not found") != 1 {
+		t.Errorf("scanner checked more than once: %s", message)
+	}
+
+	// the kaniko binaries are only found inside the kaniko image
+	if _, statErr := os.Stat(kanikoExecutor); statErr != nil && !strings.Contains(message, "kaniko binary not found") {
+		t.Errorf("error %q doesn't report the kaniko binaries", message)
+	}
+
+	if listed["app"] != 1 || listed["app/cache"] != 1 {
+		t.Errorf("listed repositories = %v, want app and app/cache once", listed)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// argFunc returns the arguments of a flag for the given settings, or nil to omit the flag.
+type argFunc func(flag string, settings *Settings) []string
+
+// kanikoFlag maps a setting to a flag of the kaniko executor or warmer.
+type kanikoFlag struct {
+	Name string
+	Args argFunc
+}
+
+// boolArg adds the flag when the value is true.
+func boolArg(value func(s *Settings) bool) argFunc {
+	return func(flag string, s *Settings) []string {
+		if value(s) {
+			return []string{"--" + flag}
+		}
+		return nil
+	}
+}
+
+// disabledArg adds the flag set to false when the value is false, for flags enabled by default.
+func disabledArg(value func(s *Settings) bool) argFunc {
+	return func(flag string, s *Settings) []string {
+		if !value(s) {
+			return []string{"--" + flag + "=false"}
+		}
+		return nil
+	}
+}
+
+// stringArg adds the flag when the value isn't empty.
+func stringArg(value func(s *Settings) string) argFunc {
+	return func(flag string, s *Settings) []string {
+		if v := value(s); v != "" {
+			return []string{"--" + flag, v}
+		}
+		return nil
+	}
+}
+
+// sliceArg adds the flag once per entry.
+func sliceArg(value func(s *Settings) []string) argFunc {
+	return func(flag string, s *Settings) []string {
+		var args []string
+		for _, entry := range value(s) {
+			args = append(args, "--"+flag, entry)
+		}
+		return args
+	}
+}
+
+// intArg adds the flag when the value is greater than the unset value.
+func intArg(value func(s *Settings) int, unset int) argFunc {
+	return func(flag string, s *Settings) []string {
+		if v := value(s); v > unset {
+			return []string{"--" + flag, strconv.Itoa(v)}
+		}
+		return nil
+	}
+}
+
+// durationArg adds the flag when the duration isn't zero.
+func durationArg(value func(s *Settings) time.Duration) argFunc {
+	return func(flag string, s *Settings) []string {
+		if v := value(s); v != 0 {
+			return []string{"--" + flag, v.String()}
+		}
+		return nil
+	}
+}
+
+// executorFlags are the flags passed to the kaniko executor.
+var executorFlags = []kanikoFlag{
+	{"build-arg", sliceArg(func(s *Settings) []string { return s.BuildArgs })},
+	{"cache", boolArg(func(s *Settings) bool { return s.Cache })},
+	{"cache-repo", stringArg(func(s *Settings) string {
+		if !s.Cache {
+			return ""
+		}
+		return s.CacheRepo
+	})},
+	{"cache-copy-layers", boolArg(func(s *Settings) bool { return s.CacheCopyLayers })},
+	{"cache-dir", stringArg(func(s *Settings) string { return s.CacheDir })},
+	{"cache-run-layers", disabledArg(func(s *Settings) bool { return s.CacheRunLayers })},
+	{"cache-ttl", durationArg(func(s *Settings) time.Duration { return s.CacheTTL })},
+	{"cleanup", boolArg(func(s *Settings) bool { return s.Cleanup })},
+	{"compressed-caching", disabledArg(func(s *Settings) bool { return s.CompressedCaching })},
+	{"compression", stringArg(func(s *Settings) string { return s.Compression })},
+	{"compression-level", intArg(func(s *Settings) int { return s.CompressionLevel }, -1)},
+	{"context", stringArg(func(s *Settings) string { return s.Context })},
+	{"context-sub-path", stringArg(func(s *Settings) string { return s.ContextSubPath })},
+	{"custom-platform", stringArg(func(s *Settings) string { return s.CustomPlatform })},
+	{"destination", sliceArg(platformDestinations)},
+	{"digest-file", stringArg(func(s *Settings) string { return s.DigestFile })},
+	{"dockerfile", stringArg(func(s *Settings) string { return s.Dockerfile })},
+	{"force", boolArg(func(s *Settings) bool { return s.Force })},
+	{"force-build-metadata", boolArg(func(s *Settings) bool { return s.ForceBuildMetadata })},
+	{"git", stringArg(func(s *Settings) string { return s.Git })},
+	{"ignore-path", sliceArg(func(s *Settings) []string { return s.IgnorePath })},
+	{"ignore-var-run", disabledArg(func(s *Settings) bool { return s.IgnoreVarRun })},
+	{"image-download-retry", intArg(func(s *Settings) int { return s.ImageDownloadRetry }, 0)},
+	{"image-fs-extract-retry", intArg(func(s *Settings) int { return s.ImageFsExtractRetry }, 0)},
+	{"image-name-tag-with-digest-file", stringArg(func(s *Settings) string { return s.ImageNameTagWithDigestFile })},
+	{"image-name-with-digest-file", stringArg(func(s *Settings) string { return s.ImageNameWithDigestFile })},
+	{"insecure", boolArg(func(s *Settings) bool { return s.Insecure })},
+	{"insecure-pull", boolArg(func(s *Settings) bool { return s.InsecurePull })},
+	{"insecure-registry", sliceArg(func(s *Settings) []string { return s.InsecureRegistries })},
+	{"kaniko-dir", stringArg(func(s *Settings) string { return s.KanikoDir })},
+	{"label", sliceArg(func(s *Settings) []string { return s.Labels })},
+	{"log-format", stringArg(func(s *Settings) string { return s.LogFormat })},
+	{"log-timestamp", boolArg(func(s *Settings) bool { return s.LogTimestamp })},
+	{"no-push", boolArg(func(s *Settings) bool { return s.NoPush })},
+	{"no-push-cache", boolArg(func(s *Settings) bool { return s.NoPushCache })},
+	{"oci-layout-path", stringArg(func(s *Settings) string { return s.OCILayoutPath })},
+	{"push-ignore-immutable-tag-errors", boolArg(func(s *Settings) bool { return s.PushIgnoreImmutableTagErrors })},
+	{"push-retry", intArg(func(s *Settings) int { return s.PushRetry }, 0)},
+	{"registry-certificate", sliceArg(func(s *Settings) []string { return s.RegistryCertificates })},
+	{"registry-client-cert", sliceArg(func(s *Settings) []string { return s.RegistryClientCerts })},
+	{"registry-map", sliceArg(func(s *Settings) []string { return s.RegistryMap })},
+	{"registry-mirror", stringArg(func(s *Settings) string { return s.RegistryMirror })},
+	{"reproducible", boolArg(func(s *Settings) bool { return s.Reproducible })},
+	{"single-snapshot", boolArg(func(s *Settings) bool { return s.SingleSnapshot })},
+	{"skip-default-registry-fallback", boolArg(func(s *Settings) bool { return s.SkipDefaultRegistryFallback })},
+	{"skip-push-permission-check", boolArg(func(s *Settings) bool { return s.SkipPushPermissionCheck })},
+	{"skip-tls-verify", boolArg(func(s *Settings) bool { return s.SkipTLSVerify })},
+	{"skip-tls-verify-pull", boolArg(func(s *Settings) bool { return s.SkipTLSVerifyPull })},
+	{"skip-tls-verify-registry", sliceArg(func(s *Settings) []string { return s.SkipTLSVerifyRegistries })},
+	{"skip-unused-stages", boolArg(func(s *Settings) bool { return s.SkipUnusedStages })},
+	{"snapshot-mode", stringArg(func(s *Settings) string { return s.SnapshotMode })},
+	{"tar-path", stringArg(func(s *Settings) string { return s.TarPath })},
+	{"target", stringArg(func(s *Settings) string { return s.Target })},
+	{"use-new-run", boolArg(func(s *Settings) bool { return s.UseNewRun })},
+	{"verbosity", stringArg(func(s *Settings) string { return s.Verbosity })},
+}
+
+// warmerFlags are the flags passed to the kaniko warmer.
+var warmerFlags = []kanikoFlag{
+	{"cache-dir", stringArg(func(s *Settings) string { return s.CacheDir })},
+	{"cache-ttl", durationArg(func(s *Settings) time.Duration { return s.CacheTTL })},
+	{"custom-platform", stringArg(func(s *Settings) string { return s.CustomPlatform })},
+	{"dockerfile", stringArg(func(s *Settings) string { return s.Dockerfile })},
+	{"force", boolArg(func(s *Settings) bool { return s.Main.ForceCache })},
+	{"image", sliceArg(func(s *Settings) []string { return s.Main.Images })},
+	{"insecure-pull", boolArg(func(s *Settings) bool { return s.InsecurePull })},
+	{"insecure-registry", sliceArg(func(s *Settings) []string { return s.InsecureRegistries })},
+	{"log-format", stringArg(func(s *Settings) string { return s.LogFormat })},
+	{"log-timestamp", boolArg(func(s *Settings) bool { return s.LogTimestamp })},
+	{"registry-certificate", sliceArg(func(s *Settings) []string { return s.RegistryCertificates })},
+	{"registry-client-cert", sliceArg(func(s *Settings) []string { return s.RegistryClientCerts })},
+	{"registry-map", sliceArg(func(s *Settings) []string { return s.RegistryMap })},
+	{"registry-mirror", stringArg(func(s *Settings) string { return s.RegistryMirror })},
+	{"skip-tls-verify-pull", boolArg(func(s *Settings) bool { return s.SkipTLSVerifyPull })},
+	{"skip-tls-verify-registry", sliceArg(func(s *Settings) []string { return s.SkipTLSVerifyRegistries })},
+	{"verbosity", stringArg(func(s *Settings) string { return s.Verbosity })},
+}
+
+// platformDestinations returns the destinations, with the architecture as tag suffix when
+// building one of several platforms.
+func platformDestinations(settings *Settings) []string {
+	if settings.CustomPlatform == "" || len(settings.Main.Platforms) == 0 {
+		return settings.Destinations
+	}
+
+	_, arch, _ := strings.Cut(settings.CustomPlatform, "/")
+
+	destinations := make([]string, 0, len(settings.Destinations))
+	for _, entry := range settings.Destinations {
+		destinations = append(destinations, entry+"-"+arch)
+	}
+
+	return destinations
+}
+
+// buildArgs returns the arguments of every flag followed by the extra arguments.
+func buildArgs(flags []kanikoFlag, settings *Settings, extra []string) []string {
+	var args []string
+	for _, flag := range flags {
+		args = append(args, flag.Args(flag.Name, settings)...)
+	}
+
+	return append(args, extra...)
+}
+
+var helpFlagRegexp = regexp.MustCompile(`(?m)^\s+(?:-\w, )?--([a-zA-Z0-9][a-zA-Z0-9-]*)`)
+
+// parseHelpFlags returns the flags listed in the help output of a cobra command.
+func parseHelpFlags(help string) map[string]bool {
+	flags := make(map[string]bool)
+	for _, match := range helpFlagRegexp.FindAllStringSubmatch(help, -1) {
+		flags[match[1]] = true
+	}
+
+	return flags
+}
+
+// compareFlags returns the mapped flags missing from the supported ones, and the supported
+// flags that aren't mapped.
+func compareFlags(flags []kanikoFlag, supported map[string]bool) (missing, unmapped []string) {
+	mapped := make(map[string]bool, len(flags))
+	for _, flag := range flags {
+		mapped[flag.Name] = true
+		if !supported[flag.Name] {
+			missing = append(missing, flag.Name)
+		}
+	}
+
+	for name := range supported {
+		if !mapped[name] && name != "help" {
+			unmapped = append(unmapped, name)
+		}
+	}
+	sort.Strings(unmapped)
+
+	return missing, unmapped
+}
+
+// CheckFlags compares the flags used by the plugin with the ones supported by the bundled
+// kaniko executor and warmer. Flags that kaniko doesn't support anymore are returned as an
+// error, while kaniko flags not exposed by the plugin are only reported.
+func CheckFlags() error {
+	tools := []struct {
+		path  string
+		flags []kanikoFlag
+	}{
+		{kanikoExecutor, executorFlags},
+		{kanikoWarmer, warmerFlags},
+	}
+
+	var errs []error
+
+	for _, tool := range tools {
+		output, err := exec.Command(tool.path, "--help").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to get the flags of %s: %w", tool.path, err)
+		}
+
+		missing, unmapped := compareFlags(tool.flags, parseHelpFlags(string(output)))
+		if len(unmapped) > 0 {
+			slog.Info("Kaniko flags not mapped by the plugin", "command", tool.path, "flags", unmapped)
+		}
+		if len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("%s doesn't support the flags: %s", tool.path, strings.Join(missing, ", ")))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,233 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// defaultFlagSettings returns the settings with the defaults of the plugin flags.
+func defaultFlagSettings() Settings {
+	return Settings{
+		CacheRunLayers:    true,
+		CompressedCaching: true,
+		IgnoreVarRun:      true,
+		CompressionLevel:  -1,
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags  []kanikoFlag
+		update func(s *Settings)
+		extra  []string
+		want   []string
+	}{
+		{
+			name:  "executor defaults",
+			flags: executorFlags,
+			update: func(s *Settings) {
+				s.Context = "/drone/src"
+				s.Dockerfile = "Dockerfile"
+				s.Destinations = []string{"registry.example.com/app:1.0"}
+			},
+			want: []string{
+				"--context", "/drone/src",
+				"--destination", "registry.example.com/app:1.0",
+				"--dockerfile", "Dockerfile",
+			},
+		},
+		{
+			name:  "disabled flags",
+			flags: executorFlags,
+			update: func(s *Settings) {
+				s.CacheRunLayers = false
+				s.CompressedCaching = false
+				s.IgnoreVarRun = false
+			},
+			want: []string{
+				"--cache-run-layers=false",
+				"--compressed-caching=false",
+				"--ignore-var-run=false",
+			},
+		},
+		{
+			name:  "compression level zero",
+			flags: executorFlags,
+			update: func(s *Settings) {
+				s.Compression = "zstd"
+				s.CompressionLevel = 0
+			},
+			want: []string{"--compression", "zstd", "--compression-level", "0"},
+		},
+		{
+			name:  "cache repo without cache",
+			flags: executorFlags,
+			update: func(s *Settings) {
+				s.CacheRepo = "registry.example.com/app/cache"
+			},
+			want: nil,
+		},
+		{
+			name:  "cache repo",
+			flags: executorFlags,
+			update: func(s *Settings) {
+				s.Cache = true
+				s.CacheRepo = "registry.example.com/app/cache"
+			},
+			want: []string{"--cache", "--cache-repo", "registry.example.com/app/cache"},
+		},
+		{
+			name:  "platform destinations",
+			flags: executorFlags,
+			update: func(s *Settings) {
+				s.Main.Platforms = []string{"linux/amd64", "linux/arm64"}
+				s.CustomPlatform = "linux/arm64"
+				s.Destinations = []string{"registry.example.com/app:1.0", "registry.example.com/app:latest"}
+			},
+			want: []string{
+				"--custom-platform", "linux/arm64",
+				"--destination", "registry.example.com/app:1.0-arm64",
+				"--destination", "registry.example.com/app:latest-arm64",
+			},
+		},
+		{
+			name:  "custom platform without platforms",
+			flags: executorFlags,
+			update: func(s *Settings) {
+				s.CustomPlatform = "linux/arm64"
+				s.Destinations = []string{"registry.example.com/app:1.0"}
+			},
+			want: []string{
+				"--custom-platform", "linux/arm64",
+				"--destination", "registry.example.com/app:1.0",
+			},
+		},
+		{
+			name:  "extra arguments",
+			flags: executorFlags,
+			update: func(s *Settings) {
+				s.BuildArgs = []string{"VERSION=1.0", "GOARCH=amd64"}
+			},
+			extra: []string{"--no-push"},
+			want: []string{
+				"--build-arg", "VERSION=1.0",
+				"--build-arg", "GOARCH=amd64",
+				"--no-push",
+			},
+		},
+		{
+			name:  "warmer",
+			flags: warmerFlags,
+			update: func(s *Settings) {
+				s.CustomPlatform = "linux/arm64"
+				s.CacheDir = "/cache"
+				s.Destinations = []string{"registry.example.com/app:1.0"}
+				s.CacheRunLayers = false
+				s.Main.ForceCache = true
+				s.Main.Images = []string{"alpine:3.20", "golang:1.22"}
+			},
+			want: []string{
+				"--cache-dir", "/cache",
+				"--custom-platform", "linux/arm64",
+				"--force",
+				"--image", "alpine:3.20",
+				"--image", "golang:1.22",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := defaultFlagSettings()
+			tt.update(&settings)
+
+			got := buildArgs(tt.flags, &settings, tt.extra)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHelpFlags(t *testing.T) {
+	help := `Usage:
+  warmer [flags]
+
+Flags:
+      --cache-dir string                  Specify a local directory to use as a cache. (default "/cache")
+  -f, --force                             Force cache overwriting.
+  -h, --help                              help for warmer
+  -i, --image multi-arg type              Image to cache. Set it repeatedly for multiple images.
+
+Use "warmer [command] --help" for more information about a command.
+`
+
+	want := map[string]bool{"cache-dir": true, "force": true, "help": true, "image": true}
+	if got := parseHelpFlags(help); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseHelpFlags() = %v, want %v", got, want)
+	}
+}
+
+func TestCompareFlags(t *testing.T) {
+	warmerHelp := readHelp(t, "warmer-1.23.2-help.txt")
+
+	tests := []struct {
+		name         string
+		help         string
+		flags        []kanikoFlag
+		wantMissing  []string
+		wantUnmapped []string
+	}{
+		{
+			name:         "executor",
+			help:         readHelp(t, "executor-1.23.2-help.txt"),
+			flags:        executorFlags,
+			wantUnmapped: []string{"annotation", "preserve-context"},
+		},
+		{
+			name:         "warmer",
+			help:         warmerHelp,
+			flags:        warmerFlags,
+			wantUnmapped: []string{"skip-default-registry-fallback"},
+		},
+		{
+			// older warmers only have the --customPlatform flag
+			name:         "warmer with customPlatform",
+			help:         strings.Replace(warmerHelp, "--custom-platform", "--customPlatform", 1),
+			flags:        warmerFlags,
+			wantMissing:  []string{"custom-platform"},
+			wantUnmapped: []string{"customPlatform", "skip-default-registry-fallback"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missing, unmapped := compareFlags(tt.flags, parseHelpFlags(tt.help))
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("missing = %q, want %q", missing, tt.wantMissing)
+			}
+			if !reflect.DeepEqual(unmapped, tt.wantUnmapped) {
+				t.Errorf("unmapped = %q, want %q", unmapped, tt.wantUnmapped)
+			}
+		})
+	}
+}
+
+// readHelp returns the help output of a kaniko 1.23.2 command saved in testdata.
+func readHelp(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
@@ -5,6 +5,7 @@
 package kaniko
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -15,6 +16,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	tags "github.com/drone-plugins/drone-docker"
@@ -29,51 +31,9 @@ type authEntry struct {
 	Auth string `json:"auth"`
 }
 
-func trace(cmd *exec.Cmd) {
-	_, _ = fmt.Fprintf(os.Stdout, "+ %s\n", strings.Join(cmd.Args, " "))
-}
-
-// helper function to add proxy values from the environment
-func addProxyBuildArgs(settings *Settings) {
-	addProxyValue(settings, "http_proxy")
-	addProxyValue(settings, "https_proxy")
-	addProxyValue(settings, "no_proxy")
-}
-
-// helper function to add the upper and lower case version of a proxy value.
-func addProxyValue(settings *Settings, key string) {
-	value := getProxyValue(key)
-
-	if len(value) > 0 && !hasProxyBuildArg(settings, key) {
-		settings.BuildArgs = append(settings.BuildArgs, fmt.Sprintf("%s=%s", key, value))
-		settings.BuildArgs = append(settings.BuildArgs, fmt.Sprintf("%s=%s", strings.ToUpper(key), value))
-	}
-}
-
-// helper function to get a proxy value from the environment.
-//
-// assumes that the upper and lower case versions of are the same.
-func getProxyValue(key string) string {
-	value := os.Getenv(key)
-
-	if len(value) > 0 {
-		return value
-	}
-
-	return os.Getenv(strings.ToUpper(key))
-}
-
-// helper function that looks to see if a proxy value was set in the build args.
-func hasProxyBuildArg(settings *Settings, key string) bool {
-	keyUpper := strings.ToUpper(key)
-
-	for _, s := range settings.BuildArgs {
-		if strings.HasPrefix(s, key) || strings.HasPrefix(s, keyUpper) {
-			return true
-		}
-	}
-
-	return false
+// trace logs the command before running it, with the attributes of the phase.
+func trace(cmd *exec.Cmd, attrs ...any) {
+	slog.Info("Running command", append([]any{"command", secrets.Mask(strings.Join(cmd.Args, " "))}, attrs...)...)
 }
 
 func generateAuthFile(settings *Auth) error {
@@ -133,12 +93,6 @@ func generateAuthFile(settings *Auth) error {
 	return nil
 }
 
-func addArgsFromEnv(settings *Settings) {
-	for _, entry := range settings.Main.BuildArgsFromEnv {
-		addProxyValue(settings, entry)
-	}
-}
-
 func enableCompatibilityMode(settings *Settings, pipeline *drone.Pipeline) error {
 	if settings.Main.Debug {
 		settings.Verbosity = "debug"
@@ -201,3 +155,89 @@ func generateLabelSchemas(settings *Settings, pipeline *drone.Pipeline) {
 		settings.Labels = append(settings.Labels, fmt.Sprintf("org.opencontainers.image.%s", label))
 	}
 }
+
+// uniqueStrings returns the entries without duplicates, preserving the original order.
+func uniqueStrings(entries []string) []string {
+	seen := make(map[string]bool, len(entries))
+	result := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if !seen[entry] {
+			seen[entry] = true
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// lineWriter is an io.Writer that calls a function for every complete line written to it.
+type lineWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	onLine func(line string)
+}
+
+func newLineWriter(onLine func(line string)) *lineWriter {
+	return &lineWriter{onLine: onLine}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		w.onLine(strings.TrimRight(string(w.buf[:idx]), "\r"))
+		w.buf = w.buf[idx+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush processes any remaining data that wasn't terminated by a newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.onLine(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// writeFileAtomic writes the file in a temporary file of the same directory and then renames
+// it, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	if err := os.Chmod(file.Name(), perm); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats, shared with kaniko
+const (
+	LogFormatText  = "text"
+	LogFormatColor = "color"
+	LogFormatJSON  = "json"
+)
+
+// ConfigureLogging sets the default logger of the plugin to follow the log-format and verbosity
+// of kaniko, with the secrets masked. The output of kaniko is parsed and logged again as records
+// of the plugin, so the whole run uses the same format.
+func ConfigureLogging(settings *Settings) {
+	verbosity := settings.Verbosity
+	if settings.Main.Debug {
+		verbosity = "debug"
+	}
+
+	slog.SetDefault(slog.New(secrets.NewHandler(newLogHandler(os.Stderr, settings.LogFormat, verbosity))))
+}
+
+// newLogHandler returns the slog handler for the log format.
+func newLogHandler(w io.Writer, format, verbosity string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: parseLevel(verbosity)}
+
+	switch format {
+	case LogFormatJSON:
+		return slog.NewJSONHandler(w, opts)
+	case LogFormatColor:
+		return &colorHandler{w: w, mu: &sync.Mutex{}, level: opts.Level}
+	default:
+		return slog.NewTextHandler(w, opts)
+	}
+}
+
+// parseLevel maps the kaniko (logrus) levels to the slog ones, also in the four letters form of
+// the text output.
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "trace", "debug", "trac", "debu":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error", "fatal", "panic", "erro", "fata", "pani":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// colorHandler prints the records like the colored output of kaniko.
+type colorHandler struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *colorHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *colorHandler) Handle(_ context.Context, record slog.Record) error {
+	color := 36
+	switch {
+	case record.Level >= slog.LevelError:
+		color = 31
+	case record.Level >= slog.LevelWarn:
+		color = 33
+	case record.Level < slog.LevelInfo:
+		color = 37
+	}
+
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "\x1b[%dm%-5s\x1b[0m[%s] %-44s", color, record.Level.String(), record.Time.Format(time.TimeOnly), record.Message)
+
+	writeAttr := func(attr slog.Attr) {
+		_, _ = fmt.Fprintf(&buf, " \x1b[%dm%s\x1b[0m=%v", color, attr.Key, attr.Value.Resolve())
+	}
+
+	for _, attr := range h.attrs {
+		writeAttr(attr)
+	}
+
+	record.Attrs(func(attr slog.Attr) bool {
+		attr.Key = h.prefix + attr.Key
+		writeAttr(attr)
+		return true
+	})
+
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := h.w.Write(buf.Bytes())
+
+	return err
+}
+
+func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := *h
+	handler.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	handler.attrs = append(handler.attrs, h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.prefix + attr.Key
+		handler.attrs = append(handler.attrs, attr)
+	}
+
+	return &handler
+}
+
+func (h *colorHandler) WithGroup(name string) slog.Handler {
+	handler := *h
+	handler.prefix = h.prefix + name + "."
+
+	return &handler
+}
+
+var (
+	logfmtLevelRegexp = regexp.MustCompile(`(?:^|\s)level=(\w+)`)
+	logfmtMsgRegexp   = regexp.MustCompile(`(?:^|\s)msg=(?:"((?:[^"\\]|\\.)*)"|(\S+))`)
+
+	// colored lines look like "\x1b[36mINFO\x1b[0m[0000] msg", with the time instead of the
+	// elapsed seconds if log-timestamp is set
+	ansiRegexp       = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	colorEntryRegexp = regexp.MustCompile(`^(TRAC|DEBU|INFO|WARN|ERRO|FATA|PANI)\[[^\]]*\]\s*(.*)$`)
+)
+
+// parseKanikoLine reads a log record written by kaniko, in JSON, text (logfmt) or color format.
+// Other lines, like the output of the RUN instructions, aren't records and return false.
+func parseKanikoLine(line string) (slog.Level, string, []any, bool) {
+	if strings.HasPrefix(line, "{") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err == nil {
+			level, _ := entry["level"].(string)
+			msg, _ := entry["msg"].(string)
+
+			var fields []any
+			for key, value := range entry {
+				if key != "level" && key != "msg" && key != "time" {
+					fields = append(fields, key, value)
+				}
+			}
+
+			return parseLevel(level), msg, fields, true
+		}
+	}
+
+	if match := logfmtMsgRegexp.FindStringSubmatch(line); match != nil {
+		if level := logfmtLevelRegexp.FindStringSubmatch(line); level != nil {
+			msg := match[2]
+			if match[1] != "" {
+				msg = strings.ReplaceAll(match[1], `\"`, `"`)
+			}
+			return parseLevel(level[1]), msg, nil, true
+		}
+	}
+
+	if match := colorEntryRegexp.FindStringSubmatch(ansiRegexp.ReplaceAllString(line, "")); match != nil {
+		return parseLevel(match[1]), strings.TrimSpace(match[2]), nil, true
+	}
+
+	return slog.LevelInfo, line, nil, false
+}
+
+// newOutputWriters returns the writers for the stdout and stderr of a kaniko command. The log
+// records of kaniko are logged again as records with the given attributes, masked by the default
+// logger. The other lines, like the output of the RUN instructions, are written as is to the same
+// stream, masked, since they would be dropped by the log level otherwise while kaniko already
+// filters its own records with the verbosity. Each stream has its own writer, so a partial line
+// of one isn't joined with the other.
+func newOutputWriters(attrs ...any) (stdout, stderr io.Writer, flush func()) {
+	logger := slog.Default().With(append([]any{"source", "kaniko"}, attrs...)...)
+	outputLine := func(w io.Writer) func(line string) {
+		return func(line string) {
+			level, msg, fields, ok := parseKanikoLine(line)
+			if !ok {
+				_, _ = io.WriteString(w, secrets.Mask(line)+"\n")
+				return
+			}
+			logger.Log(context.Background(), level, msg, fields...)
+		}
+	}
+
+	stdoutLines := newLineWriter(outputLine(os.Stdout))
+	stderrLines := newLineWriter(outputLine(os.Stderr))
+
+	return stdoutLines, stderrLines, func() {
+		stdoutLines.Flush()
+		stderrLines.Flush()
+	}
+}
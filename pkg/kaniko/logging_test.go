@@ -0,0 +1,172 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseKanikoLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantLevel  slog.Level
+		wantMsg    string
+		wantFields []any
+		wantRecord bool
+	}{
+		{
+			name:       "json",
+			line:       `{"level":"warning","msg":"Error uploading layer to cache","time":"2024-07-10T12:10:59Z","layer":"sha256:abc"}`,
+			wantLevel:  slog.LevelWarn,
+			wantMsg:    "Error uploading layer to cache",
+			wantFields: []any{"layer", "sha256:abc"},
+			wantRecord: true,
+		},
+		{
+			name:       "text",
+			line:       `time="2024-07-10T12:10:59Z" level=info msg="Retrieving image manifest alpine:3.20"`,
+			wantLevel:  slog.LevelInfo,
+			wantMsg:    "Retrieving image manifest alpine:3.20",
+			wantRecord: true,
+		},
+		{
+			name:       "color",
+			line:       "\x1b[36mINFO\x1b[0m[0003] Retrieving image manifest alpine:3.20",
+			wantLevel:  slog.LevelInfo,
+			wantMsg:    "Retrieving image manifest alpine:3.20",
+			wantRecord: true,
+		},
+		{
+			name:       "color with timestamp",
+			line:       "\x1b[31mERRO\x1b[0m[2024-07-10T12:10:59Z] Error building image: unexpected EOF",
+			wantLevel:  slog.LevelError,
+			wantMsg:    "Error building image: unexpected EOF",
+			wantRecord: true,
+		},
+		{
+			name:       "color debug",
+			line:       "\x1b[37mDEBU\x1b[0m[0001] Skip resolving path /",
+			wantLevel:  slog.LevelDebug,
+			wantMsg:    "Skip resolving path /",
+			wantRecord: true,
+		},
+		{
+			name:      "run output",
+			line:      "(1/4) Installing ca-certificates (20240705-r0)",
+			wantLevel: slog.LevelInfo,
+			wantMsg:   "(1/4) Installing ca-certificates (20240705-r0)",
+		},
+		{
+			name:      "colored run output",
+			line:      "\x1b[32mok\x1b[0m  example.com/app  0.012s",
+			wantLevel: slog.LevelInfo,
+			wantMsg:   "\x1b[32mok\x1b[0m  example.com/app  0.012s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, msg, fields, record := parseKanikoLine(tt.line)
+			if level != tt.wantLevel || msg != tt.wantMsg || !reflect.DeepEqual(fields, tt.wantFields) || record != tt.wantRecord {
+				t.Errorf("parseKanikoLine() = %s, %q, %v, %t, want %s, %q, %v, %t", level, msg, fields, record, tt.wantLevel, tt.wantMsg, tt.wantFields, tt.wantRecord)
+			}
+		})
+	}
+}
+
+// capture replaces the stdout and stderr while running fn, returning what was written to them.
+func capture(t *testing.T, fn func()) (string, string) {
+	t.Helper()
+
+	read := func(target **os.File) func() string {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		original := *target
+		*target = writer
+
+		output := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(reader)
+			output <- string(data)
+		}()
+
+		return func() string {
+			*target = original
+			_ = writer.Close()
+			return <-output
+		}
+	}
+
+	stdout := read(&os.Stdout)
+	stderr := read(&os.Stderr)
+	fn()
+
+	return stdout(), stderr()
+}
+
+func TestOutputWriters(t *testing.T) {
+	var buf bytes.Buffer
+
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelError})))
+	defer slog.SetDefault(defaultLogger)
+
+	secrets.Add("s3cr3t-token")
+
+	stdoutText, stderrText := capture(t, func() {
+		stdout, stderr, flush := newOutputWriters("platform", "linux/amd64")
+
+		// the partial lines of each stream are kept apart
+		_, _ = stdout.Write([]byte("\x1b[36mINFO\x1b[0m[0001] Unpacking "))
+		_, _ = stderr.Write([]byte("\x1b[31mERRO\x1b[0m[0001] Error uploading layer\n"))
+		_, _ = stdout.Write([]byte("rootfs as cmd COPY\n"))
+		_, _ = stdout.Write([]byte("curl -H 'Authorization: s3cr3t-token' https://example.com\n\n"))
+		_, _ = stderr.Write([]byte("partial line"))
+		flush()
+	})
+
+	// the output that isn't a record is never filtered by the log level
+	if want := "curl -H 'Authorization: ********' https://example.com\n\n"; stdoutText != want {
+		t.Errorf("stdout = %q, want %q", stdoutText, want)
+	}
+	if want := "partial line\n"; stderrText != want {
+		t.Errorf("stderr = %q, want %q", stderrText, want)
+	}
+
+	type record struct {
+		Level    string `json:"level"`
+		Msg      string `json:"msg"`
+		Source   string `json:"source"`
+		Platform string `json:"platform"`
+	}
+
+	var records []record
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var r record
+		if err := decoder.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+
+	// the info record is filtered, like kaniko does with the same verbosity
+	want := []record{
+		{Level: "ERROR", Msg: "Error uploading layer", Source: "kaniko", Platform: "linux/amd64"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %+v, want %+v", records, want)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+)
+
+const metricsPrefix = "drone_kaniko_"
+
+const (
+	metricGauge   = "gauge"
+	metricCounter = "counter"
+)
+
+// kaniko messages that tell if a layer was found in the cache
+const (
+	kanikoCacheHit  = "Using caching version of cmd"
+	kanikoCacheMiss = "No cached layer found for cmd"
+	kanikoPushStart = "Pushing image to"
+)
+
+type metricFamily struct {
+	name    string
+	help    string
+	typ     string
+	samples map[string]float64
+}
+
+// metrics collects the measures of the run, to be exported in the Prometheus text format. A
+// nil metrics doesn't collect anything.
+type metrics struct {
+	mu       sync.Mutex
+	labels   []string
+	families map[string]*metricFamily
+}
+
+// newMetrics returns the metrics collector if an export is configured, or nil otherwise. Every
+// sample has the repository and branch of the pipeline as labels.
+func newMetrics(settings *Settings, pipeline *drone.Pipeline) *metrics {
+	if settings.Main.MetricsFile == "" && settings.Main.MetricsPushgateway == "" {
+		return nil
+	}
+
+	return &metrics{
+		labels:   []string{"repo", pipeline.Repo.Slug, "branch", currentBranch(pipeline)},
+		families: make(map[string]*metricFamily),
+	}
+}
+
+func (m *metrics) record(name, help, typ string, value float64, add bool, labels ...string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{name: name, help: help, typ: typ, samples: make(map[string]float64)}
+		m.families[name] = family
+	}
+
+	key := formatLabels(append(append([]string{}, m.labels...), labels...))
+	if add {
+		family.samples[key] += value
+	} else {
+		family.samples[key] = value
+	}
+}
+
+func (m *metrics) phaseDuration(phase, platform string, duration time.Duration) {
+	m.record("phase_duration_seconds", "Duration of the phases of the build", metricGauge, duration.Seconds(), false, "phase", phase, "platform", platform)
+}
+
+func (m *metrics) cacheResult(platform string, hit bool) {
+	if hit {
+		m.record("cache_hits", "Layers found in the cache", metricCounter, 1, true, "platform", platform)
+	} else {
+		m.record("cache_misses", "Layers not found in the cache", metricCounter, 1, true, "platform", platform)
+	}
+}
+
+func (m *metrics) imageSize(platform, destination string, size int64) {
+	m.record("image_size_bytes", "Compressed size of the pushed image", metricGauge, float64(size), false, "platform", platform, "destination", destination)
+}
+
+func (m *metrics) retry(operation string) {
+	m.record("retries", "Retried operations", metricCounter, 1, true, "operation", operation)
+}
+
+func (m *metrics) buildResult(name string, duration time.Duration, err error) {
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+
+	m.record("build_success", "Whether the build succeeded", metricGauge, success, false, "build", name)
+	m.record("build_duration_seconds", "Duration of the build", metricGauge, duration.Seconds(), false, "build", name)
+}
+
+// outputObserver returns a function that reads the kaniko output for the cache results and
+// the start of the push.
+func (m *metrics) outputObserver(platform string, pushStart *time.Time) func(line string) {
+	if m == nil {
+		return nil
+	}
+
+	return func(line string) {
+		switch {
+		case strings.Contains(line, kanikoCacheHit):
+			m.cacheResult(platform, true)
+		case strings.Contains(line, kanikoCacheMiss):
+			m.cacheResult(platform, false)
+		case strings.Contains(line, kanikoPushStart) && pushStart.IsZero():
+			*pushStart = time.Now()
+		}
+	}
+}
+
+// formatLabels renders the label pairs, sorted by name.
+func formatLabels(pairs []string) string {
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		labels = append(labels, pairs[i]+`="`+escapeLabel(pairs[i+1])+`"`)
+	}
+	sort.Strings(labels)
+
+	return strings.Join(labels, ",")
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// render returns the metrics in the Prometheus text format, or in the OpenMetrics format which
+// declares the counters without the _total suffix and ends with an EOF marker.
+func (m *metrics) render(openMetrics bool) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+
+	for _, name := range names {
+		family := m.families[name]
+
+		sampleName := metricsPrefix + family.name
+		familyName := sampleName
+		if family.typ == metricCounter {
+			sampleName += "_total"
+			if !openMetrics {
+				familyName = sampleName
+			}
+		}
+
+		_, _ = fmt.Fprintf(&buf, "# HELP %s %s\n", familyName, family.help)
+		_, _ = fmt.Fprintf(&buf, "# TYPE %s %s\n", familyName, family.typ)
+
+		keys := make([]string, 0, len(family.samples))
+		for key := range family.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			_, _ = fmt.Fprintf(&buf, "%s{%s} %g\n", sampleName, key, family.samples[key])
+		}
+	}
+
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+
+	return buf.Bytes()
+}
+
+// export writes the metrics to the metrics file and pushes them to the Pushgateway, if set.
+func (m *metrics) export(ctx context.Context, settings *Settings, client *http.Client) error {
+	if m == nil {
+		return nil
+	}
+
+	if settings.Main.MetricsFile != "" {
+		if err := os.WriteFile(settings.Main.MetricsFile, m.render(true), 0o644); err != nil {
+			return fmt.Errorf("failed to write metrics: %w", err)
+		}
+		slog.Info("Metrics saved", "path", settings.Main.MetricsFile)
+	}
+
+	if settings.Main.MetricsPushgateway != "" {
+		if err := m.push(ctx, settings.Main.MetricsPushgateway, settings.Main.MetricsJob, client); err != nil {
+			return err
+		}
+		slog.Info("Metrics pushed", "url", settings.Main.MetricsPushgateway, "job", settings.Main.MetricsJob)
+	}
+
+	return nil
+}
+
+// groupingPath returns the path of the Pushgateway group of the job, with the common labels as
+// grouping key so the runs of other repositories and branches don't replace each other.
+func (m *metrics) groupingPath(job string) string {
+	path := "/metrics" + groupingLabel("job", job)
+	for i := 0; i+1 < len(m.labels); i += 2 {
+		path += groupingLabel(m.labels[i], m.labels[i+1])
+	}
+
+	return path
+}
+
+// groupingLabel returns the path segments of a grouping label. Values with a slash, like the
+// repository, or empty are encoded in base64 as the Pushgateway requires.
+func groupingLabel(name, value string) string {
+	if value == "" || strings.Contains(value, "/") {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+		if encoded == "" {
+			encoded = "="
+		}
+		return "/" + name + "@base64/" + encoded
+	}
+
+	return "/" + name + "/" + url.PathEscape(value)
+}
+
+// push replaces the metrics of the group of the job in a Pushgateway compatible endpoint.
+func (m *metrics) push(ctx context.Context, gateway, job string, client *http.Client) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	endpoint := strings.TrimSuffix(gateway, "/") + m.groupingPath(job)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(m.render(false)))
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("failed to push metrics: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
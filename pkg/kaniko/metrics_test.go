@@ -0,0 +1,144 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+)
+
+func testPipeline() *drone.Pipeline {
+	pipeline := &drone.Pipeline{}
+	pipeline.Repo.Slug = "octocat/hello-world"
+	pipeline.Build.Branch = "feature/login"
+
+	return pipeline
+}
+
+func TestMetricsPush(t *testing.T) {
+	var method, path, contentType, body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, contentType, body = r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	settings := &Settings{}
+	settings.Main.MetricsPushgateway = server.URL + "/"
+	settings.Main.MetricsJob = "drone-kaniko"
+
+	m := newMetrics(settings, testPipeline())
+	m.buildResult("app", 2500*time.Millisecond, nil)
+	m.cacheResult("linux/amd64", true)
+	m.cacheResult("linux/amd64", true)
+	m.retry("push")
+
+	if err := m.export(context.Background(), settings, server.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	if method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", method)
+	}
+
+	wantPath := "/metrics/job/drone-kaniko/repo@base64/b2N0b2NhdC9oZWxsby13b3JsZA/branch@base64/ZmVhdHVyZS9sb2dpbg"
+	if path != wantPath {
+		t.Errorf("path = %s, want %s", path, wantPath)
+	}
+
+	if !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("content type = %s", contentType)
+	}
+
+	wantBody := `# HELP drone_kaniko_build_duration_seconds Duration of the build
+# TYPE drone_kaniko_build_duration_seconds gauge
+drone_kaniko_build_duration_seconds{branch="feature/login",build="app",repo="octocat/hello-world"} 2.5
+# HELP drone_kaniko_build_success Whether the build succeeded
+# TYPE drone_kaniko_build_success gauge
+drone_kaniko_build_success{branch="feature/login",build="app",repo="octocat/hello-world"} 1
+# HELP drone_kaniko_cache_hits_total Layers found in the cache
+# TYPE drone_kaniko_cache_hits_total counter
+drone_kaniko_cache_hits_total{branch="feature/login",platform="linux/amd64",repo="octocat/hello-world"} 2
+# HELP drone_kaniko_retries_total Retried operations
+# TYPE drone_kaniko_retries_total counter
+drone_kaniko_retries_total{branch="feature/login",operation="push",repo="octocat/hello-world"} 1
+`
+	if body != wantBody {
+		t.Errorf("body = %s, want %s", body, wantBody)
+	}
+}
+
+func TestMetricsPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	settings := &Settings{}
+	settings.Main.MetricsPushgateway = server.URL
+	settings.Main.MetricsJob = "drone-kaniko"
+
+	m := newMetrics(settings, testPipeline())
+	m.retry("push")
+
+	if err := m.export(context.Background(), settings, server.Client()); err == nil {
+		t.Error("expected error for a rejected push")
+	}
+}
+
+func TestGroupingLabel(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+	}{
+		{"job", "drone-kaniko", "/job/drone-kaniko"},
+		{"branch", "main", "/branch/main"},
+		{"repo", "octocat/hello-world", "/repo@base64/b2N0b2NhdC9oZWxsby13b3JsZA"},
+		{"branch", "", "/branch@base64/="},
+	}
+
+	for _, tt := range tests {
+		if got := groupingLabel(tt.name, tt.value); got != tt.want {
+			t.Errorf("groupingLabel(%q, %q) = %s, want %s", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	m := newMetrics(&Settings{}, testPipeline())
+	if m != nil {
+		t.Fatal("metrics enabled without a metrics file or Pushgateway")
+	}
+
+	// a nil collector ignores every measure
+	m.buildResult("app", time.Second, nil)
+	m.phaseDuration(phaseBuild, "linux/amd64", time.Second)
+	m.imageSize("linux/amd64", "registry.example.com/app:1.0", 1024)
+
+	if observer := m.outputObserver("linux/amd64", &time.Time{}); observer != nil {
+		t.Error("nil metrics returned an output observer")
+	}
+
+	settings := &Settings{}
+	settings.Main.MetricsFile = filepath.Join(t.TempDir(), "metrics.prom")
+
+	if err := m.export(context.Background(), settings, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(settings.Main.MetricsFile); !os.IsNotExist(err) {
+		t.Errorf("nil metrics wrote the metrics file: %v", err)
+	}
+}
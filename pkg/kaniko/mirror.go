@@ -0,0 +1,204 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+	"go.megpoid.dev/drone-kaniko/pkg/tracing"
+	"gopkg.in/yaml.v3"
+)
+
+// mirror is a repository that receives a copy of the pushed images, with its own credentials.
+// The docker config is used if it doesn't have credentials.
+type mirror struct {
+	Repo     string `yaml:"repo"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// environment variable with the password, to use a Drone secret
+	PasswordEnv string `yaml:"password-env"`
+	Insecure    bool   `yaml:"insecure"`
+}
+
+// MirrorResult is the copy of the pushed image or manifest list in a mirror.
+type MirrorResult struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Tags        []string `json:"tags,omitempty"`
+	Digest      string   `json:"digest,omitempty"`
+	Status      string   `json:"status"`
+	Duration    string   `json:"duration"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// parseMirrors parses the YAML or JSON list of mirrors given by the mirrors setting.
+func parseMirrors(text string) ([]mirror, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(text)))
+	decoder.KnownFields(true)
+
+	var mirrors []mirror
+	if err := decoder.Decode(&mirrors); err != nil {
+		return nil, fmt.Errorf("failed to parse mirrors: %w", err)
+	}
+
+	var errs []error
+
+	for idx := range mirrors {
+		m := &mirrors[idx]
+
+		if m.Repo == "" {
+			errs = append(errs, fmt.Errorf("mirror %d has no repo", idx+1))
+			continue
+		}
+
+		if _, err := name.NewRepository(m.Repo); err != nil {
+			errs = append(errs, fmt.Errorf("invalid mirror repo %s: %w", m.Repo, err))
+		}
+
+		if m.PasswordEnv != "" {
+			if m.Password != "" {
+				errs = append(errs, fmt.Errorf("mirror %s cannot have both password and password-env", m.Repo))
+			}
+			m.Password = os.Getenv(m.PasswordEnv)
+		}
+
+		if (m.Username == "") != (m.Password == "") {
+			errs = append(errs, fmt.Errorf("mirror %s must have both username and password", m.Repo))
+		}
+
+		secrets.Add(m.Password)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return mirrors, nil
+}
+
+// options returns the registry options of the mirror.
+func (m mirror) options(ctx context.Context, settings *Settings) []crane.Option {
+	opts := append(craneOptions(settings, m.Repo, false), crane.WithContext(ctx))
+	if m.Insecure {
+		opts = append(opts, crane.WithInsecure())
+	}
+	if m.Username != "" {
+		opts = append(opts, crane.WithAuth(m.Username, m.Password))
+	}
+
+	return opts
+}
+
+// mirrorSource returns the repository of the first destination and its tags, the ones copied to
+// the mirrors.
+func mirrorSource(destinations []string) (string, []string) {
+	var repo string
+	var tags []string
+
+	for _, destination := range destinations {
+		tag, err := name.NewTag(destination)
+		if err != nil {
+			continue
+		}
+
+		if repo == "" {
+			repo = tag.Repository.Name()
+		}
+
+		if tag.Repository.Name() == repo {
+			tags = append(tags, tag.TagStr())
+		}
+	}
+
+	return repo, tags
+}
+
+// replicate copies the pushed image or manifest list, by digest, to every mirror at the same
+// time. Every mirror is attempted even if some fail.
+func (b *build) replicate(ctx context.Context, digest string) error {
+	if len(b.mirrors) == 0 {
+		return nil
+	}
+
+	repo, tags := mirrorSource(b.settings.Destinations)
+	if repo == "" || digest == "" {
+		return errors.New("cannot replicate to the mirrors, the digest of the pushed image is unknown")
+	}
+
+	source := repo + "@" + digest
+
+	srcOpts := append(craneOptions(&b.settings, repo, false), crane.WithContext(ctx))
+
+	results := make([]MirrorResult, len(b.mirrors))
+	retry := newRetryPolicy(&b.settings, b.metrics)
+
+	var wg sync.WaitGroup
+
+	for idx, m := range b.mirrors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			phase := phaseMirror + " " + m.Repo
+
+			spanCtx, span := tracing.Start(ctx, phaseMirror, "source", source, "destination", m.Repo, "tags", tags)
+
+			start := time.Now()
+			var copied string
+			err := retry.do(spanCtx, phase, func() error {
+				var err error
+				copied, err = crane.Replicate(source, m.Repo, tags, srcOpts, m.options(spanCtx, &b.settings))
+				return err
+			})
+			span.SetAttributes("digest", copied)
+			span.End(err)
+
+			result := MirrorResult{
+				Source:      source,
+				Destination: m.Repo,
+				Tags:        tags,
+				Digest:      copied,
+				Status:      StatusSuccess,
+				Duration:    time.Since(start).Round(time.Millisecond).String(),
+			}
+
+			if err != nil {
+				result.Status = StatusFailure
+				result.Error = secrets.Mask(err.Error())
+				results[idx] = result
+
+				slog.Error("Failed to replicate to mirror", "destination", m.Repo, "error", err)
+				return
+			}
+
+			results[idx] = result
+
+			slog.Info("Phase finished", "phase", phaseMirror, "destination", m.Repo, "digest", copied, "duration", time.Since(start))
+			b.metrics.phaseDuration(phaseMirror, "", time.Since(start))
+		}()
+	}
+
+	wg.Wait()
+
+	b.results.Mirrors = append(b.results.Mirrors, results...)
+
+	var errs []error
+	for _, result := range results {
+		if result.Status != StatusSuccess {
+			errs = append(errs, fmt.Errorf("failed to replicate to %s: %s", result.Destination, result.Error))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Formats of the outputs file
+const (
+	OutputsFormatDotenv = "dotenv"
+	OutputsFormatJSON   = "json"
+)
+
+var outputNameRegexp = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// outputName returns the name as a valid environment variable name.
+func outputName(name string) string {
+	return outputNameRegexp.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+// outputs returns the values of the build that later steps may need, like the digests and
+// tags. Values that aren't known, e.g. the digests if the image wasn't pushed, are omitted.
+func (b *build) outputs() map[string]string {
+	values := make(map[string]string)
+
+	var tags []string
+	for _, destination := range b.settings.Destinations {
+		if tag, err := name.NewTag(destination); err == nil {
+			tags = append(tags, tag.TagStr())
+		}
+	}
+	values["IMAGE_TAGS"] = strings.Join(uniqueStrings(tags), ",")
+
+	var digest string
+
+	for _, image := range b.results.Images {
+		// the target stage is an intermediate image, not the result of the build
+		if image.Target != "" || image.Digest == "" {
+			continue
+		}
+
+		digest = image.Digest
+		if image.Platform != "" {
+			_, arch, _ := strings.Cut(image.Platform, "/")
+			values["IMAGE_DIGEST_"+outputName(arch)] = image.Digest
+		}
+	}
+
+	// the manifest list is what the destinations point to with multiple platforms
+	if len(b.results.Manifests) > 0 && b.results.Manifests[0].Digest != "" {
+		digest = b.results.Manifests[0].Digest
+		values["IMAGE_INDEX_DIGEST"] = digest
+	}
+
+	if digest != "" {
+		values["IMAGE_DIGEST"] = digest
+
+		if len(b.settings.Destinations) > 0 {
+			if tag, err := name.NewTag(b.settings.Destinations[0]); err == nil {
+				values["IMAGE_REF_WITH_DIGEST"] = tag.Repository.Name() + "@" + digest
+			}
+		}
+	}
+
+	for key, value := range values {
+		if value == "" {
+			delete(values, key)
+		}
+	}
+
+	return values
+}
+
+// outputsFormat returns the format of the outputs file, guessed from the extension if not set.
+func outputsFormat(path, format string) string {
+	if format != "" {
+		return format
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return OutputsFormatJSON
+	}
+
+	return OutputsFormatDotenv
+}
+
+// writeOutputs saves the outputs of the successful builds. If the step has more than one build
+// then the names are prefixed with the build name, e.g. API_IMAGE_DIGEST.
+func writeOutputs(path, format string, builds []*build, report *Report) error {
+	values := make(map[string]string)
+
+	for idx, b := range builds {
+		if report.Builds[idx].Status != StatusSuccess {
+			continue
+		}
+
+		prefix := ""
+		if len(builds) > 1 {
+			prefix = outputName(b.name) + "_"
+		}
+
+		for key, value := range b.outputs() {
+			values[prefix+key] = value
+		}
+	}
+
+	var data []byte
+
+	switch outputsFormat(path, format) {
+	case OutputsFormatJSON:
+		var err error
+		if data, err = json.MarshalIndent(values, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	default:
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var buf bytes.Buffer
+		for _, key := range keys {
+			_, _ = fmt.Fprintf(&buf, "%s=%s\n", key, values[key])
+		}
+		data = buf.Bytes()
+	}
+
+	if err := writeFileAtomic(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write outputs: %w", err)
+	}
+
+	slog.Info("Build outputs saved", "path", path, "values", len(values))
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testOutputBuilds returns a multi-platform build that pushed its test stage, a single platform
+// build and a failed one.
+func testOutputBuilds() ([]*build, *Report) {
+	api := &build{name: "api", results: &buildResults{
+		Images: []ImageResult{
+			{Target: "test", Platform: "linux/amd64", Digest: "sha256:test"},
+			{Platform: "linux/amd64", Digest: "sha256:amd64"},
+			{Platform: "linux/arm64/v8", Digest: "sha256:arm64"},
+		},
+		Manifests: []ManifestResult{
+			{Destination: "registry.example.com/api", Tags: []string{"1.0", "latest"}, Digest: "sha256:index"},
+		},
+	}}
+	api.settings.Destinations = []string{"registry.example.com/api:1.0", "registry.example.com/api:latest", "registry.example.com/api:1.0"}
+
+	worker := &build{name: "worker-v2", results: &buildResults{
+		Images: []ImageResult{{Digest: "sha256:worker"}},
+	}}
+	worker.settings.Destinations = []string{"registry.example.com/worker:1.0"}
+
+	broken := &build{name: "broken", results: &buildResults{
+		Images: []ImageResult{{Digest: "sha256:broken"}},
+	}}
+	broken.settings.Destinations = []string{"registry.example.com/broken:1.0"}
+
+	builds := []*build{api, worker, broken}
+
+	report := newReport(builds)
+	report.finish(0, api, 0, nil)
+	report.finish(1, worker, 0, nil)
+	report.finish(2, broken, 0, errors.New("build failed"))
+
+	return builds, report
+}
+
+func TestWriteOutputs(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		format string
+		golden string
+	}{
+		{name: "dotenv", file: "outputs.env", golden: "outputs.dotenv.golden"},
+		{name: "json extension", file: "outputs.json", golden: "outputs.json.golden"},
+		{name: "json format", file: "outputs", format: OutputsFormatJSON, golden: "outputs.json.golden"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builds, report := testOutputBuilds()
+
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := writeOutputs(path, tt.format, builds, report); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkGolden(t, tt.golden, data)
+		})
+	}
+}
+
+func TestWriteOutputsSingleBuild(t *testing.T) {
+	builds, report := testOutputBuilds()
+
+	// the names of a single build have no prefix
+	path := filepath.Join(t.TempDir(), "outputs.env")
+	if err := writeOutputs(path, "", builds[1:2], &Report{Builds: report.Builds[1:2]}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "IMAGE_DIGEST=sha256:worker\nIMAGE_REF_WITH_DIGEST=registry.example.com/worker@sha256:worker\nIMAGE_TAGS=1.0\n"
+	if string(data) != want {
+		t.Errorf("outputs = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Plan output formats
+const (
+	PlanFormatText = "text"
+	PlanFormatJSON = "json"
+)
+
+// Plan is the list of steps that the plugin would run for the current settings.
+type Plan struct {
+	Builds []BuildPlan `json:"builds"`
+}
+
+// BuildPlan has the steps of a single build.
+type BuildPlan struct {
+	Name         string         `json:"name,omitempty"`
+	Target       string         `json:"push_target,omitempty"`
+	Destinations []string       `json:"destinations,omitempty"`
+	Platforms    []string       `json:"platforms,omitempty"`
+	Warm         []PlanStep     `json:"warm,omitempty"`
+	Steps        []PlanStep     `json:"steps"`
+	Manifests    []PlanManifest `json:"manifests,omitempty"`
+}
+
+// PlanStep is a single call to the kaniko executor or warmer.
+type PlanStep struct {
+	Platform     string   `json:"platform,omitempty"`
+	Stage        string   `json:"stage,omitempty"`
+	Destinations []string `json:"destinations,omitempty"`
+	Command      []string `json:"command"`
+	// scanner command run before pushing the image, if the scan gate is enabled
+	Scan []string `json:"scan,omitempty"`
+}
+
+// PlanManifest is a manifest list pushed after building every platform.
+type PlanManifest struct {
+	Target string      `json:"target"`
+	Tags   []string    `json:"tags,omitempty"`
+	Images []PlanImage `json:"images"`
+}
+
+// PlanImage is the image of a platform referenced by a manifest list.
+type PlanImage struct {
+	Image    string `json:"image"`
+	Platform string `json:"platform"`
+}
+
+// newPlan resolves the steps of every build.
+func newPlan(builds []*build) (*Plan, error) {
+	plan := &Plan{Builds: make([]BuildPlan, 0, len(builds))}
+
+	for _, b := range builds {
+		buildPlan, err := b.plan()
+		if err != nil {
+			if b.name != "" {
+				return nil, fmt.Errorf("build %s: %w", b.name, err)
+			}
+			return nil, err
+		}
+
+		plan.Builds = append(plan.Builds, *buildPlan)
+	}
+
+	return plan, nil
+}
+
+// plan returns the steps of the build, in the order they would run.
+func (b *build) plan() (*BuildPlan, error) {
+	buildPlan := &BuildPlan{
+		Name:      b.name,
+		Target:    b.target,
+		Platforms: b.settings.Main.Platforms,
+	}
+
+	platforms := b.settings.Main.Platforms
+	if len(platforms) == 0 {
+		platforms = []string{b.settings.CustomPlatform}
+	}
+
+	if len(b.settings.Main.Images) > 0 {
+		for _, platform := range platforms {
+			warmSettings := b.settings
+			warmSettings.CustomPlatform = platform
+
+			buildPlan.Warm = append(buildPlan.Warm, PlanStep{
+				Platform: platform,
+				Command:  secrets.MaskAll(commandWarmer(context.Background(), &warmSettings).Args),
+			})
+		}
+	}
+
+	builds := []*build{b}
+	if target := b.targetBuild(); target != nil {
+		builds = []*build{target, b}
+	}
+
+	for _, entry := range builds {
+		var lists []manifestList
+		if len(entry.settings.Main.Platforms) > 0 {
+			var err error
+			if lists, err = entry.manifestLists(); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, platform := range platforms {
+			settings, err := entry.platformSettings(platform)
+			if err != nil {
+				return nil, err
+			}
+
+			step := PlanStep{
+				Platform:     platform,
+				Stage:        settings.Target,
+				Destinations: platformDestinations(settings),
+			}
+
+			if entry.checkedBeforePush() {
+				tarball, _ := tarballPath(entry.name, settings)
+				settings = tarballSettings(settings, tarball)
+				if entry.scan != nil {
+					step.Scan = entry.scan.command(tarball)
+				}
+			}
+
+			step.Command = secrets.MaskAll(commandBuild(context.Background(), settings).Args)
+			buildPlan.Steps = append(buildPlan.Steps, step)
+		}
+
+		for _, list := range lists {
+			planManifest := PlanManifest{Target: list.Target, Tags: list.Tags}
+			for _, image := range list.Images {
+				planManifest.Images = append(planManifest.Images, PlanImage{
+					Image:    image.Image,
+					Platform: image.Platform.OS + "/" + image.Platform.Architecture,
+				})
+			}
+			buildPlan.Manifests = append(buildPlan.Manifests, planManifest)
+		}
+	}
+
+	// resolved after building the manifest lists, as they add the default tags
+	buildPlan.Destinations = b.destinations()
+
+	return buildPlan, nil
+}
+
+// write prints the plan in the given format.
+func (p *Plan) write(w io.Writer, format string) error {
+	if format == PlanFormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	}
+
+	for _, b := range p.Builds {
+		if b.Name != "" {
+			_, _ = fmt.Fprintf(w, "Build %s\n", b.Name)
+		} else {
+			_, _ = fmt.Fprintln(w, "Build")
+		}
+
+		if len(b.Platforms) > 0 {
+			_, _ = fmt.Fprintf(w, "  Platforms: %s\n", strings.Join(b.Platforms, ", "))
+		}
+
+		if b.Target != "" {
+			_, _ = fmt.Fprintf(w, "  Push target: %s\n", b.Target)
+		}
+
+		if len(b.Destinations) > 0 {
+			_, _ = fmt.Fprintf(w, "  Destinations: %s\n", strings.Join(b.Destinations, ", "))
+		}
+
+		for _, step := range b.Warm {
+			_, _ = fmt.Fprintf(w, "  Warm %s\n    + %s\n", platformName(step.Platform), strings.Join(step.Command, " "))
+		}
+
+		for _, step := range b.Steps {
+			stage := ""
+			if step.Stage != "" {
+				stage = " (stage " + step.Stage + ")"
+			}
+
+			_, _ = fmt.Fprintf(w, "  Build %s%s\n", platformName(step.Platform), stage)
+			if len(step.Destinations) > 0 {
+				_, _ = fmt.Fprintf(w, "    Destinations: %s\n", strings.Join(step.Destinations, ", "))
+			}
+			_, _ = fmt.Fprintf(w, "    + %s\n", strings.Join(step.Command, " "))
+			if len(step.Scan) > 0 {
+				_, _ = fmt.Fprintf(w, "    Scan\n    + %s\n", strings.Join(step.Scan, " "))
+			}
+		}
+
+		for _, list := range b.Manifests {
+			_, _ = fmt.Fprintf(w, "  Manifest %s\n", list.Target)
+			if len(list.Tags) > 0 {
+				_, _ = fmt.Fprintf(w, "    Tags: %s\n", strings.Join(list.Tags, ", "))
+			}
+			for _, image := range list.Images {
+				_, _ = fmt.Fprintf(w, "    %s: %s\n", image.Platform, image.Image)
+			}
+		}
+	}
+
+	return nil
+}
+
+func platformName(platform string) string {
+	if platform == "" {
+		return "default platform"
+	}
+
+	return platform
+}
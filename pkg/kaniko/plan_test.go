@@ -0,0 +1,104 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares the output with the golden file in testdata, or rewrites the file with
+// the -update flag.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, run go test -update to accept it:\n%s", path, got)
+	}
+}
+
+// testPlugin returns a validated plugin with a multi-platform build that pushes its test
+// stage, and a worker build, using the dockerfiles of testdata/plan.
+func testPlugin(t *testing.T) *pluginImpl {
+	t.Helper()
+
+	// the proxy variables of the environment would be added to the build args
+	for _, name := range proxyVars {
+		for _, key := range []string{name, strings.ToUpper(name)} {
+			t.Setenv(key, "")
+			_ = os.Unsetenv(key)
+		}
+	}
+
+	settings := Settings{
+		Context:          "testdata/plan",
+		Dockerfile:       "testdata/plan/Dockerfile",
+		Destinations:     []string{"registry.example.com/app:1.0"},
+		BuildArgs:        []string{"GITHUB_TOKEN=plan-s3cr3t-token", "VERSION=1.0"},
+		Cache:            true,
+		CacheRepo:        "registry.example.com/app/cache",
+		CompressionLevel: -1,
+		Target:           "test",
+	}
+	settings.Main.Platforms = []string{"linux/amd64", "linux/arm64"}
+	settings.Main.PushTarget = true
+	settings.Main.Builds = `[
+		{"name": "app"},
+		{"name": "worker", "dockerfile": "testdata/plan/Dockerfile.worker", "destination": ["registry.example.com/worker:1.0"], "platforms": [], "target": "", "push-target": false}
+	]`
+
+	p := &pluginImpl{settings: settings, pipeline: *testPipeline(), network: drone.Network{Context: context.Background()}}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestPlanWrite(t *testing.T) {
+	p := testPlugin(t)
+
+	plan, err := newPlan(p.builds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{PlanFormatText, PlanFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := plan.write(&buf, format); err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(buf.String(), "plan-s3cr3t-token") {
+				t.Errorf("secret build arg not masked:\n%s", buf.String())
+			}
+
+			checkGolden(t, "plan."+format+".golden", buf.Bytes())
+		})
+	}
+}
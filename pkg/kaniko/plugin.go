@@ -12,6 +12,8 @@ type pluginImpl struct {
 	settings Settings
 	pipeline drone.Pipeline
 	network  drone.Network
+	builds   []*build
+	metrics  *metrics
 }
 
 // New Plugin from the given Settings, Pipeline, and Network.
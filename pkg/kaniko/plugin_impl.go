@@ -5,18 +5,21 @@
 package kaniko
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/estesp/manifest-tool/v2/pkg/types"
-	"github.com/google/go-containerregistry/pkg/name"
-	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
-	"go.megpoid.dev/drone-kaniko/pkg/manifest"
+	"go.megpoid.dev/drone-kaniko/pkg/cache"
+	"go.megpoid.dev/drone-kaniko/pkg/policy"
+	"go.megpoid.dev/drone-kaniko/pkg/tracing"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -24,67 +27,71 @@ const (
 	kanikoWarmer   = "/kaniko/warmer"
 )
 
+// time given to kaniko to exit after a SIGTERM before it's killed
+const commandWaitDelay = 10 * time.Second
+
 // Settings for the Plugin.
 type Settings struct {
-	BuildArgs                    []string
-	Cache                        bool
-	CacheCopyLayers              bool
-	CacheDir                     string
-	CacheRepo                    string
-	CacheRunLayers               bool
-	CacheTTL                     time.Duration
-	Cleanup                      bool
-	CompressedCaching            bool
-	Compression                  string
-	CompressionLevel             int
-	Context                      string
-	ContextSubPath               string
-	CustomPlatform               string
-	Destinations                 []string
-	DigestFile                   string
-	Dockerfile                   string
-	Force                        bool
-	ForceBuildMetadata           bool
-	Git                          string
-	IgnorePath                   []string
-	IgnoreVarRun                 bool
-	ImageDownloadRetry           int
-	ImageFsExtractRetry          int
-	ImageNameTagWithDigestFile   string
-	ImageNameWithDigestFile      string
-	Insecure                     bool
-	InsecurePull                 bool
-	InsecureRegistries           []string
-	KanikoDir                    string
-	Labels                       []string
-	LogFormat                    string
-	LogTimestamp                 bool
-	NoPush                       bool
-	NoPushCache                  bool
-	OCILayoutPath                string
-	PushIgnoreImmutableTagErrors bool
-	PushRetry                    int
-	RegistryCertificates         []string
-	RegistryClientCerts          []string
-	RegistryMap                  []string
-	RegistryMirror               string
-	Reproducible                 bool
-	SingleSnapshot               bool
-	SkipDefaultRegistryFallback  bool
-	SkipPushPermissionCheck      bool
-	SkipTLSVerify                bool
-	SkipTLSVerifyPull            bool
-	SkipTLSVerifyRegistries      []string
-	SkipUnusedStages             bool
-	SnapshotMode                 string
-	TarPath                      string
-	Target                       string
-	UseNewRun                    bool
-	Verbosity                    string
-	Auth                         Auth
-	Main                         Main
-	Manifest                     Manifest
-	Extra                        Extra
+	BuildArgs                    []string      `yaml:"build-arg"`
+	Cache                        bool          `yaml:"cache"`
+	CacheCopyLayers              bool          `yaml:"cache-copy-layers"`
+	CacheDir                     string        `yaml:"cache-dir"`
+	CacheRepo                    string        `yaml:"cache-repo"`
+	CacheRunLayers               bool          `yaml:"cache-run-layers"`
+	CacheTTL                     time.Duration `yaml:"cache-ttl"`
+	Cleanup                      bool          `yaml:"cleanup"`
+	CompressedCaching            bool          `yaml:"compressed-caching"`
+	Compression                  string        `yaml:"compression"`
+	CompressionLevel             int           `yaml:"compression-level"`
+	Context                      string        `yaml:"context"`
+	ContextSubPath               string        `yaml:"context-sub-path"`
+	CustomPlatform               string        `yaml:"custom-platform"`
+	Destinations                 []string      `yaml:"destination"`
+	DigestFile                   string        `yaml:"digest-file"`
+	Dockerfile                   string        `yaml:"dockerfile"`
+	Force                        bool          `yaml:"force"`
+	ForceBuildMetadata           bool          `yaml:"force-build-metadata"`
+	Git                          string        `yaml:"git"`
+	IgnorePath                   []string      `yaml:"ignore-path"`
+	IgnoreVarRun                 bool          `yaml:"ignore-var-run"`
+	ImageDownloadRetry           int           `yaml:"image-download-retry"`
+	ImageFsExtractRetry          int           `yaml:"image-fs-extract-retry"`
+	ImageNameTagWithDigestFile   string        `yaml:"image-name-tag-with-digest-file"`
+	ImageNameWithDigestFile      string        `yaml:"image-name-with-digest-file"`
+	Insecure                     bool          `yaml:"insecure"`
+	InsecurePull                 bool          `yaml:"insecure-pull"`
+	InsecureRegistries           []string      `yaml:"insecure-registry"`
+	KanikoDir                    string        `yaml:"kaniko-dir"`
+	Labels                       []string      `yaml:"label"`
+	LogFormat                    string        `yaml:"log-format"`
+	LogTimestamp                 bool          `yaml:"log-timestamp"`
+	NoPush                       bool          `yaml:"no-push"`
+	NoPushCache                  bool          `yaml:"no-push-cache"`
+	OCILayoutPath                string        `yaml:"oci-layout-path"`
+	PushIgnoreImmutableTagErrors bool          `yaml:"push-ignore-immutable-tag-errors"`
+	PushRetry                    int           `yaml:"push-retry"`
+	RegistryCertificates         []string      `yaml:"registry-certificate"`
+	RegistryClientCerts          []string      `yaml:"registry-client-cert"`
+	RegistryMap                  []string      `yaml:"registry-map"`
+	RegistryMirror               string        `yaml:"registry-mirror"`
+	Reproducible                 bool          `yaml:"reproducible"`
+	SingleSnapshot               bool          `yaml:"single-snapshot"`
+	SkipDefaultRegistryFallback  bool          `yaml:"skip-default-registry-fallback"`
+	SkipPushPermissionCheck      bool          `yaml:"skip-push-permission-check"`
+	SkipTLSVerify                bool          `yaml:"skip-tls-verify"`
+	SkipTLSVerifyPull            bool          `yaml:"skip-tls-verify-pull"`
+	SkipTLSVerifyRegistries      []string      `yaml:"skip-tls-verify-registry"`
+	SkipUnusedStages             bool          `yaml:"skip-unused-stages"`
+	SnapshotMode                 string        `yaml:"snapshot-mode"`
+	TarPath                      string        `yaml:"tar-path"`
+	Target                       string        `yaml:"target"`
+	UseNewRun                    bool          `yaml:"use-new-run"`
+	Verbosity                    string        `yaml:"verbosity"`
+	Auth                         Auth          `yaml:"-"`
+	Main                         Main          `yaml:",inline"`
+	Manifest                     Manifest      `yaml:",inline"`
+	Extra                        Extra         `yaml:",inline"`
+	File                         *SettingsFile `yaml:"-"`
 }
 
 // Auth settings for the Plugin.
@@ -97,30 +104,75 @@ type Auth struct {
 
 // Main args for the Plugin.
 type Main struct {
-	BuildArgsFromEnv []string
-	Debug            bool
-	DryRun           bool
-	ForceCache       bool
-	Tags             []string
-	Platforms        []string
-	TagsAuto         bool
-	TagsSuffix       string
-	Images           []string
-	Repo             string
-	LabelSchema      []string
-	Mirror           string
-	PushTarget       bool
-	AutoLabel        bool
+	BuildArgsFromEnv        []string      `yaml:"args-from-env"`
+	ProxyCase               string        `yaml:"proxy-case"`
+	Debug                   bool          `yaml:"debug"`
+	Simulate                bool          `yaml:"simulate"`
+	ForceCache              bool          `yaml:"force-cache"`
+	Tags                    []string      `yaml:"tags"`
+	Platforms               []string      `yaml:"platforms"`
+	TagsAuto                bool          `yaml:"tags-auto"`
+	TagsSuffix              string        `yaml:"tags-suffix"`
+	Images                  []string      `yaml:"image"`
+	Repo                    string        `yaml:"repo"`
+	LabelSchema             []string      `yaml:"label-schema"`
+	Mirror                  string        `yaml:"mirror"`
+	PushTarget              bool          `yaml:"push-target"`
+	AutoLabel               bool          `yaml:"auto-label"`
+	WarmerPolicy            string        `yaml:"warmer-policy"`
+	WarmerConcurrency       int           `yaml:"warmer-concurrency"`
+	CacheGC                 string        `yaml:"cache-gc"`
+	CacheGCPolicy           string        `yaml:"cache-gc-policy"`
+	CacheMaxSize            string        `yaml:"cache-max-size"`
+	CacheRepoTemplate       string        `yaml:"cache-repo-template"`
+	CacheBranchRepoTemplate string        `yaml:"cache-branch-repo-template"`
+	CachePerBranch          bool          `yaml:"cache-per-branch"`
+	CacheSeedLimit          int           `yaml:"cache-seed-limit"`
+	CachePruneAge           time.Duration `yaml:"cache-prune-age"`
+	CachePruneKeep          int           `yaml:"cache-prune-keep"`
+	Builds                  string        `yaml:"-"`
+	BuildsConcurrency       int           `yaml:"builds-concurrency"`
+	ReportFile              string        `yaml:"report-file"`
+	CardPath                string        `yaml:"card-path"`
+	CardSchema              string        `yaml:"card-schema"`
+	OutputsFile             string        `yaml:"outputs-file"`
+	OutputsFormat           string        `yaml:"outputs-format"`
+	Scan                    bool          `yaml:"scan"`
+	Scanner                 string        `yaml:"scanner"`
+	ScanArgs                string        `yaml:"scan-args"`
+	ScanFormat              string        `yaml:"scan-format"`
+	ScanSeverity            string        `yaml:"scan-severity"`
+	ScanAllowlist           string        `yaml:"scan-allowlist"`
+	PolicyFile              string        `yaml:"policy-file"`
+	SizeDiff                bool          `yaml:"size-diff"`
+	SizeBaseline            string        `yaml:"size-baseline"`
+	SizeBudget              string        `yaml:"size-budget"`
+	SizeMaxGrowth           float64       `yaml:"size-max-growth"`
+	Mirrors                 string        `yaml:"mirrors"`
+	MetricsFile             string        `yaml:"metrics-file"`
+	MetricsPushgateway      string        `yaml:"metrics-pushgateway"`
+	MetricsJob              string        `yaml:"metrics-job"`
+	Plan                    bool          `yaml:"plan"`
+	PlanFormat              string        `yaml:"plan-format"`
+	MaskVars                []string      `yaml:"mask-vars"`
+	Secrets                 []string      `yaml:"secrets"`
+	Timeout                 time.Duration `yaml:"timeout"`
+	WarmTimeout             time.Duration `yaml:"warm-timeout"`
+	BuildTimeout            time.Duration `yaml:"build-timeout"`
+	ManifestTimeout         time.Duration `yaml:"manifest-timeout"`
+	RetryMaxAttempts        int           `yaml:"retry-max-attempts"`
+	RetryBackoff            time.Duration `yaml:"retry-backoff"`
+	RetryMaxBackoff         time.Duration `yaml:"retry-max-backoff"`
 }
 
 type Manifest struct {
-	IgnoreMissing bool
+	IgnoreMissing bool `yaml:"ignore-missing"`
 }
 
 // Extra args for the plugin
 type Extra struct {
-	Executor []string
-	Warmer   []string
+	Executor []string `yaml:"executor-extra-args"`
+	Warmer   []string `yaml:"warmer-extra-args"`
 }
 
 type Platform struct {
@@ -129,426 +181,439 @@ type Platform struct {
 	TarballPath  string
 }
 
-func (p *pluginImpl) Validate() error {
-	if err := enableCompatibilityMode(&p.settings, &p.pipeline); err != nil {
-		return err
-	}
+func (p *pluginImpl) Validate() (err error) {
+	_, span := tracing.Start(p.network.Context, "validate")
+	defer func() { span.End(err) }()
 
-	if !p.settings.NoPush && len(p.settings.Destinations) == 0 && p.settings.Main.Repo == "" {
-		return errors.New("must provide either no-push or at least one repo/destination")
-	}
+	var errs []error
 
-	for _, entry := range p.settings.RegistryCertificates {
-		parts := strings.Split(entry, "=")
-		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-			return fmt.Errorf("invalid registry-certificate: %s", entry)
-		}
+	if p.settings.File != nil && len(p.settings.File.Unknown) > 0 {
+		errs = append(errs, fmt.Errorf("unknown settings in %s: %s", p.settings.File.Path, strings.Join(p.settings.File.Unknown, ", ")))
 	}
 
-	for _, entry := range p.settings.RegistryClientCerts {
-		parts := strings.Split(entry, "=")
-		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-			return fmt.Errorf("invalid registry-client-cert: %s", entry)
-		}
+	switch p.settings.Main.WarmerPolicy {
+	case "", WarmerPolicyIgnore, WarmerPolicyWarn, WarmerPolicyFail:
+	default:
+		errs = append(errs, fmt.Errorf("invalid warmer-policy: %s", p.settings.Main.WarmerPolicy))
 	}
 
-	if len(p.settings.Main.Platforms) > 0 {
-		if p.settings.CustomPlatform != "" {
-			return fmt.Errorf("platforms is set, custom-platform will be ignored")
-		}
-		if p.settings.TarPath != "" {
-			return fmt.Errorf("platforms is set, tar-path will be ignored")
-		}
+	switch p.settings.Main.CacheGC {
+	case "", CacheGCPre, CacheGCPost:
+	default:
+		errs = append(errs, fmt.Errorf("invalid cache-gc: %s", p.settings.Main.CacheGC))
 	}
 
-	if err := generateAuthFile(&p.settings.Auth); err != nil {
-		return fmt.Errorf("failed to generate docker auth file: %w", err)
+	switch p.settings.Main.CacheGCPolicy {
+	case "", cache.PolicyLRU, cache.PolicyAge:
+	default:
+		errs = append(errs, fmt.Errorf("invalid cache-gc-policy: %s", p.settings.Main.CacheGCPolicy))
 	}
 
-	if p.settings.Main.AutoLabel {
-		generateLabelSchemas(&p.settings, &p.pipeline)
+	switch p.settings.Main.PlanFormat {
+	case "", PlanFormatText, PlanFormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("invalid plan-format: %s", p.settings.Main.PlanFormat))
 	}
-	// set defaults
-	addProxyBuildArgs(&p.settings)
-	addArgsFromEnv(&p.settings)
 
-	if p.settings.Cache {
-		p.settings.Main.Images = append(p.settings.Main.Images, p.settings.Destinations...)
+	switch p.settings.Main.OutputsFormat {
+	case "", OutputsFormatDotenv, OutputsFormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("invalid outputs-format: %s", p.settings.Main.OutputsFormat))
 	}
 
-	if p.settings.Context == "" {
-		wd, err := os.Getwd()
-		if err != nil {
-			p.settings.Context = "."
-		} else {
-			p.settings.Context = wd
-		}
+	if _, err := cache.ParseSize(p.settings.Main.CacheMaxSize); err != nil {
+		errs = append(errs, fmt.Errorf("invalid cache-max-size: %w", err))
 	}
 
-	return nil
-}
+	definitions, err := p.buildDefinitions()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
 
-func (p *pluginImpl) Execute() error {
-	var cmds []*exec.Cmd
-	cmds = append(cmds, commandKanikoVersion()) // kaniko version
+	// a single build with the plugin settings
+	if len(definitions) == 0 {
+		definitions = []buildDefinition{{}}
+	}
 
-	// no platforms, just build and push directly without a manifest
-	if len(p.settings.Main.Platforms) == 0 {
-		if len(p.settings.Main.Images) > 0 {
-			cmds = append(cmds, commandWarmer(&p.settings)) // kaniko warmer
+	for _, definition := range definitions {
+		b := &build{name: definition.Name, settings: p.settings.clone(), results: &buildResults{}}
+		if err := definition.apply(&b.settings); err != nil {
+			errs = append(errs, err)
+			continue
 		}
 
-		cmds = append(cmds, commandBuild(&p.settings)) // kaniko build/push
-		if err := runCmds(cmds); err != nil {
-			return err
+		if err := p.validateBuild(b); err != nil {
+			if b.name != "" {
+				err = fmt.Errorf("build %s: %w", b.name, err)
+			}
+			errs = append(errs, err)
+			continue
 		}
 
-		return nil
+		registerSecrets(&b.settings)
+		p.builds = append(p.builds, b)
 	}
 
-	if len(p.settings.Main.Images) > 0 {
-		for _, platform := range p.settings.Main.Platforms {
-			p.settings.CustomPlatform = platform
+	if err := checkBuildSecrets(p.builds); err != nil {
+		errs = append(errs, err)
+	}
 
-			// warmer is called once per platform
-			cmds = append(cmds, commandWarmer(&p.settings)) // kaniko warmer
-		}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
 	}
 
-	// list of repositories with their tags
-	repositories := make(map[string][]string)
+	if err := generateAuthFile(&p.settings.Auth); err != nil {
+		return fmt.Errorf("failed to generate docker auth file: %w", err)
+	}
 
-	for idx, destination := range p.settings.Destinations {
-		tag, err := name.NewTag(destination)
-		if err != nil {
-			return fmt.Errorf("invalid destination: %s", destination)
-		}
+	span.SetAttributes("builds", len(p.builds))
 
-		repoName := tag.Repository.Name()
-		if _, ok := repositories[repoName]; !ok {
-			repositories[repoName] = []string{tag.TagStr()}
-		} else {
-			repositories[repoName] = append(repositories[repoName], tag.TagStr())
-		}
+	return nil
+}
 
-		// update the destination list with default tags, if missing
-		p.settings.Destinations[idx] = tag.Name()
+// buildDefinitions returns the builds defined in the settings, or the settings file.
+func (p *pluginImpl) buildDefinitions() ([]buildDefinition, error) {
+	if p.settings.Main.Builds != "" {
+		return ParseBuilds(p.settings.Main.Builds)
 	}
 
-	for _, platform := range p.settings.Main.Platforms {
-		p.settings.CustomPlatform = platform
-
-		// kaniko is called once per platform
-		cmds = append(cmds, commandBuild(&p.settings)) // kaniko build
+	if p.settings.File != nil {
+		return p.settings.File.builds, nil
 	}
 
-	for _, cmd := range cmds {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		trace(cmd)
+	return nil, nil
+}
 
-		err := cmd.Run()
-		if err != nil {
-			var exErr *exec.ExitError
-			// ignore warmer errors since the first run there won't be a cache
-			if cmd.Args[0] == kanikoWarmer && errors.As(err, &exErr) {
-				continue
-			}
-			return err
-		}
-	}
+// validateBuild checks the settings of a build and fills the defaults. Every problem found is
+// returned, not only the first one.
+func (p *pluginImpl) validateBuild(b *build) error {
+	settings := &b.settings
 
-	cfg := manifest.Config{
-		Insecure: p.settings.Insecure,
+	if err := enableCompatibilityMode(settings, &p.pipeline); err != nil {
+		return err
 	}
 
-	for repoName, tags := range repositories {
-		var images []types.ManifestEntry
+	var errs []error
 
-		for _, platform := range p.settings.Main.Platforms {
-			OS, arch, found := strings.Cut(platform, "/")
-			if !found {
-				return fmt.Errorf("invalid platform: %s", platform)
-			}
+	if !settings.NoPush && len(settings.Destinations) == 0 && settings.Main.Repo == "" {
+		errs = append(errs, errors.New("must provide either no-push or at least one repo/destination"))
+	}
 
-			images = append(images, types.ManifestEntry{
-				Image: repoName + ":" + tags[0] + "-" + arch,
-				Platform: ocispec.Platform{
-					Architecture: arch,
-					OS:           OS,
-				},
-			})
+	errs = append(errs, validateSettings(settings)...)
+
+	if len(settings.Main.Platforms) > 0 {
+		if settings.CustomPlatform != "" {
+			errs = append(errs, errors.New("platforms and custom-platform cannot be used together"))
+		}
+		if settings.TarPath != "" {
+			errs = append(errs, errors.New("platforms and tar-path cannot be used together"))
 		}
+	}
 
-		target := repoName + ":" + tags[0]
+	if settings.Main.Scan {
+		gate, scanErrs := newScanGate(settings)
+		errs = append(errs, scanErrs...)
+		b.scan = gate
+	}
 
-		// push the manifest to the registry, per repository
-		manifestErr := manifest.Push(target, tags[1:], images, cfg)
-		if manifestErr != nil {
-			return fmt.Errorf("failed to push manifest: %w", manifestErr)
+	if settings.Main.PolicyFile != "" {
+		rules, err := policy.Load(settings.Main.PolicyFile)
+		if err != nil {
+			errs = append(errs, err)
 		}
+		b.policy = rules
 	}
 
-	return nil
-}
+	check, sizeErrs := newSizeCheck(settings)
+	errs = append(errs, sizeErrs...)
+	b.size = check
 
-func runCmds(cmds []*exec.Cmd) error {
-	for _, cmd := range cmds {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		trace(cmd)
+	if settings.Main.Mirrors != "" {
+		if settings.NoPush {
+			errs = append(errs, errors.New("mirrors cannot be used with no-push"))
+		}
 
-		err := cmd.Run()
+		mirrors, err := parseMirrors(settings.Main.Mirrors)
 		if err != nil {
-			var exerr *exec.ExitError
-			// ignore warmer errors since the first run there won't be a cache
-			if cmd.Args[0] == kanikoWarmer && errors.As(err, &exerr) {
-				continue
-			}
-			return err
+			errs = append(errs, err)
 		}
+		b.mirrors = mirrors
 	}
 
-	return nil
-}
-
-func commandKanikoVersion() *exec.Cmd {
-	return exec.Command(kanikoExecutor, "version")
-}
-
-func commandBuild(settings *Settings) *exec.Cmd {
-	var args []string
-	for _, entry := range settings.BuildArgs {
-		args = append(args, "--build-arg", entry)
+	// the target stage is built as its own image, the main image uses the final stage
+	if settings.Main.PushTarget {
+		if settings.Target == "" {
+			errs = append(errs, errors.New("push-target requires a target stage"))
+		}
+		b.target = settings.Target
+		settings.Target = ""
 	}
-	if settings.Cache {
-		args = append(args, "--cache")
-		if settings.CacheRepo != "" {
-			args = append(args, "--cache-repo", settings.CacheRepo)
+
+	for _, platform := range settings.Main.Platforms {
+		if _, _, found := strings.Cut(platform, "/"); !found {
+			errs = append(errs, fmt.Errorf("invalid platform: %s", platform))
 		}
 	}
-	if settings.CacheCopyLayers {
-		args = append(args, "--cache-copy-layers")
+
+	buildSecrets, secretErrs := parseBuildSecrets(settings)
+	errs = append(errs, secretErrs...)
+	b.secrets = buildSecrets
+
+	if settings.Main.AutoLabel {
+		generateLabelSchemas(settings, &p.pipeline)
 	}
-	if settings.CacheDir != "" {
-		args = append(args, "--cache-dir", settings.CacheDir)
+	// set defaults
+	errs = append(errs, resolveBuildArgs(settings)...)
+
+	seedRepo, err := resolveCacheRepo(settings, &p.pipeline)
+	if err != nil {
+		errs = append(errs, err)
 	}
-	if !settings.CacheRunLayers {
-		args = append(args, "--cache-run-layers=false")
+	b.cacheSeedRepo = seedRepo
+
+	if settings.Cache {
+		settings.Main.Images = uniqueStrings(append(settings.Main.Images, settings.Destinations...))
 	}
-	if settings.CacheTTL != 0 {
-		args = append(args, "--cache-ttl", settings.CacheTTL.String())
+
+	if settings.Context == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			settings.Context = "."
+		} else {
+			settings.Context = wd
+		}
 	}
-	if settings.Cleanup {
-		args = append(args, "--cleanup")
+
+	errs = append(errs, validateBuildContext(settings)...)
+
+	// the platform overrides are checked with the settings they produce
+	if len(errs) == 0 {
+		for _, platform := range settings.Main.Platforms {
+			if _, err := b.platformSettings(platform); err != nil {
+				errs = append(errs, err)
+			}
+		}
 	}
-	if !settings.CompressedCaching {
-		args = append(args, "--compressed-caching=false")
+
+	return errors.Join(errs...)
+}
+
+func (p *pluginImpl) Execute() (err error) {
+	if p.settings.Main.Plan {
+		return p.printPlan()
 	}
-	if settings.Compression != "" {
-		args = append(args, "--compression", settings.Compression)
+
+	if p.settings.Main.Simulate {
+		return p.dryRun()
 	}
-	if settings.CompressionLevel != -1 {
-		args = append(args, "--compression-level", strconv.FormatInt(int64(settings.CompressionLevel), 10))
+
+	ctx := p.network.Context
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	if settings.Context != "" {
-		args = append(args, "--context", settings.Context)
+
+	if p.settings.Main.CacheGC == CacheGCPre {
+		if err := p.collectCache(ctx); err != nil {
+			return err
+		}
 	}
-	if settings.ContextSubPath != "" {
-		args = append(args, "--context-sub-path", settings.ContextSubPath)
+
+	// the cache is collected after the builds even if they fail, so a broken build doesn't leave
+	// the cache over its limits
+	if p.settings.Main.CacheGC == CacheGCPost {
+		defer func() {
+			err = errors.Join(err, p.collectCache(context.WithoutCancel(ctx)))
+		}()
 	}
-	if settings.CustomPlatform != "" {
-		args = append(args, "--custom-platform", settings.CustomPlatform)
+
+	ctx, cancel := withPhaseTimeout(ctx, phaseTotal, p.settings.Main.Timeout)
+	defer cancel()
+
+	if err := runCmd(commandKanikoVersion(ctx), "phase", "version"); err != nil {
+		return phaseError(ctx, err)
 	}
-	for _, entry := range settings.Destinations {
-		if settings.CustomPlatform != "" && len(settings.Main.Platforms) > 0 {
-			_, arch, _ := strings.Cut(settings.CustomPlatform, "/")
-			entry = entry + "-" + arch
+
+	p.metrics = newMetrics(&p.settings, &p.pipeline)
+
+	// the cache directory is shared by all the builds, so it's warmed before building
+	tracker := newWarmTracker()
+	for _, b := range p.builds {
+		b.metrics = p.metrics
+		b.inspectImages = p.settings.Main.ReportFile != "" || p.settings.Main.OutputsFile != "" || p.cardEnabled() || len(b.mirrors) > 0
+
+		warmStart := time.Now()
+		warmCtx, cancelWarm := withPhaseTimeout(ctx, phaseWarm, b.settings.Main.WarmTimeout)
+		warmCtx, span := tracing.Start(warmCtx, phaseWarm, "build", b.name)
+		// the cause is read before the context is canceled, or every failure looks canceled
+		err := phaseError(warmCtx, b.warm(warmCtx, tracker))
+		span.End(err)
+		cancelWarm()
+
+		if err != nil {
+			return err
 		}
 
-		args = append(args, "--destination", entry)
-	}
-	if settings.DigestFile != "" {
-		args = append(args, "--digest-file", settings.DigestFile)
-	}
-	if settings.Dockerfile != "" {
-		args = append(args, "--dockerfile", settings.Dockerfile)
-	}
-	if settings.Force {
-		args = append(args, "--force")
-	}
-	if settings.ForceBuildMetadata {
-		args = append(args, "--force-build-metadata")
+		slog.Debug("Phase finished", "phase", phaseWarm, "build", b.name, "duration", time.Since(warmStart))
+		p.metrics.phaseDuration(phaseWarm, "", time.Since(warmStart))
 	}
-	if settings.Git != "" {
-		args = append(args, "--git", settings.Git)
-	}
-	for _, entry := range settings.IgnorePath {
-		args = append(args, "--ignore-path", entry)
-	}
-	if !settings.IgnoreVarRun {
-		args = append(args, "--ignore-var-run=false")
-	}
-	if settings.ImageDownloadRetry > 0 {
-		args = append(args, "--image-download-retry", strconv.FormatInt(int64(settings.ImageDownloadRetry), 10))
-	}
-	if settings.ImageFsExtractRetry > 0 {
-		args = append(args, "--image-fs-extract-retry", strconv.FormatInt(int64(settings.ImageFsExtractRetry), 10))
-	}
-	if settings.ImageNameTagWithDigestFile != "" {
-		args = append(args, "--image-name-tag-with-digest-file", settings.ImageNameTagWithDigestFile)
-	}
-	if settings.ImageNameWithDigestFile != "" {
-		args = append(args, "--image-name-with-digest-file", settings.ImageNameWithDigestFile)
-	}
-	if settings.Insecure {
-		args = append(args, "--insecure")
-	}
-	if settings.InsecurePull {
-		args = append(args, "--insecure-pull")
-	}
-	for _, entry := range settings.InsecureRegistries {
-		args = append(args, "--insecure-registry", entry)
-	}
-	if settings.KanikoDir != "" {
-		args = append(args, "--kaniko-dir", settings.KanikoDir)
-	}
-	for _, entry := range settings.Labels {
-		args = append(args, "--label", entry)
-	}
-	if settings.LogFormat != "" {
-		args = append(args, "--log-format", settings.LogFormat)
-	}
-	if settings.LogTimestamp {
-		args = append(args, "--log-timestamp")
-	}
-	if settings.NoPush {
-		args = append(args, "--no-push")
-	}
-	if settings.NoPushCache {
-		args = append(args, "--no-push-cache")
-	}
-	if settings.OCILayoutPath != "" {
-		args = append(args, "--oci-layout-path", settings.OCILayoutPath)
-	}
-	if settings.PushIgnoreImmutableTagErrors {
-		args = append(args, "--push-ignore-immutable-tag-errors")
-	}
-	if settings.PushRetry > 0 {
-		args = append(args, "--push-retry", strconv.FormatInt(int64(settings.PushRetry), 10))
-	}
-	for _, entry := range settings.RegistryCertificates {
-		args = append(args, "--registry-certificate", entry)
-	}
-	for _, entry := range settings.RegistryClientCerts {
-		args = append(args, "--registry-client-cert", entry)
-	}
-	for _, entry := range settings.RegistryMap {
-		args = append(args, "--registry-map", entry)
-	}
-	if settings.RegistryMirror != "" {
-		args = append(args, "--registry-mirror", settings.RegistryMirror)
-	}
-	if settings.Reproducible {
-		args = append(args, "--reproducible")
-	}
-	if settings.SingleSnapshot {
-		args = append(args, "--single-snapshot")
-	}
-	if settings.SkipDefaultRegistryFallback {
-		args = append(args, "--skip-default-registry-fallback")
-	}
-	if settings.SkipPushPermissionCheck {
-		args = append(args, "--skip-push-permission-check")
-	}
-	if settings.SkipTLSVerify {
-		args = append(args, "--skip-tls-verify")
-	}
-	if settings.SkipTLSVerifyPull {
-		args = append(args, "--skip-tls-verify-pull")
-	}
-	for _, entry := range settings.SkipTLSVerifyRegistries {
-		args = append(args, "--skip-tls-verify-registry", entry)
+
+	// a warm timeout can be tolerated by the warmer policy, but not an interruption
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
 	}
-	if settings.SkipUnusedStages {
-		args = append(args, "--skip-unused-stages")
+
+	cleanup, err := stageBuildSecrets(buildSecretsDir, p.builds)
+	if err != nil {
+		return err
 	}
-	if settings.SnapshotMode != "" {
-		args = append(args, "--snapshotMode", settings.SnapshotMode)
+	defer cleanup()
+
+	start := time.Now()
+	report := newReport(p.builds)
+
+	concurrency := max(p.settings.Main.BuildsConcurrency, 1)
+	if concurrency > 1 && len(p.builds) > 1 {
+		// kaniko unpacks the images into the root filesystem of the container
+		slog.Warn("Running builds concurrently, they share the root filesystem of the container", "concurrency", concurrency)
 	}
-	if settings.TarPath != "" {
-		args = append(args, "--tar-path", settings.TarPath)
+
+	group := new(errgroup.Group)
+	group.SetLimit(concurrency)
+
+	for idx, b := range p.builds {
+		group.Go(func() error {
+			buildStart := time.Now()
+			buildCtx, span := tracing.Start(ctx, "build "+b.name, "build", b.name, "destination", b.destinations())
+			err := b.run(buildCtx)
+			span.End(err)
+			report.finish(idx, b, time.Since(buildStart), err)
+			p.metrics.buildResult(b.name, time.Since(buildStart), err)
+			return nil
+		})
 	}
-	if settings.Target != "" {
-		args = append(args, "--target", settings.Target)
+
+	_ = group.Wait()
+
+	report.close(time.Since(start))
+	report.log()
+
+	p.metrics.phaseDuration(phaseTotal, "", time.Since(start))
+	if err := p.exportMetrics(context.WithoutCancel(ctx)); err != nil {
+		slog.Warn("Failed to export metrics", "error", err)
 	}
-	if settings.UseNewRun {
-		args = append(args, "--use-new-run")
+
+	if p.cardEnabled() {
+		_, span := tracing.Start(ctx, "card", "path", p.settings.Main.CardPath)
+		err := writeCard(p.settings.Main.CardPath, p.settings.Main.CardSchema, report)
+		span.End(err)
+
+		if err != nil {
+			slog.Warn("Failed to write card", "error", err)
+		}
 	}
-	if settings.Verbosity != "" {
-		args = append(args, "--verbosity", settings.Verbosity)
+
+	if p.settings.Main.ReportFile != "" {
+		_, span := tracing.Start(ctx, "report", "path", p.settings.Main.ReportFile)
+		err := report.write(p.settings.Main.ReportFile)
+		span.End(err)
+
+		if err != nil {
+			return err
+		}
 	}
-	if len(settings.Extra.Executor) > 0 {
-		args = append(args, settings.Extra.Executor...)
+
+	if p.settings.Main.OutputsFile != "" {
+		_, span := tracing.Start(ctx, "outputs", "path", p.settings.Main.OutputsFile)
+		err := writeOutputs(p.settings.Main.OutputsFile, p.settings.Main.OutputsFormat, p.builds, report)
+		span.End(err)
+
+		if err != nil {
+			return err
+		}
 	}
-	return exec.Command(kanikoExecutor, args...)
+
+	return report.Err()
 }
 
-func commandWarmer(settings *Settings) *exec.Cmd {
-	var args []string
-	if settings.CacheDir != "" {
-		args = append(args, "--cache-dir", settings.CacheDir)
-	}
-	if settings.CacheTTL != 0 {
-		args = append(args, "--cache-ttl", settings.CacheTTL.String())
-	}
-	if settings.CustomPlatform != "" {
-		args = append(args, "--customPlatform", settings.CustomPlatform)
-	}
-	if settings.Dockerfile != "" {
-		args = append(args, "--dockerfile", settings.Dockerfile)
-	}
-	if settings.Main.ForceCache {
-		args = append(args, "--force")
-	}
-	for _, entry := range settings.Main.Images {
-		args = append(args, "--image", entry)
-	}
-	if settings.InsecurePull {
-		args = append(args, "--insecure-pull")
-	}
-	for _, entry := range settings.InsecureRegistries {
-		args = append(args, "--insecure-registry", entry)
-	}
-	if settings.LogFormat != "" {
-		args = append(args, "--log-format", settings.LogFormat)
-	}
-	if settings.LogTimestamp {
-		args = append(args, "--log-timestamp")
-	}
-	for _, entry := range settings.RegistryCertificates {
-		args = append(args, "--registry-certificate", entry)
-	}
-	for _, entry := range settings.RegistryClientCerts {
-		args = append(args, "--registry-client-cert", entry)
-	}
-	for _, entry := range settings.RegistryMap {
-		args = append(args, "--registry-map", entry)
-	}
-	if settings.RegistryMirror != "" {
-		args = append(args, "--registry-mirror", settings.RegistryMirror)
-	}
-	if settings.SkipTLSVerifyPull {
-		args = append(args, "--skip-tls-verify-pull")
-	}
-	for _, entry := range settings.SkipTLSVerifyRegistries {
-		args = append(args, "--skip-tls-verify-registry", entry)
+// cardEnabled checks if the card has to be written. It's opt-in with card-path, since reading the
+// digests and sizes for the card costs a request to the registry per image.
+func (p *pluginImpl) cardEnabled() bool {
+	return p.settings.Main.CardPath != "" && p.settings.Main.CardSchema != ""
+}
+
+// exportMetrics exports the metrics of the run, if enabled.
+func (p *pluginImpl) exportMetrics(ctx context.Context) error {
+	if p.metrics == nil {
+		return nil
 	}
-	if settings.Verbosity != "" {
-		args = append(args, "--verbosity", settings.Verbosity)
+
+	ctx, span := tracing.Start(ctx, "metrics export")
+	err := p.metrics.export(ctx, &p.settings, p.network.Client)
+	span.End(err)
+
+	return err
+}
+
+// collectCache runs the garbage collection of the cache directory.
+func (p *pluginImpl) collectCache(ctx context.Context) error {
+	_, span := tracing.Start(ctx, "cache gc", "policy", p.settings.Main.CacheGCPolicy)
+	err := CollectCache(&p.settings, false)
+	span.End(err)
+
+	return err
+}
+
+// newCommand creates a command that receives a SIGTERM when the context is done, and is killed
+// if it doesn't exit in time.
+func newCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
 	}
-	if len(settings.Extra.Warmer) > 0 {
-		args = append(args, settings.Extra.Warmer...)
+	cmd.WaitDelay = commandWaitDelay
+
+	return cmd
+}
+
+// runCmd runs the command with its output masked, and logged with the given attributes. If the
+// command fails the last lines of the output are kept in the error.
+func runCmd(cmd *exec.Cmd, attrs ...any) error {
+	return runCmdWithOutput(cmd, nil, attrs...)
+}
+
+// runCmdWithOutput runs the command like runCmd, passing every line of output to onLine.
+func runCmdWithOutput(cmd *exec.Cmd, onLine func(line string), attrs ...any) error {
+	stdout, stderr, flush := newOutputWriters(attrs...)
+	defer flush()
+
+	tail := &outputTail{}
+	output := newLineWriter(func(line string) {
+		tail.add(line)
+		if onLine != nil {
+			onLine(line)
+		}
+	})
+
+	cmd.Stdout = io.MultiWriter(stdout, output)
+	cmd.Stderr = io.MultiWriter(stderr, output)
+	trace(cmd, attrs...)
+
+	if err := cmd.Run(); err != nil {
+		output.Flush()
+		return &commandError{err: err, output: tail.lines}
 	}
-	return exec.Command(kanikoWarmer, args...)
+
+	return nil
+}
+
+func commandKanikoVersion(ctx context.Context) *exec.Cmd {
+	return newCommand(ctx, kanikoExecutor, "version")
+}
+
+func commandBuild(ctx context.Context, settings *Settings) *exec.Cmd {
+	return newCommand(ctx, kanikoExecutor, buildArgs(executorFlags, settings, settings.Extra.Executor)...)
+}
+
+func commandWarmer(ctx context.Context, settings *Settings) *exec.Cmd {
+	return newCommand(ctx, kanikoWarmer, buildArgs(warmerFlags, settings, settings.Extra.Warmer)...)
 }
@@ -0,0 +1,64 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.megpoid.dev/drone-kaniko/pkg/tracing"
+)
+
+// checkPolicy checks the built image against the policy of the build. Every violation is logged
+// and listed in the error.
+func (b *build) checkPolicy(ctx context.Context, settings *Settings, tarball string, destinations []string) (err error) {
+	ctx, span := tracing.Start(ctx, phasePolicy, "platform", settings.CustomPlatform)
+	defer func() { span.End(err) }()
+
+	start := time.Now()
+
+	img, source, err := builtImage(ctx, settings, tarball, destinations)
+	if err != nil {
+		return fmt.Errorf("failed to load the image for the policy check: %w", err)
+	}
+
+	if img == nil {
+		slog.Warn("Skipping policy check, the image isn't pushed or saved", "platform", settings.CustomPlatform)
+		return nil
+	}
+
+	violations, err := b.policy.Evaluate(img, b.baseImages())
+	if err != nil {
+		return fmt.Errorf("failed to check the image policy: %w", err)
+	}
+
+	for _, violation := range violations {
+		slog.Warn("Policy violation", "rule", violation.Rule, "message", violation.Message, "platform", settings.CustomPlatform)
+	}
+
+	slog.Info("Phase finished",
+		"phase", phasePolicy,
+		"platform", settings.CustomPlatform,
+		"source", source,
+		"violations", len(violations),
+		"duration", time.Since(start),
+	)
+
+	span.SetAttributes("source", source, "violations", len(violations))
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	listed := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		listed = append(listed, violation.String())
+	}
+
+	return fmt.Errorf("image has %d policy violations: %s", len(violations), strings.Join(listed, "; "))
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+)
+
+// craneOptions returns the options to reach the registry of the reference like kaniko does: with
+// plain HTTP for the insecure registries, without verifying the certificate of the registries
+// with skip-tls-verify, and trusting the registry certificates. The pull flags are used for the
+// images kaniko only pulls, like the base images, and the push ones for the destinations, the
+// cache repository and the mirrors.
+func craneOptions(settings *Settings, ref string, pull bool) []crane.Option {
+	registry := registryOf(ref)
+
+	insecure, skipVerify := settings.Insecure, settings.SkipTLSVerify
+	if pull {
+		insecure, skipVerify = settings.InsecurePull, settings.SkipTLSVerifyPull
+	}
+
+	insecure = insecure || slices.Contains(settings.InsecureRegistries, registry)
+	skipVerify = skipVerify || slices.Contains(settings.SkipTLSVerifyRegistries, registry)
+
+	var opts []crane.Option
+	if insecure {
+		opts = append(opts, crane.WithInsecure())
+	}
+
+	return append(opts, crane.WithTransport(registryTransport(settings, registry, skipVerify)))
+}
+
+// registryOf returns the registry of an image reference or repository, or an empty string if
+// it cannot be parsed.
+func registryOf(ref string) string {
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Context().RegistryStr()
+}
+
+// registryTransport returns the transport for the registry, with the certificates of the
+// registry-certificate and registry-client-cert entries of the registry. Certificates that
+// cannot be loaded are skipped, so the registry fails with a certificate error like in kaniko.
+func registryTransport(settings *Settings, registry string, skipVerify bool) http.RoundTripper {
+	transport := remote.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: skipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if certs := registryValues(settings.RegistryCertificates, registry); len(certs) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		for _, cert := range certs {
+			data, err := os.ReadFile(cert)
+			if err != nil {
+				slog.Warn("Cannot read registry certificate", "registry", registry, "path", cert, "error", err)
+				continue
+			}
+
+			if !pool.AppendCertsFromPEM(data) {
+				slog.Warn("Invalid registry certificate", "registry", registry, "path", cert)
+			}
+		}
+
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	// client certificates have the format 'my.registry.url=/path/to/cert.crt,/path/to/key.key'
+	for _, entry := range registryValues(settings.RegistryClientCerts, registry) {
+		certFile, keyFile, _ := strings.Cut(entry, ",")
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			slog.Warn("Cannot load registry client certificate", "registry", registry, "path", certFile, "error", err)
+			continue
+		}
+
+		transport.TLSClientConfig.Certificates = append(transport.TLSClientConfig.Certificates, cert)
+	}
+
+	return transport
+}
+
+// registryValues returns the values of the 'registry=value' entries of the registry.
+func registryValues(entries []string, registry string) []string {
+	var values []string
+
+	for _, entry := range entries {
+		key, value, found := strings.Cut(entry, "=")
+		if found && key == registry && value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
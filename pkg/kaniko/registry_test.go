@@ -0,0 +1,219 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+)
+
+func newTestRegistry() http.Handler {
+	return registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+}
+
+func TestCraneOptionsTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(newTestRegistry())
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	repo := host + "/app"
+
+	cert := filepath.Join(t.TempDir(), "registry.crt")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(cert, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		update  func(s *Settings)
+		pull    bool
+		wantErr bool
+	}{
+		{name: "untrusted", update: func(s *Settings) {}, wantErr: true},
+		{name: "registry certificate", update: func(s *Settings) { s.RegistryCertificates = []string{host + "=" + cert} }},
+		{name: "certificate of another registry", update: func(s *Settings) { s.RegistryCertificates = []string{"registry.example.com=" + cert} }, wantErr: true},
+		{name: "skip tls verify", update: func(s *Settings) { s.SkipTLSVerify = true }},
+		{name: "skip tls verify on pull", update: func(s *Settings) { s.SkipTLSVerify = true }, pull: true, wantErr: true},
+		{name: "skip tls verify pull", update: func(s *Settings) { s.SkipTLSVerifyPull = true }, pull: true},
+		{name: "skip tls verify registry", update: func(s *Settings) { s.SkipTLSVerifyRegistries = []string{host} }, pull: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := &Settings{}
+			tt.update(settings)
+
+			_, err := crane.ListTags(repo, craneOptions(settings, repo, tt.pull)...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistryValues(t *testing.T) {
+	entries := []string{"registry.example.com=/certs/a.crt", "other.example.com=/certs/b.crt", "registry.example.com=/certs/c.crt", "invalid"}
+
+	got := registryValues(entries, "registry.example.com")
+	if len(got) != 2 || got[0] != "/certs/a.crt" || got[1] != "/certs/c.crt" {
+		t.Errorf("registryValues() = %v", got)
+	}
+
+	if got := registryOf("registry.example.com:5000/team/app:1.0"); got != "registry.example.com:5000" {
+		t.Errorf("registryOf() = %s", got)
+	}
+	if got := registryOf("alpine"); got != name.DefaultRegistry {
+		t.Errorf("registryOf() = %s, want %s", got, name.DefaultRegistry)
+	}
+}
+
+// flakyRegistry fails the first push of the manifests of some tags, or every push of the
+// immutable ones. The failure uses a server error not retried by the registry client itself.
+type flakyRegistry struct {
+	handler   http.Handler
+	mu        sync.Mutex
+	unstable  map[string]bool
+	immutable map[string]bool
+}
+
+func (r *flakyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPut && strings.Contains(req.URL.Path, "/manifests/") {
+		tag := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+
+		r.mu.Lock()
+		unstable := r.unstable[tag]
+		delete(r.unstable, tag)
+		r.mu.Unlock()
+
+		switch {
+		case r.immutable[tag]:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"errors":[{"code":"TAG_INVALID","message":"The image tag '`+tag+`' already exists and cannot be overwritten because the repository is immutable."}]}`)
+			return
+		case unstable:
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		}
+	}
+
+	r.handler.ServeHTTP(w, req)
+}
+
+func TestPushTarball(t *testing.T) {
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		update    func(s *Settings)
+		unstable  []string
+		immutable []string
+		wantErr   bool
+		wantTags  []string
+	}{
+		{
+			name:     "pushed",
+			update:   func(s *Settings) {},
+			wantTags: []string{"1.0", "latest"},
+		},
+		{
+			name:     "push retry",
+			update:   func(s *Settings) { s.PushRetry = 1 },
+			unstable: []string{"latest"},
+			wantTags: []string{"1.0", "latest"},
+		},
+		{
+			name:     "no retry",
+			update:   func(s *Settings) {},
+			unstable: []string{"latest"},
+			wantErr:  true,
+		},
+		{
+			name:      "immutable tag",
+			update:    func(s *Settings) {},
+			immutable: []string{"1.0"},
+			wantErr:   true,
+		},
+		{
+			name:      "ignored immutable tag",
+			update:    func(s *Settings) { s.PushIgnoreImmutableTagErrors = true },
+			immutable: []string{"1.0"},
+			wantTags:  []string{"latest"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &flakyRegistry{handler: newTestRegistry(), unstable: map[string]bool{}, immutable: map[string]bool{}}
+			for _, tag := range tt.unstable {
+				handler.unstable[tag] = true
+			}
+			for _, tag := range tt.immutable {
+				handler.immutable[tag] = true
+			}
+
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			repo := strings.TrimPrefix(server.URL, "http://") + "/app"
+
+			refs := map[name.Reference]v1.Image{}
+			for _, tag := range []string{"1.0", "latest"} {
+				ref, err := name.NewTag(repo + ":" + tag)
+				if err != nil {
+					t.Fatal(err)
+				}
+				refs[ref] = img
+			}
+
+			path := filepath.Join(t.TempDir(), "image.tar")
+			if err := tarball.MultiRefWriteToFile(path, refs); err != nil {
+				t.Fatal(err)
+			}
+
+			b := &build{}
+			b.settings.Destinations = []string{repo + ":1.0", repo + ":latest"}
+			tt.update(&b.settings)
+
+			err := b.pushTarball(context.Background(), &b.settings, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			tags, err := crane.ListTags(repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(tags, ",") != strings.Join(tt.wantTags, ",") {
+				t.Errorf("tags = %v, want %v", tags, tt.wantTags)
+			}
+		})
+	}
+}
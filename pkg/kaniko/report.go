@@ -0,0 +1,194 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+)
+
+// Build status in the report
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Report is the combined result of every build of the step.
+type Report struct {
+	Status   string        `json:"status"`
+	Duration string        `json:"duration"`
+	Builds   []BuildReport `json:"builds"`
+}
+
+// BuildReport is the result of a single build.
+type BuildReport struct {
+	Name         string           `json:"name,omitempty"`
+	Status       string           `json:"status"`
+	Error        string           `json:"error,omitempty"`
+	Duration     string           `json:"duration"`
+	Destinations []string         `json:"destinations,omitempty"`
+	Platforms    []string         `json:"platforms,omitempty"`
+	BaseImages   []string         `json:"base_images,omitempty"`
+	Images       []ImageResult    `json:"images,omitempty"`
+	Manifests    []ManifestResult `json:"manifests,omitempty"`
+	Mirrors      []MirrorResult   `json:"mirrors,omitempty"`
+
+	err error
+}
+
+// ImageResult is an image built for a platform, or the target stage, and pushed.
+type ImageResult struct {
+	Platform     string   `json:"platform,omitempty"`
+	Target       string   `json:"target,omitempty"`
+	Destinations []string `json:"destinations,omitempty"`
+	Digest       string   `json:"digest,omitempty"`
+	Size         int64    `json:"size,omitempty"`
+	// number of vulnerabilities per severity, if the image was scanned
+	Vulnerabilities map[string]int `json:"vulnerabilities,omitempty"`
+	// size change since the previous release, if compared
+	SizeDiff *SizeDiff `json:"size_diff,omitempty"`
+}
+
+// SizeDiff is the size change of an image since its previous release.
+type SizeDiff struct {
+	Baseline     string `json:"baseline"`
+	BaselineSize int64  `json:"baseline_size"`
+	Size         int64  `json:"size"`
+	Delta        int64  `json:"delta"`
+	// growth in percent over the baseline
+	Growth        float64     `json:"growth"`
+	AddedLayers   []LayerDiff `json:"added_layers,omitempty"`
+	RemovedLayers []LayerDiff `json:"removed_layers,omitempty"`
+}
+
+// LayerDiff is a layer added or removed since the previous release.
+type LayerDiff struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+func newSizeDiff(baseline string, diff *crane.ImageDiff) *SizeDiff {
+	layerDiffs := func(layers []crane.LayerInfo) []LayerDiff {
+		var diffs []LayerDiff
+		for _, layer := range layers {
+			diffs = append(diffs, LayerDiff{Digest: layer.Digest, Size: layer.Size, CreatedBy: layer.CreatedBy})
+		}
+		return diffs
+	}
+
+	return &SizeDiff{
+		Baseline:      baseline,
+		BaselineSize:  diff.BaseSize,
+		Size:          diff.Size,
+		Delta:         diff.Delta(),
+		Growth:        diff.Growth(),
+		AddedLayers:   layerDiffs(diff.Added),
+		RemovedLayers: layerDiffs(diff.Removed),
+	}
+}
+
+// ManifestResult is a manifest list pushed after building every platform.
+type ManifestResult struct {
+	Destination string   `json:"destination"`
+	Tags        []string `json:"tags,omitempty"`
+	Digest      string   `json:"digest,omitempty"`
+}
+
+func newReport(builds []*build) *Report {
+	report := &Report{Builds: make([]BuildReport, len(builds))}
+	for idx, b := range builds {
+		report.Builds[idx] = BuildReport{
+			Name:         b.name,
+			Destinations: b.destinations(),
+			Platforms:    b.settings.Main.Platforms,
+			BaseImages:   b.baseImages(),
+		}
+	}
+
+	return report
+}
+
+// finish records the outcome of the build at the given index.
+func (r *Report) finish(idx int, b *build, duration time.Duration, err error) {
+	entry := &r.Builds[idx]
+	entry.Duration = duration.Round(time.Millisecond).String()
+	entry.Status = StatusSuccess
+	entry.Images = b.results.Images
+	entry.Manifests = b.results.Manifests
+	entry.Mirrors = b.results.Mirrors
+	entry.err = err
+
+	if err != nil {
+		entry.Status = StatusFailure
+		entry.Error = secrets.Mask(err.Error())
+	}
+}
+
+// close sets the global status and duration of the report.
+func (r *Report) close(duration time.Duration) {
+	r.Duration = duration.Round(time.Millisecond).String()
+	r.Status = StatusSuccess
+
+	for _, entry := range r.Builds {
+		if entry.Status != StatusSuccess {
+			r.Status = StatusFailure
+		}
+	}
+}
+
+// Err returns the errors of the failed builds.
+func (r *Report) Err() error {
+	var errs []error
+	for _, entry := range r.Builds {
+		if entry.err == nil {
+			continue
+		}
+
+		if entry.Name != "" {
+			errs = append(errs, fmt.Errorf("build %s: %w", entry.Name, entry.err))
+		} else {
+			errs = append(errs, entry.err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Report) log() {
+	for _, entry := range r.Builds {
+		slog.Info("Build finished",
+			"name", entry.Name,
+			"status", entry.Status,
+			"duration", entry.Duration,
+			"destinations", entry.Destinations,
+			"platforms", entry.Platforms,
+		)
+	}
+
+	slog.Info("Build summary", "status", r.Status, "duration", r.Duration, "builds", len(r.Builds))
+}
+
+// write saves the report as JSON in the given path.
+func (r *Report) write(path string) error {
+	data, err := json.MarshalIndent(secrets.MaskValue(r), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	slog.Info("Build report saved", "path", path)
+
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportWriteMasked(t *testing.T) {
+	// the JSON encoder escapes the quote and <, > and &, so the text of the report
+	// wouldn't contain the secret as is
+	secret := `report<&>"s3cr3t`
+	secrets.Add(secret)
+
+	report := testReport()
+	report.Builds[1].Error = "login failed with " + secret
+
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "report.json")
+	if err := report.write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	cardPath := filepath.Join(dir, "card.json")
+	if err := writeCard(cardPath, "https://example.com/card.json", report); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{path, cardPath} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded any
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		text, _ := json.Marshal(decoded)
+		if !strings.Contains(string(data), "login failed with ********") || strings.Contains(string(text), "s3cr3t") {
+			t.Errorf("%s not masked: %s", filepath.Base(file), data)
+		}
+	}
+
+	// the report is still used after writing it
+	if report.Builds[1].Error != "login failed with "+secret {
+		t.Errorf("report modified: %s", report.Builds[1].Error)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"go.megpoid.dev/drone-kaniko/pkg/manifest"
+)
+
+// commandOutputLines is the number of output lines kept to find the error of a command.
+const commandOutputLines = 50
+
+// errors from the registry or the network that are worth retrying
+var retryableRegexp = regexp.MustCompile(`(?i)status code (429|5\d\d)|too ?many ?requests|internal server error|bad gateway|service unavailable|gateway timeout|connection reset|connection refused|i/o timeout|tls handshake timeout|unexpected EOF|temporary failure in name resolution`)
+
+// kaniko prints the error that stopped it before exiting, e.g. "error pushing image: ..."
+var kanikoErrorRegexp = regexp.MustCompile(`^error (building|pushing) image: `)
+
+// kanikoRunFailure is part of the error of a failed RUN instruction, whose output may look like
+// a registry error but isn't solved by retrying.
+const kanikoRunFailure = "failed to execute command"
+
+// retryPolicy retries an operation that failed with a retryable error, waiting between attempts
+// with an exponential backoff.
+type retryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	metrics     *metrics
+}
+
+func newRetryPolicy(settings *Settings, m *metrics) retryPolicy {
+	return retryPolicy{
+		MaxAttempts: max(settings.Main.RetryMaxAttempts, 1),
+		Backoff:     settings.Main.RetryBackoff,
+		MaxBackoff:  settings.Main.RetryMaxBackoff,
+		metrics:     m,
+	}
+}
+
+// do runs the operation until it succeeds, fails with an error that can't be retried, the
+// attempts run out or the context is done.
+func (r retryPolicy) do(ctx context.Context, operation string, fn func() error) error {
+	backoff := r.Backoff
+
+	for attempt := 1; ; attempt++ {
+		if r.MaxAttempts > 1 {
+			slog.Info("Running attempt", "operation", operation, "attempt", attempt, "max_attempts", r.MaxAttempts)
+		}
+
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= r.MaxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+
+		slog.Warn("Attempt failed, retrying", "operation", operation, "attempt", attempt, "backoff", backoff, "error", err)
+		r.metrics.retry(operation)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// isRetryable checks if the error is caused by the network, a rate limit or a server error of
+// the registry. A phase that timed out isn't retried, as the timeout covers every attempt of the
+// phase and a slow build or push would only time out again.
+func isRetryable(err error) bool {
+	if errors.Is(err, manifest.ErrPushAbandoned) {
+		return false
+	}
+
+	// the context errors are also network errors, so they are checked first
+	var timeoutErr *phaseTimeoutError
+	if errors.As(err, &timeoutErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.StatusCode == http.StatusTooManyRequests || terr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	text := err.Error()
+
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		text += "\n" + cmdErr.kanikoError()
+	}
+
+	return retryableRegexp.MatchString(text)
+}
+
+// commandError is the error of a failed command, with the last lines of its output.
+type commandError struct {
+	err    error
+	output []string
+}
+
+func (e *commandError) Error() string {
+	return e.err.Error()
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
+// kanikoError returns the error line printed by kaniko when it fails pulling, building or
+// pushing. The rest of the output, like the one of the RUN instructions, is ignored, as is the
+// error of a failed RUN instruction.
+func (e *commandError) kanikoError() string {
+	for i := len(e.output) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(e.output[i])
+		if !kanikoErrorRegexp.MatchString(line) {
+			continue
+		}
+
+		if strings.Contains(line, kanikoRunFailure) {
+			return ""
+		}
+
+		return line
+	}
+
+	return ""
+}
+
+// outputTail keeps the last lines written by a command.
+type outputTail struct {
+	lines []string
+}
+
+func (t *outputTail) add(line string) {
+	if len(t.lines) == commandOutputLines {
+		t.lines = t.lines[1:]
+	}
+	t.lines = append(t.lines, line)
+}
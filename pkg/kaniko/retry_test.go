@@ -0,0 +1,138 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"go.megpoid.dev/drone-kaniko/pkg/manifest"
+)
+
+func TestIsRetryable(t *testing.T) {
+	exitErr := &exec.ExitError{}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "push rate limited",
+			err: &commandError{err: exitErr, output: []string{
+				"INFO[0012] Pushing image to registry.example.com/app:1.0",
+				"error pushing image: failed to push to destination registry.example.com/app:1.0: PUT https://registry.example.com/v2/app/manifests/1.0: TOOMANYREQUESTS: rate limit exceeded",
+			}},
+			want: true,
+		},
+		{
+			name: "pull server error",
+			err: &commandError{err: exitErr, output: []string{
+				"error building image: unable to complete operation after 0 attempts, last error: GET https://registry.example.com/v2/alpine/manifests/3.20: unexpected status code 503 Service Unavailable",
+			}},
+			want: true,
+		},
+		{
+			name: "push unauthorized",
+			err: &commandError{err: exitErr, output: []string{
+				"error pushing image: failed to push to destination registry.example.com/app:1.0: UNAUTHORIZED: authentication required",
+			}},
+		},
+		{
+			name: "failed run instruction",
+			err: &commandError{err: exitErr, output: []string{
+				"INFO[0005] RUN curl -f http://localhost:8080/health",
+				"curl: (7) Failed to connect to localhost port 8080: Connection refused",
+				"error building image: error building stage: failed to execute command: waiting for process to exit: exit status 7",
+			}},
+		},
+		{
+			name: "run output without kaniko error",
+			err: &commandError{err: exitErr, output: []string{
+				"npm ERR! request to https://registry.npmjs.org/left-pad failed, reason: status code 503",
+			}},
+		},
+		{
+			name: "registry error",
+			err:  fmt.Errorf("failed to push image: %w", &transport.Error{StatusCode: http.StatusBadGateway}),
+			want: true,
+		},
+		{
+			name: "registry client error",
+			err:  &transport.Error{StatusCode: http.StatusNotFound},
+		},
+		{
+			name: "error chain",
+			err:  errors.New("failed to push manifest: connection reset by peer"),
+			want: true,
+		},
+		{
+			name: "phase timeout",
+			err:  fmt.Errorf("%w: %w", &phaseTimeoutError{phase: phaseBuild}, context.Canceled),
+		},
+		{
+			name: "deadline exceeded",
+			err:  fmt.Errorf("failed to push: %w", context.DeadlineExceeded),
+		},
+		{
+			name: "canceled",
+			err:  fmt.Errorf("%w: %w", context.Canceled, exitErr),
+		},
+		{
+			name: "abandoned manifest push",
+			err:  fmt.Errorf("%w: %w", &phaseTimeoutError{phase: phaseManifest}, fmt.Errorf("%w: %w", manifest.ErrPushAbandoned, context.Canceled)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	retryable := errors.New("unexpected status code 502 Bad Gateway")
+	permanent := errors.New("unexpected status code 401 Unauthorized")
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "success", errs: []error{nil}, wantAttempts: 1},
+		{name: "retried", errs: []error{retryable, retryable, nil}, wantAttempts: 3},
+		{name: "permanent", errs: []error{permanent}, wantAttempts: 1, wantErr: permanent},
+		{name: "attempts exhausted", errs: []error{retryable, retryable, retryable}, wantAttempts: 3, wantErr: retryable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := retryPolicy{MaxAttempts: 3}
+
+			attempts := 0
+			err := policy.do(context.Background(), "push", func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
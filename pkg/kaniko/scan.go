@@ -0,0 +1,252 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+	"go.megpoid.dev/drone-kaniko/pkg/scan"
+	"go.megpoid.dev/drone-kaniko/pkg/tracing"
+)
+
+// defaultKanikoDir is where kaniko keeps its files, ignored in the snapshots of the image.
+const defaultKanikoDir = "/kaniko"
+
+// maximum number of blocking findings listed in the error
+const maxListedFindings = 10
+
+var tarballNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// scanGate checks the image with a scanner before pushing it. The image is built as a tarball
+// without pushing, scanned, and pushed only if no finding blocks it.
+type scanGate struct {
+	scanner scan.Scanner
+	policy  scan.Policy
+}
+
+// newScanGate creates the gate of the build with the command scanner of the settings.
+func newScanGate(settings *Settings) (*scanGate, []error) {
+	var errs []error
+
+	if err := validateChoice("scan-severity", settings.Main.ScanSeverity, scan.SeverityNames); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateChoice("scan-format", settings.Main.ScanFormat, scan.FormatNames); err != nil {
+		errs = append(errs, err)
+	}
+
+	args := strings.Fields(settings.Main.ScanArgs)
+	if len(args) == 0 {
+		args = scan.DefaultArgs(settings.Main.Scanner)
+	}
+
+	if len(args) == 0 {
+		errs = append(errs, fmt.Errorf("scan-args is required for the scanner %s", settings.Main.Scanner))
+	} else if !strings.Contains(strings.Join(args, " "), scan.PlaceholderImage) {
+		errs = append(errs, fmt.Errorf("scan-args must contain the %s placeholder", scan.PlaceholderImage))
+	}
+
+	allowlist, err := scan.LoadAllowlist(settings.Main.ScanAllowlist)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	format := settings.Main.ScanFormat
+	if format == "" {
+		format = scan.FormatSARIF
+	}
+
+	gate := &scanGate{
+		scanner: &scan.CommandScanner{
+			Command: settings.Main.Scanner,
+			Args:    args,
+			Format:  format,
+			Stderr:  os.Stderr,
+			NewCommand: func(ctx context.Context, name string, args ...string) *exec.Cmd {
+				cmd := newCommand(ctx, name, args...)
+				trace(cmd, "phase", phaseScan)
+				return cmd
+			},
+		},
+		policy: scan.Policy{
+			Threshold: scan.ParseSeverity(settings.Main.ScanSeverity),
+			Allowlist: allowlist,
+		},
+	}
+
+	return gate, nil
+}
+
+// checkedBeforePush tells if the image must pass a check before it's pushed. The image is then
+// built as a tarball without pushing, checked, and pushed only if every check passes.
+func (b *build) checkedBeforePush() bool {
+	return b.scan != nil || b.policy != nil || b.size.enforced()
+}
+
+// tarballPath returns where the image of the platform is saved before checking it. The path set
+// by the user is used if any, otherwise the file is saved in the kaniko directory as it's the
+// only place, besides the ignored paths, that survives the build.
+func tarballPath(name string, settings *Settings) (path string, temporary bool) {
+	if settings.TarPath != "" {
+		return settings.TarPath, false
+	}
+
+	dir := settings.KanikoDir
+	if dir == "" {
+		dir = defaultKanikoDir
+	}
+
+	var parts []string
+	for _, part := range []string{name, settings.Target, settings.CustomPlatform} {
+		if part = strings.Trim(tarballNameRegexp.ReplaceAllString(part, "-"), "-"); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	file := strings.Join(parts, "-")
+	if file == "" {
+		file = "image"
+	}
+
+	return filepath.Join(dir, "check", file+".tar"), true
+}
+
+// tarballSettings returns the settings of the build that saves the image as a tarball instead of
+// pushing it.
+func tarballSettings(settings *Settings, tarball string) *Settings {
+	gated := settings.clone()
+	gated.NoPush = true
+	gated.TarPath = tarball
+
+	return &gated
+}
+
+// command returns the scanner command for the tarball, or nil if it isn't a command scanner.
+func (g *scanGate) command(tarball string) []string {
+	scanner, ok := g.scanner.(*scan.CommandScanner)
+	if !ok {
+		return nil
+	}
+
+	command := []string{scanner.Command}
+	for _, arg := range scanner.Args {
+		command = append(command, strings.ReplaceAll(arg, scan.PlaceholderImage, tarball))
+	}
+
+	return command
+}
+
+// scanCommand returns the scanner binary of the build, if it uses a command scanner.
+func (b *build) scanCommand() string {
+	if b.scan == nil {
+		return ""
+	}
+
+	if command := b.scan.command(""); len(command) > 0 {
+		return command[0]
+	}
+
+	return ""
+}
+
+// check scans the tarball and applies the policy to the findings.
+func (g *scanGate) check(ctx context.Context, platform, tarball string) (result *scan.Result, err error) {
+	ctx, span := tracing.Start(ctx, phaseScan, "platform", platform)
+	defer func() { span.End(err) }()
+
+	start := time.Now()
+
+	findings, err := g.scanner.Scan(ctx, tarball)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan image: %w", err)
+	}
+
+	result = g.policy.Check(findings, time.Now())
+
+	for _, finding := range result.Allowed {
+		slog.Info("Vulnerability allowed", "id", finding.ID, "severity", finding.Severity.String(), "platform", platform)
+	}
+
+	slog.Info("Phase finished",
+		"phase", phaseScan,
+		"platform", platform,
+		"findings", result.Counts(),
+		"blocking", len(result.Blocking),
+		"allowed", len(result.Allowed),
+		"duration", time.Since(start),
+	)
+
+	span.SetAttributes("findings", len(result.Findings), "blocking", len(result.Blocking), "allowed", len(result.Allowed))
+
+	if len(result.Blocking) == 0 {
+		return result, nil
+	}
+
+	listed := make([]string, 0, maxListedFindings)
+	for _, finding := range result.Blocking[:min(len(result.Blocking), maxListedFindings)] {
+		listed = append(listed, finding.String())
+	}
+	if len(result.Blocking) > maxListedFindings {
+		listed = append(listed, fmt.Sprintf("and %d more", len(result.Blocking)-maxListedFindings))
+	}
+
+	return result, fmt.Errorf("image has %d vulnerabilities at or above %s severity: %s",
+		len(result.Blocking), g.policy.Threshold, strings.Join(listed, ", "))
+}
+
+// pushTarball pushes the checked image to its destinations, with the registry settings of the
+// first one. Like kaniko, it's retried push-retry times and skips the immutable tags if
+// push-ignore-immutable-tag-errors is set.
+func (b *build) pushTarball(ctx context.Context, settings *Settings, tarball string) error {
+	phase := phasePush
+	if settings.CustomPlatform != "" {
+		phase += " " + settings.CustomPlatform
+	}
+
+	var opts []crane.Option
+	if len(settings.Destinations) > 0 {
+		opts = craneOptions(settings, settings.Destinations[0], false)
+	}
+
+	opts = append(opts, crane.WithContext(ctx))
+	if settings.PushIgnoreImmutableTagErrors {
+		opts = append(opts, crane.WithIgnoreImmutableTags())
+	}
+
+	retry := newRetryPolicy(&b.settings, b.metrics)
+	retry.MaxAttempts = max(retry.MaxAttempts, settings.PushRetry+1)
+
+	err := retry.do(ctx, phase, func() error {
+		_, err := crane.Push(tarball, opts...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push image: %w", err)
+	}
+
+	return nil
+}
+
+// removeTarball deletes the temporary tarball of a checked image.
+func removeTarball(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Failed to remove image tarball", "path", path, "error", err)
+	}
+}
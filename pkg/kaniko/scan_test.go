@@ -0,0 +1,123 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.megpoid.dev/drone-kaniko/pkg/scan"
+)
+
+// TestScannerProcess is the fake scanner run by TestScanGate. It copies the report in
+// SCAN_REPORT to the file given with --file, like grype.
+func TestScannerProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_SCANNER_PROCESS") != "1" {
+		return
+	}
+
+	data, err := os.ReadFile(os.Getenv("SCAN_REPORT"))
+	if err != nil {
+		os.Exit(2)
+	}
+
+	for idx, arg := range os.Args {
+		if arg == "--file" && idx+1 < len(os.Args) {
+			if err := os.WriteFile(os.Args[idx+1], data, 0o600); err != nil {
+				os.Exit(2)
+			}
+		}
+	}
+
+	os.Exit(0)
+}
+
+func TestScanGate(t *testing.T) {
+	report, err := filepath.Abs("../scan/testdata/grype.sarif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		threshold    scan.Severity
+		allowlist    scan.Allowlist
+		wantBlocking []string
+		wantAllowed  int
+	}{
+		{
+			name:         "blocking",
+			threshold:    scan.SeverityHigh,
+			wantBlocking: []string{"CVE-2023-5363", "CVE-2023-5363", "CVE-2024-24790"},
+		},
+		{
+			name:         "allowlist without package suffix",
+			threshold:    scan.SeverityHigh,
+			allowlist:    scan.Allowlist{"CVE-2023-5363": time.Time{}},
+			wantBlocking: []string{"CVE-2024-24790"},
+			wantAllowed:  2,
+		},
+		{
+			name:         "expired allowlist entry",
+			threshold:    scan.SeverityCritical,
+			allowlist:    scan.Allowlist{"CVE-2024-24790": time.Now().AddDate(0, 0, -2)},
+			wantBlocking: []string{"CVE-2024-24790"},
+		},
+		{
+			name:      "allowed",
+			threshold: scan.SeverityHigh,
+			allowlist: scan.Allowlist{
+				"CVE-2023-5363":  time.Time{},
+				"CVE-2024-24790": time.Now().AddDate(0, 1, 0),
+			},
+			wantAllowed: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := &scanGate{
+				scanner: &scan.CommandScanner{
+					Command: "grype",
+					Args:    scan.DefaultArgs("grype"),
+					Format:  scan.FormatSARIF,
+					NewCommand: func(ctx context.Context, name string, args ...string) *exec.Cmd {
+						cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=TestScannerProcess", "--"}, args...)...)
+						cmd.Env = append(os.Environ(), "GO_WANT_SCANNER_PROCESS=1", "SCAN_REPORT="+report)
+						return cmd
+					},
+				},
+				policy: scan.Policy{Threshold: tt.threshold, Allowlist: tt.allowlist},
+			}
+
+			result, err := gate.check(context.Background(), "linux/amd64", filepath.Join(t.TempDir(), "image.tar"))
+			if result == nil {
+				t.Fatalf("scan failed: %v", err)
+			}
+
+			var blocking []string
+			for _, finding := range result.Blocking {
+				blocking = append(blocking, finding.ID)
+			}
+
+			if strings.Join(blocking, ",") != strings.Join(tt.wantBlocking, ",") {
+				t.Errorf("blocking = %q, want %q", blocking, tt.wantBlocking)
+			}
+
+			if len(result.Allowed) != tt.wantAllowed {
+				t.Errorf("allowed %d findings, want %d", len(result.Allowed), tt.wantAllowed)
+			}
+
+			if (err != nil) != (len(tt.wantBlocking) > 0) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
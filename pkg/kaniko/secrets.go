@@ -0,0 +1,44 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"go.megpoid.dev/drone-kaniko/pkg/mask"
+)
+
+// secrets masks the secret values in the traced commands, logs, reports and kaniko output.
+var secrets = mask.New()
+
+// Masker returns the masker with the secrets found by the plugin, so the application logs can
+// be masked too.
+func Masker() *mask.Masker {
+	return secrets
+}
+
+// registerSecrets collects the values of the environment variables and build args whose name
+// matches the default secret patterns or the ones given in mask-vars.
+func registerSecrets(settings *Settings) {
+	patterns := slices.Concat(mask.DefaultPatterns, settings.Main.MaskVars)
+
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		if mask.IsSecretName(key, patterns) {
+			secrets.Add(value)
+		}
+	}
+
+	for _, entry := range settings.BuildArgs {
+		key, value, found := strings.Cut(entry, "=")
+		if found && mask.IsSecretName(key, patterns) {
+			secrets.Add(value)
+		}
+	}
+
+	secrets.Add(settings.Auth.Password)
+}
@@ -0,0 +1,290 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultSettingsFiles are looked up in the working directory if no settings file is given.
+var defaultSettingsFiles = []string{".kaniko.yaml", ".kaniko.yml", ".kaniko.json"}
+
+// sections of the settings file that don't map to a setting
+const (
+	settingsKeyPlatforms = "platform-overrides"
+	settingsKeyTargets   = "targets"
+	settingsKeyBuilds    = "builds"
+)
+
+// SettingsFile is a YAML (or JSON) file that uses the same keys as the plugin flags. Besides the
+// settings it can define overrides per platform and named build targets, e.g.
+//
+//	dockerfile: Dockerfile
+//	platforms: [linux/amd64, linux/arm64]
+//	platform-overrides:
+//	  linux/arm64:
+//	    build-arg: [GOARCH=arm64]
+//	targets:
+//	  worker:
+//	    target: worker
+//	    repo: registry.example.com/app-worker
+//	builds:
+//	  - name: api
+//	    target: api
+//	    repo: registry.example.com/api
+//	  - name: migrations
+//	    dockerfile: Dockerfile.migrations
+//	    repo: registry.example.com/migrations
+type SettingsFile struct {
+	Path      string
+	values    []*yaml.Node
+	platforms map[string][]*yaml.Node
+	targets   map[string][]*yaml.Node
+	builds    []buildDefinition
+	// Unknown has the keys that don't match any setting.
+	Unknown []string
+}
+
+// LoadSettingsFile reads the settings file and applies it to the settings. Values set from
+// flags or environment variables take precedence over the target values, which take precedence
+// over the top level values of the file. If path is empty, the default settings files are used
+// if present.
+func LoadSettingsFile(settings *Settings, path, target string, isSet func(name string) bool) error {
+	if path == "" {
+		for _, entry := range defaultSettingsFiles {
+			if _, err := os.Stat(entry); err == nil {
+				path = entry
+				break
+			}
+		}
+
+		if path == "" {
+			if target != "" {
+				return fmt.Errorf("settings target %s requires a settings file", target)
+			}
+			return nil
+		}
+	}
+
+	file, err := parseSettingsFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := file.values
+	if target != "" {
+		targetValues, ok := file.targets[target]
+		if !ok {
+			return fmt.Errorf("settings target %s not found in %s", target, path)
+		}
+		values = slices.Concat(values, targetValues)
+	}
+
+	for i := 0; i < len(values); i += 2 {
+		if isSet != nil && isSet(values[i].Value) {
+			continue
+		}
+
+		if err := decodeSetting(settings, values[i], values[i+1]); err != nil {
+			return fmt.Errorf("invalid setting %s in %s: %w", values[i].Value, path, err)
+		}
+	}
+
+	slog.Info("Loaded settings file", "path", path, "target", target)
+	settings.File = file
+
+	return nil
+}
+
+func parseSettingsFile(path string) (*SettingsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("settings file %s not found", path)
+		}
+		return nil, fmt.Errorf("failed to read settings file: %w", err)
+	}
+
+	file := &SettingsFile{
+		Path:      path,
+		platforms: make(map[string][]*yaml.Node),
+		targets:   make(map[string][]*yaml.Node),
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse settings file %s: %w", path, err)
+	}
+
+	// empty file
+	if len(doc.Content) == 0 {
+		return file, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("settings file %s must be a mapping", path)
+	}
+
+	known := knownSettings()
+
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		switch key.Value {
+		case settingsKeyBuilds:
+			file.builds, err = parseBuildDefinitions(value, known, &file.Unknown)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in %s: %w", key.Value, path, err)
+			}
+		case settingsKeyPlatforms, settingsKeyTargets:
+			sections, err := parseSections(key.Value, value, known, &file.Unknown)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in %s: %w", key.Value, path, err)
+			}
+			if key.Value == settingsKeyPlatforms {
+				file.platforms = sections
+			} else {
+				file.targets = sections
+			}
+		default:
+			if !known[key.Value] {
+				file.Unknown = append(file.Unknown, key.Value)
+				continue
+			}
+			file.values = append(file.values, key, value)
+		}
+	}
+
+	return file, nil
+}
+
+// parseSections reads a mapping of names to settings, like the platform overrides or targets.
+func parseSections(prefix string, node *yaml.Node, known map[string]bool, unknown *[]string) (map[string][]*yaml.Node, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.New("must be a mapping")
+	}
+
+	sections := make(map[string][]*yaml.Node)
+
+	for i := 0; i < len(node.Content); i += 2 {
+		sectionName, section := node.Content[i].Value, node.Content[i+1]
+		if section.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s must be a mapping", sectionName)
+		}
+
+		var values []*yaml.Node
+		for j := 0; j < len(section.Content); j += 2 {
+			key := section.Content[j]
+			if !known[key.Value] {
+				*unknown = append(*unknown, prefix+"."+sectionName+"."+key.Value)
+				continue
+			}
+			values = append(values, key, section.Content[j+1])
+		}
+
+		sections[sectionName] = values
+	}
+
+	return sections, nil
+}
+
+// hasPlatform checks if the settings file has overrides for the platform.
+func (f *SettingsFile) hasPlatform(platform string) bool {
+	return platform != "" && len(f.platforms[platform]) > 0
+}
+
+// ApplyPlatform overrides the settings with the values defined for the platform, if any. Lists
+// are added to the ones already set instead of replacing them, and a build arg replaces the one
+// with the same key.
+func (f *SettingsFile) ApplyPlatform(settings *Settings, platform string) error {
+	values := f.platforms[platform]
+	for i := 0; i < len(values); i += 2 {
+		field := settingField(reflect.ValueOf(settings).Elem(), values[i].Value)
+
+		var previous reflect.Value
+		if field.IsValid() && field.Kind() == reflect.Slice {
+			previous = reflect.AppendSlice(reflect.MakeSlice(field.Type(), 0, field.Len()), field)
+		}
+
+		if err := decodeSetting(settings, values[i], values[i+1]); err != nil {
+			return fmt.Errorf("invalid setting %s for platform %s in %s: %w", values[i].Value, platform, f.Path, err)
+		}
+
+		if previous.IsValid() {
+			field.Set(reflect.AppendSlice(previous, field))
+		}
+	}
+
+	settings.BuildArgs = parseBuildArgList(settings.BuildArgs).list()
+
+	return nil
+}
+
+// settingField returns the field of the settings with the key, or an invalid value if none.
+func settingField(v reflect.Value, key string) reflect.Value {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name, opts, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+
+		switch {
+		case opts == "inline":
+			if field := settingField(v.Field(i), key); field.IsValid() {
+				return field
+			}
+		case name == key:
+			return v.Field(i)
+		}
+	}
+
+	return reflect.Value{}
+}
+
+// decodeSetting decodes a single key of the settings file into the settings.
+func decodeSetting(settings *Settings, key, value *yaml.Node) error {
+	node := &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Tag:     "!!map",
+		Content: []*yaml.Node{key, value},
+	}
+
+	return node.Decode(settings)
+}
+
+// knownSettings returns the keys that can be used in the settings file.
+func knownSettings() map[string]bool {
+	known := make(map[string]bool)
+	collectSettingKeys(reflect.TypeOf(Settings{}), known)
+
+	return known
+}
+
+func collectSettingKeys(t reflect.Type, known map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("yaml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if opts == "inline" {
+			collectSettingKeys(field.Type, known)
+			continue
+		}
+
+		known[name] = true
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeSettingsFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), ".kaniko.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestPlatformOverrideBuildArgs(t *testing.T) {
+	for _, name := range proxyVars {
+		t.Setenv(name, "")
+		t.Setenv(strings.ToUpper(name), "")
+	}
+	t.Setenv("http_proxy", "http://proxy:3128")
+	t.Setenv("GIT_TOKEN", "token")
+	t.Setenv("ARM_FLAGS", "-march=armv8-a")
+
+	path := writeSettingsFile(t, `
+build-arg: [VERSION=1.0, GOARCH=amd64]
+args-from-env: [GIT_TOKEN]
+proxy-case: lower
+platforms: [linux/amd64, linux/arm64]
+platform-overrides:
+  linux/arm64:
+    build-arg: [GOARCH=arm64, GOARM=7]
+    args-from-env: [ARM_FLAGS]
+`)
+
+	settings := Settings{}
+	if err := LoadSettingsFile(&settings, path, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := resolveBuildArgs(&settings); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	b := &build{settings: settings}
+
+	tests := []struct {
+		platform string
+		want     []string
+	}{
+		{
+			platform: "linux/amd64",
+			want:     []string{"VERSION=1.0", "GOARCH=amd64", "http_proxy=http://proxy:3128", "GIT_TOKEN=token"},
+		},
+		{
+			platform: "linux/arm64",
+			want:     []string{"VERSION=1.0", "GOARCH=arm64", "http_proxy=http://proxy:3128", "GIT_TOKEN=token", "GOARM=7", "ARM_FLAGS=-march=armv8-a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			got, err := b.platformSettings(tt.platform)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got.BuildArgs, tt.want) {
+				t.Errorf("build args = %q, want %q", got.BuildArgs, tt.want)
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(b.settings.BuildArgs, tests[0].want) {
+		t.Errorf("overrides changed the build settings: %q", b.settings.BuildArgs)
+	}
+}
+
+func TestPlatformOverrideValidation(t *testing.T) {
+	path := writeSettingsFile(t, `
+compression: gzip
+platform-overrides:
+  linux/arm64:
+    compression: zip
+`)
+
+	settings := Settings{}
+	if err := LoadSettingsFile(&settings, path, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &build{settings: settings}
+
+	if _, err := b.platformSettings("linux/amd64"); err != nil {
+		t.Errorf("unexpected error for platform without overrides: %v", err)
+	}
+
+	_, err := b.platformSettings("linux/arm64")
+	if err == nil || !strings.Contains(err.Error(), "invalid compression") {
+		t.Errorf("expected invalid compression error, got %v", err)
+	}
+}
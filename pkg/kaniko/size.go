@@ -0,0 +1,200 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.megpoid.dev/drone-kaniko/pkg/cache"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+	"go.megpoid.dev/drone-kaniko/pkg/tracing"
+)
+
+// sizeCheck compares the built images with their previous release and enforces the size budget.
+type sizeCheck struct {
+	// compare the image with the baseline
+	diff bool
+	// reference of the previous image, the latest tag of the first destination if empty
+	baseline string
+	// maximum compressed size of the image, zero disables it
+	budget int64
+	// maximum growth in percent over the baseline, zero disables it
+	maxGrowth float64
+}
+
+// newSizeCheck creates the size check of the build, nil if it isn't enabled.
+func newSizeCheck(settings *Settings) (*sizeCheck, []error) {
+	var errs []error
+
+	budget, err := cache.ParseSize(settings.Main.SizeBudget)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid size-budget: %w", err))
+	}
+
+	if settings.Main.SizeMaxGrowth < 0 {
+		errs = append(errs, fmt.Errorf("invalid size-max-growth: %g", settings.Main.SizeMaxGrowth))
+	}
+
+	if settings.Main.SizeBaseline != "" {
+		if _, err := name.ParseReference(settings.Main.SizeBaseline); err != nil {
+			errs = append(errs, fmt.Errorf("invalid size-baseline: %w", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	check := &sizeCheck{
+		diff:      settings.Main.SizeDiff || settings.Main.SizeBaseline != "" || settings.Main.SizeMaxGrowth > 0,
+		baseline:  settings.Main.SizeBaseline,
+		budget:    budget,
+		maxGrowth: settings.Main.SizeMaxGrowth,
+	}
+
+	if !check.diff && check.budget == 0 {
+		return nil, nil
+	}
+
+	return check, nil
+}
+
+// enforced tells if the check can fail the build, so the image must be checked before the push.
+func (c *sizeCheck) enforced() bool {
+	return c != nil && (c.budget > 0 || c.maxGrowth > 0)
+}
+
+// baselineRef returns the reference of the previous image.
+func (c *sizeCheck) baselineRef(destinations []string) string {
+	if c.baseline != "" || len(destinations) == 0 {
+		return c.baseline
+	}
+
+	ref, err := name.ParseReference(destinations[0])
+	if err != nil {
+		return ""
+	}
+
+	return ref.Context().Tag("latest").String()
+}
+
+// baselineImage fetches the previous image for the platform. It must be called before the build
+// since the build may overwrite the baseline tag. Returns nil if there is no previous image.
+func (c *sizeCheck) baselineImage(ctx context.Context, settings *Settings, destinations []string) (v1.Image, string) {
+	if !c.diff {
+		return nil, ""
+	}
+
+	ref := c.baselineRef(destinations)
+	if ref == "" {
+		slog.Warn("Skipping size comparison, size-baseline is required without destinations", "platform", settings.CustomPlatform)
+		return nil, ""
+	}
+
+	opts := append(craneOptions(settings, ref, false), crane.WithContext(ctx), crane.WithPlatform(currentPlatform(settings.CustomPlatform)))
+
+	img, err := crane.Image(ref, opts...)
+	if err != nil {
+		if crane.IsNotFound(err) {
+			slog.Info("Skipping size comparison, the baseline image doesn't exist", "baseline", ref, "platform", settings.CustomPlatform)
+		} else {
+			slog.Warn("Skipping size comparison, failed to fetch the baseline image", "baseline", ref, "platform", settings.CustomPlatform, "error", err)
+		}
+		return nil, ""
+	}
+
+	// read the manifest now, before the tag can point to the new image
+	if _, err := img.Manifest(); err != nil {
+		slog.Warn("Skipping size comparison, failed to fetch the baseline image", "baseline", ref, "platform", settings.CustomPlatform, "error", err)
+		return nil, ""
+	}
+
+	return img, ref
+}
+
+// formatDelta returns the size change with its sign.
+func formatDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + cache.FormatSize(-delta)
+	}
+
+	return "+" + cache.FormatSize(delta)
+}
+
+// checkSize compares the built image with the baseline, if any, and checks the size budget and
+// growth limit.
+func (b *build) checkSize(ctx context.Context, settings *Settings, tarball string, destinations []string, baseline v1.Image, baselineRef string) (diff *SizeDiff, err error) {
+	ctx, span := tracing.Start(ctx, phaseSize, "platform", settings.CustomPlatform, "baseline", baselineRef)
+	defer func() { span.End(err) }()
+
+	img, _, err := builtImage(ctx, settings, tarball, destinations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the image for the size check: %w", err)
+	}
+
+	if img == nil {
+		slog.Warn("Skipping size check, the image isn't pushed or saved", "platform", settings.CustomPlatform)
+		return nil, nil
+	}
+
+	_, size, err := crane.Layers(img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the image size: %w", err)
+	}
+
+	span.SetAttributes("size", size)
+
+	if baseline != nil {
+		start := time.Now()
+
+		imageDiff, err := crane.DiffImages(baseline, img)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare the image with %s: %w", baselineRef, err)
+		}
+
+		diff = newSizeDiff(baselineRef, imageDiff)
+
+		for _, layer := range imageDiff.Added {
+			slog.Info("Layer added", "digest", layer.Digest, "size", cache.FormatSize(layer.Size), "created_by", layer.CreatedBy, "platform", settings.CustomPlatform)
+		}
+		for _, layer := range imageDiff.Removed {
+			slog.Info("Layer removed", "digest", layer.Digest, "size", cache.FormatSize(layer.Size), "created_by", layer.CreatedBy, "platform", settings.CustomPlatform)
+		}
+
+		slog.Info("Phase finished",
+			"phase", phaseSize,
+			"platform", settings.CustomPlatform,
+			"baseline", baselineRef,
+			"baseline_size", cache.FormatSize(imageDiff.BaseSize),
+			"size", cache.FormatSize(imageDiff.Size),
+			"delta", formatDelta(imageDiff.Delta()),
+			"growth", fmt.Sprintf("%.1f%%", imageDiff.Growth()),
+			"added_layers", len(imageDiff.Added),
+			"removed_layers", len(imageDiff.Removed),
+			"duration", time.Since(start),
+		)
+
+		span.SetAttributes("delta", imageDiff.Delta(), "added_layers", len(imageDiff.Added), "removed_layers", len(imageDiff.Removed))
+	}
+
+	var errs []error
+
+	if b.size.budget > 0 && size > b.size.budget {
+		errs = append(errs, fmt.Errorf("image size %s exceeds the budget of %s", cache.FormatSize(size), cache.FormatSize(b.size.budget)))
+	}
+
+	if diff != nil && b.size.maxGrowth > 0 && diff.Growth > b.size.maxGrowth {
+		errs = append(errs, fmt.Errorf("image grew %.1f%% (%s) since %s, the maximum is %g%%",
+			diff.Growth, formatDelta(diff.Delta), baselineRef, b.size.maxGrowth))
+	}
+
+	return diff, errors.Join(errs...)
+}
@@ -0,0 +1,231 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+)
+
+func TestNewSizeCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  func(m *Main)
+		want    *sizeCheck
+		wantErr string
+	}{
+		{
+			name:   "disabled",
+			update: func(m *Main) {},
+		},
+		{
+			name:   "budget",
+			update: func(m *Main) { m.SizeBudget = "1.5MiB" },
+			want:   &sizeCheck{budget: 1572864},
+		},
+		{
+			name:   "growth",
+			update: func(m *Main) { m.SizeMaxGrowth = 10 },
+			want:   &sizeCheck{diff: true, maxGrowth: 10},
+		},
+		{
+			name:   "baseline",
+			update: func(m *Main) { m.SizeBaseline = "registry.example.com/app:stable" },
+			want:   &sizeCheck{diff: true, baseline: "registry.example.com/app:stable"},
+		},
+		{
+			name:    "invalid budget",
+			update:  func(m *Main) { m.SizeBudget = "big" },
+			wantErr: "invalid size-budget",
+		},
+		{
+			name:    "negative growth",
+			update:  func(m *Main) { m.SizeMaxGrowth = -5 },
+			wantErr: "invalid size-max-growth",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := &Settings{}
+			tt.update(&settings.Main)
+
+			check, errs := newSizeCheck(settings)
+			if tt.wantErr != "" {
+				if len(errs) == 0 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+					t.Fatalf("expected %q error, got %v", tt.wantErr, errs)
+				}
+				return
+			}
+
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+
+			if (check == nil) != (tt.want == nil) || (check != nil && *check != *tt.want) {
+				t.Errorf("newSizeCheck() = %+v, want %+v", check, tt.want)
+			}
+		})
+	}
+}
+
+// saveImage writes the image as a tarball, like kaniko with tar-path.
+func saveImage(t *testing.T, img v1.Image) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "image.tar")
+
+	tag, err := name.NewTag("registry.example.com/app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tarball.WriteToFile(path, tag, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestCheckSize(t *testing.T) {
+	baseline, err := random.Image(4096, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := random.Layer(2048, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := mutate.AppendLayers(baseline, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imageDiff, err := crane.DiffImages(baseline, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := imageDiff.Size
+	growth := imageDiff.Growth()
+	path := saveImage(t, img)
+
+	tests := []struct {
+		name     string
+		check    sizeCheck
+		baseline v1.Image
+		wantErr  []string
+	}{
+		{
+			name:  "within budget",
+			check: sizeCheck{budget: size},
+		},
+		{
+			name:    "over budget",
+			check:   sizeCheck{budget: size - 1},
+			wantErr: []string{"exceeds the budget"},
+		},
+		{
+			name:     "within growth",
+			check:    sizeCheck{diff: true, maxGrowth: growth + 1},
+			baseline: baseline,
+		},
+		{
+			name:     "over growth",
+			check:    sizeCheck{diff: true, maxGrowth: growth - 1},
+			baseline: baseline,
+			wantErr:  []string{"image grew"},
+		},
+		{
+			name:     "over budget and growth",
+			check:    sizeCheck{diff: true, budget: size / 2, maxGrowth: growth / 2},
+			baseline: baseline,
+			wantErr:  []string{"exceeds the budget", "image grew"},
+		},
+		{
+			// the growth can't be checked without a previous image
+			name:  "growth without baseline",
+			check: sizeCheck{diff: true, maxGrowth: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &build{size: &tt.check}
+
+			diff, err := b.checkSize(context.Background(), &Settings{}, path, nil, tt.baseline, "registry.example.com/app:latest")
+
+			for _, want := range tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %q error, got %v", want, err)
+				}
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if tt.baseline == nil {
+				if diff != nil {
+					t.Errorf("unexpected size diff without baseline: %+v", diff)
+				}
+				return
+			}
+
+			if diff == nil {
+				t.Fatal("missing size diff")
+			}
+
+			if diff.BaselineSize != imageDiff.BaseSize || diff.Size != size || diff.Delta != imageDiff.Delta() || diff.Growth != growth {
+				t.Errorf("size diff = %+v, want %+v", diff, imageDiff)
+			}
+
+			if len(diff.AddedLayers) != 1 || len(diff.RemovedLayers) != 0 {
+				t.Errorf("layers added %d, removed %d, want 1 and 0", len(diff.AddedLayers), len(diff.RemovedLayers))
+			}
+		})
+	}
+}
+
+func TestFormatDelta(t *testing.T) {
+	for delta, want := range map[int64]string{0: "+0B", 2048: "+2.0KiB", -1536: "-1.5KiB"} {
+		if got := formatDelta(delta); got != want {
+			t.Errorf("formatDelta(%d) = %s, want %s", delta, got, want)
+		}
+	}
+}
+
+func TestCheckedBeforePush(t *testing.T) {
+	tests := []struct {
+		name string
+		b    build
+		want bool
+	}{
+		{name: "no checks", b: build{}},
+		{name: "size diff", b: build{size: &sizeCheck{diff: true}}},
+		{name: "size budget", b: build{size: &sizeCheck{budget: 1024}}, want: true},
+		{name: "size growth", b: build{size: &sizeCheck{diff: true, maxGrowth: 10}}, want: true},
+		{name: "scan gate", b: build{scan: &scanGate{}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.checkedBeforePush(); got != tt.want {
+				t.Errorf("checkedBeforePush() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Execution phases with their own timeout
+const (
+	phaseTotal    = "execution"
+	phaseWarm     = "cache warm"
+	phaseBuild    = "build"
+	phasePush     = "push"
+	phaseScan     = "scan"
+	phasePolicy   = "policy"
+	phaseSize     = "size check"
+	phaseManifest = "manifest push"
+	phaseMirror   = "mirror"
+)
+
+// phaseTimeoutError is the cause of a context canceled because the phase took too long.
+type phaseTimeoutError struct {
+	phase   string
+	timeout time.Duration
+}
+
+func (e *phaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.phase, e.timeout)
+}
+
+// withPhaseTimeout returns a context that is canceled after the timeout of the phase, if any.
+func withPhaseTimeout(ctx context.Context, phase string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeoutCause(ctx, timeout, &phaseTimeoutError{phase: phase, timeout: timeout})
+}
+
+// phaseError adds the reason of the cancellation to the error, as the error of a killed
+// command doesn't tell which phase timed out or if the plugin was interrupted.
+func phaseError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", context.Cause(ctx), err)
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestSleepProcess is the fake kaniko run by TestPhaseTimeout. It runs until it's stopped.
+func TestSleepProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_SLEEP_PROCESS") != "1" {
+		return
+	}
+
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+func TestPhaseTimeout(t *testing.T) {
+	ctx, cancel := withPhaseTimeout(context.Background(), phaseBuild, 500*time.Millisecond)
+	defer cancel()
+
+	cmd := newCommand(ctx, os.Args[0], "-test.run=TestSleepProcess", "--")
+	cmd.Env = append(os.Environ(), "GO_WANT_SLEEP_PROCESS=1")
+
+	start := time.Now()
+	err := phaseError(ctx, runCmd(cmd, "phase", phaseBuild))
+
+	// the process is stopped by the timeout, not killed after the wait delay
+	if elapsed := time.Since(start); elapsed >= commandWaitDelay {
+		t.Errorf("command stopped after %s", elapsed)
+	}
+
+	var timeoutErr *phaseTimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.phase != phaseBuild || timeoutErr.timeout != 500*time.Millisecond {
+		t.Fatalf("error = %v, want a build timeout", err)
+	}
+
+	if want := "build timed out after 500ms: signal: terminated"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("error = %v, want the exit error of the command", err)
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() || status.Signal() != syscall.SIGTERM {
+		t.Errorf("process status = %v, want stopped by SIGTERM", exitErr.ProcessState)
+	}
+
+	// a timeout covers every attempt of the phase, so it isn't retried
+	if isRetryable(err) {
+		t.Error("phase timeout is retryable")
+	}
+}
+
+func TestPhaseErrorWithoutTimeout(t *testing.T) {
+	ctx, cancel := withPhaseTimeout(context.Background(), phaseBuild, 0)
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("context without timeout has a deadline")
+	}
+
+	// the errors of a command that wasn't stopped are kept as they are
+	cmdErr := errors.New("exit status 1")
+	if err := phaseError(ctx, cmdErr); err != cmdErr {
+		t.Errorf("error = %v, want %v", err, cmdErr)
+	}
+
+	// an interruption of the step is reported instead of a timeout
+	cancel()
+	if err := phaseError(ctx, cmdErr); !errors.Is(err, context.Canceled) || !errors.Is(err, cmdErr) {
+		t.Errorf("error = %v, want canceled", err)
+	}
+}
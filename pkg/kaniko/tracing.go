@@ -0,0 +1,79 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+	"go.megpoid.dev/drone-kaniko/pkg/tracing"
+)
+
+const (
+	tracingServiceName = "drone-kaniko"
+	// time given to the exporter after the run, even if the step was canceled
+	tracingShutdownTimeout = 10 * time.Second
+)
+
+// buildTraceID returns a trace id derived from the Drone build, so every step of the same build
+// that uses it reports to the same trace.
+func buildTraceID(pipeline *drone.Pipeline) [16]byte {
+	var traceID [16]byte
+
+	if pipeline.Repo.Slug == "" || pipeline.Build.Number == 0 {
+		return traceID
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		pipeline.System.Host,
+		pipeline.Repo.Slug,
+		strconv.Itoa(pipeline.Build.Number),
+	}, "/")))
+	copy(traceID[:], sum[:])
+
+	return traceID
+}
+
+// StartTracing enables the trace export if configured with the OpenTelemetry variables and
+// starts the span of the step. The returned context has to be used as the network context of
+// the plugin, and the returned function ends the step span and exports the trace.
+func StartTracing(ctx context.Context, pipeline *drone.Pipeline) (context.Context, func(err error)) {
+	tracer := tracing.NewFromEnv(tracing.Config{
+		ServiceName: tracingServiceName,
+		TraceID:     buildTraceID(pipeline),
+		Mask:        secrets.Mask,
+	})
+	if tracer == nil {
+		return ctx, func(error) {}
+	}
+
+	slog.Debug("Tracing enabled", "trace_id", tracer.TraceID())
+
+	ctx, span := tracing.Start(tracing.ContextWithTracer(ctx, tracer), "step "+pipeline.Step.Name,
+		"repo", pipeline.Repo.Slug,
+		"build", pipeline.Build.Number,
+		"stage", pipeline.Stage.Name,
+		"step", pipeline.Step.Name,
+		"commit", pipeline.Commit.SHA,
+		"branch", currentBranch(pipeline),
+		"link", pipeline.Build.Link,
+	)
+
+	return ctx, func(err error) {
+		span.End(err)
+
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tracingShutdownTimeout)
+		defer cancel()
+
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Failed to export traces", "error", err)
+		}
+	}
+}
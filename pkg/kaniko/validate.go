@@ -0,0 +1,188 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+var (
+	compressionValues  = []string{"gzip", "zstd"}
+	snapshotModeValues = []string{"full", "redo", "time"}
+	logFormatValues    = []string{"text", "color", "json"}
+	verbosityValues    = []string{"panic", "fatal", "error", "warn", "info", "debug", "trace"}
+	proxyCaseValues    = []string{ProxyCaseBoth, ProxyCaseLower, ProxyCaseUpper}
+)
+
+// compression level ranges supported by kaniko, -1 uses the default level
+var compressionLevels = map[string][2]int{
+	"":     {0, 9},
+	"gzip": {0, 9},
+	"zstd": {1, 22},
+}
+
+// validateChoice checks that the value, if set, is one of the allowed values.
+func validateChoice(flag, value string, allowed []string) error {
+	if value == "" || slices.Contains(allowed, value) {
+		return nil
+	}
+
+	return fmt.Errorf("invalid %s %q, must be one of: %s", flag, value, strings.Join(allowed, ", "))
+}
+
+func validateCompressionLevel(compression string, level int) error {
+	limits, ok := compressionLevels[compression]
+	if !ok || level == -1 {
+		return nil
+	}
+
+	if level < limits[0] || level > limits[1] {
+		return fmt.Errorf("invalid compression-level %d, must be between %d and %d", level, limits[0], limits[1])
+	}
+
+	return nil
+}
+
+// validateKeyValue checks entries with the format key=value.
+func validateKeyValue(flag string, entries []string) []error {
+	var errs []error
+	for _, entry := range entries {
+		parts := strings.Split(entry, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errs = append(errs, fmt.Errorf("invalid %s: %s", flag, entry))
+		}
+	}
+
+	return errs
+}
+
+// validateRegistryMap checks entries with the format 'original=new;other-original=other-new'.
+func validateRegistryMap(entries []string) []error {
+	var errs []error
+	for _, entry := range entries {
+		for _, mapping := range strings.Split(entry, ";") {
+			original, remap, found := strings.Cut(mapping, "=")
+			if !found || original == "" || remap == "" || strings.Contains(remap, "=") {
+				errs = append(errs, fmt.Errorf("invalid registry-map: %s", entry))
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+func validateDestinations(destinations []string) []error {
+	var errs []error
+	for _, destination := range destinations {
+		if _, err := name.NewTag(destination); err != nil {
+			errs = append(errs, fmt.Errorf("invalid destination %s: %w", destination, err))
+		}
+	}
+
+	return errs
+}
+
+// validateSettings checks the values of the settings that map to kaniko flags.
+func validateSettings(settings *Settings) []error {
+	var errs []error
+
+	errs = append(errs, validateDestinations(settings.Destinations)...)
+	errs = append(errs, validateKeyValue("registry-certificate", settings.RegistryCertificates)...)
+	errs = append(errs, validateKeyValue("registry-client-cert", settings.RegistryClientCerts)...)
+	errs = append(errs, validateRegistryMap(settings.RegistryMap)...)
+
+	if err := validateChoice("proxy-case", settings.Main.ProxyCase, proxyCaseValues); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateChoice("compression", settings.Compression, compressionValues); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateCompressionLevel(settings.Compression, settings.CompressionLevel); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateChoice("snapshot-mode", settings.SnapshotMode, snapshotModeValues); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateChoice("log-format", settings.LogFormat, logFormatValues); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateChoice("verbosity", settings.Verbosity, verbosityValues); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// isLocalContext checks if the build context is a local directory instead of a remote one
+// supported by kaniko (git, s3, gcs, etc).
+func isLocalContext(settings *Settings) bool {
+	if settings.Git != "" {
+		return false
+	}
+
+	scheme, _, found := strings.Cut(settings.Context, "://")
+	return !found || scheme == "dir"
+}
+
+// validateBuildContext checks that the context directory and the dockerfile exist. The
+// dockerfile is looked up like kaniko does, as given or relative to the context.
+func validateBuildContext(settings *Settings) []error {
+	if !isLocalContext(settings) {
+		return nil
+	}
+
+	contextDir := filepath.Join(strings.TrimPrefix(settings.Context, "dir://"), settings.ContextSubPath)
+	info, err := os.Stat(contextDir)
+	if err != nil {
+		return []error{fmt.Errorf("invalid context %s: %w", contextDir, err)}
+	}
+
+	if !info.IsDir() {
+		return []error{fmt.Errorf("invalid context %s: not a directory", contextDir)}
+	}
+
+	if resolveDockerfile(settings) == "" {
+		return []error{fmt.Errorf("dockerfile %s not found in the working directory or context", dockerfileName(settings))}
+	}
+
+	return nil
+}
+
+func dockerfileName(settings *Settings) string {
+	if settings.Dockerfile == "" {
+		return "Dockerfile"
+	}
+
+	return settings.Dockerfile
+}
+
+// resolveDockerfile returns the path of the dockerfile of a local context, as given or relative
+// to the context like kaniko does, or an empty string if not found.
+func resolveDockerfile(settings *Settings) string {
+	dockerfile := dockerfileName(settings)
+
+	if _, err := os.Stat(dockerfile); err == nil {
+		return dockerfile
+	}
+
+	contextDir := filepath.Join(strings.TrimPrefix(settings.Context, "dir://"), settings.ContextSubPath)
+	if _, err := os.Stat(filepath.Join(contextDir, dockerfile)); err == nil {
+		return filepath.Join(contextDir, dockerfile)
+	}
+
+	return ""
+}
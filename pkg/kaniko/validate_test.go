@@ -0,0 +1,139 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(s *Settings)
+		wantErrs []string
+	}{
+		{
+			name:   "defaults",
+			update: func(s *Settings) {},
+		},
+		{
+			name: "valid choices",
+			update: func(s *Settings) {
+				s.Main.ProxyCase = ProxyCaseUpper
+				s.Compression = "zstd"
+				s.SnapshotMode = "redo"
+				s.LogFormat = "json"
+				s.Verbosity = "debug"
+			},
+		},
+		{
+			name: "invalid choices",
+			update: func(s *Settings) {
+				s.Main.ProxyCase = "mixed"
+				s.Compression = "xz"
+				s.SnapshotMode = "Full"
+				s.LogFormat = "yaml"
+				s.Verbosity = "verbose"
+			},
+			wantErrs: []string{
+				`invalid proxy-case "mixed", must be one of: both, lower, upper`,
+				`invalid compression "xz", must be one of: gzip, zstd`,
+				`invalid snapshot-mode "Full", must be one of: full, redo, time`,
+				`invalid log-format "yaml", must be one of: text, color, json`,
+				`invalid verbosity "verbose", must be one of: panic, fatal, error, warn, info, debug, trace`,
+			},
+		},
+		{
+			name:   "gzip level",
+			update: func(s *Settings) { s.Compression = "gzip"; s.CompressionLevel = 9 },
+		},
+		{
+			name:     "gzip level too high",
+			update:   func(s *Settings) { s.Compression = "gzip"; s.CompressionLevel = 10 },
+			wantErrs: []string{"invalid compression-level 10, must be between 0 and 9"},
+		},
+		{
+			name:     "default compression level too high",
+			update:   func(s *Settings) { s.CompressionLevel = 19 },
+			wantErrs: []string{"invalid compression-level 19, must be between 0 and 9"},
+		},
+		{
+			name:   "zstd level",
+			update: func(s *Settings) { s.Compression = "zstd"; s.CompressionLevel = 19 },
+		},
+		{
+			name:     "zstd level zero",
+			update:   func(s *Settings) { s.Compression = "zstd"; s.CompressionLevel = 0 },
+			wantErrs: []string{"invalid compression-level 0, must be between 1 and 22"},
+		},
+		{
+			// the compression is already reported as invalid
+			name:     "unknown compression level",
+			update:   func(s *Settings) { s.Compression = "xz"; s.CompressionLevel = 30 },
+			wantErrs: []string{`invalid compression "xz"`},
+		},
+		{
+			name: "registry map",
+			update: func(s *Settings) {
+				s.RegistryMap = []string{"index.docker.io=mirror.gcr.io", "gcr.io=127.0.0.1:5000;quay.io=quay.mirror.example.com"}
+			},
+		},
+		{
+			name: "invalid registry map",
+			update: func(s *Settings) {
+				s.RegistryMap = []string{"index.docker.io", "gcr.io=", "=mirror.gcr.io", "gcr.io=a=b", "gcr.io=127.0.0.1:5000;quay.io"}
+			},
+			wantErrs: []string{
+				"invalid registry-map: index.docker.io",
+				"invalid registry-map: gcr.io=",
+				"invalid registry-map: =mirror.gcr.io",
+				"invalid registry-map: gcr.io=a=b",
+				"invalid registry-map: gcr.io=127.0.0.1:5000;quay.io",
+			},
+		},
+		{
+			name: "certificates",
+			update: func(s *Settings) {
+				s.RegistryCertificates = []string{"registry.example.com=/certs/ca.crt", "registry.example.com"}
+				s.RegistryClientCerts = []string{"registry.example.com=/certs/client.crt,/certs/client.key", "=/certs/client.crt"}
+			},
+			wantErrs: []string{
+				"invalid registry-certificate: registry.example.com",
+				"invalid registry-client-cert: =/certs/client.crt",
+			},
+		},
+		{
+			name: "destinations",
+			update: func(s *Settings) {
+				s.Destinations = []string{"registry.example.com:5000/team/app:1.0", "app", "registry.example.com/App:1.0", "registry.example.com/app:1.0+build", "registry.example.com/app@sha256:" + strings.Repeat("a", 64)}
+			},
+			wantErrs: []string{
+				"invalid destination registry.example.com/App:1.0",
+				"invalid destination registry.example.com/app:1.0+build",
+				"invalid destination registry.example.com/app@sha256:",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the compression level defaults to -1, the default level of kaniko
+			settings := &Settings{Destinations: []string{"registry.example.com/app:1.0"}, CompressionLevel: -1}
+			tt.update(settings)
+
+			errs := validateSettings(settings)
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("errors = %v, want %d", errs, len(tt.wantErrs))
+			}
+
+			for idx, err := range errs {
+				if !strings.Contains(err.Error(), tt.wantErrs[idx]) {
+					t.Errorf("error = %q, want %q", err, tt.wantErrs[idx])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,432 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.megpoid.dev/drone-kaniko/pkg/crane"
+	"go.megpoid.dev/drone-kaniko/pkg/tracing"
+	"golang.org/x/sync/errgroup"
+)
+
+// Warmer policies, used to decide what to do when the warmer fails to cache an image
+// for a reason other than the image not existing yet.
+const (
+	WarmerPolicyIgnore = "ignore"
+	WarmerPolicyWarn   = "warn"
+	WarmerPolicyFail   = "fail"
+)
+
+// defaultCacheDir is the cache directory used by kaniko if none is specified.
+const defaultCacheDir = "/cache"
+
+const (
+	warmerMsgPresent = "Image already in cache: "
+	warmerMsgError   = "Error while trying to warm image: "
+)
+
+// warmerNotFoundRegexp matches the warmer errors of the images that don't exist (yet): the
+// registry error codes or the 404 status of the manifest request, and the platform missing
+// from the index. Other failures can mention "not found", like a credential helper without
+// credentials, and must not be skipped.
+var warmerNotFoundRegexp = regexp.MustCompile(`(GET|HEAD) \S+/manifests/\S+: (MANIFEST_UNKNOWN|NAME_UNKNOWN|unexpected status code 404 Not Found)\b|no child with platform `)
+
+type warmStatus string
+
+const (
+	warmCached  warmStatus = "cached"
+	warmPresent warmStatus = "present"
+	warmMissing warmStatus = "missing"
+	warmFailed  warmStatus = "failed"
+)
+
+// warmSummary keeps track of the outcome of each image processed by the warmer.
+type warmSummary struct {
+	platform string
+	images   []string
+	status   map[string]warmStatus
+	reasons  map[string]string
+}
+
+func newWarmSummary(platform string) *warmSummary {
+	return &warmSummary{
+		platform: platform,
+		status:   make(map[string]warmStatus),
+		reasons:  make(map[string]string),
+	}
+}
+
+func (s *warmSummary) add(image string, status warmStatus) {
+	if _, ok := s.status[image]; !ok {
+		s.images = append(s.images, image)
+	}
+	s.status[image] = status
+}
+
+// parseLine inspects a line of the warmer output, either in text or json format.
+func (s *warmSummary) parseLine(line string) {
+	if strings.HasPrefix(line, "{") {
+		var entry struct {
+			Msg string `json:"msg"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err == nil {
+			line = entry.Msg
+		}
+	}
+
+	if _, image, found := strings.Cut(line, warmerMsgPresent); found {
+		s.add(strings.TrimSpace(image), warmPresent)
+		return
+	}
+
+	if _, rest, found := strings.Cut(line, warmerMsgError); found {
+		image, reason, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		if isImageNotFound(reason) {
+			s.add(image, warmMissing)
+		} else {
+			s.add(image, warmFailed)
+		}
+		s.reasons[image] = reason
+	}
+}
+
+func (s *warmSummary) list(status warmStatus) []string {
+	var images []string
+	for _, image := range s.images {
+		if s.status[image] == status {
+			images = append(images, image)
+		}
+	}
+
+	return images
+}
+
+// check applies the warmer policy to the failed images. Missing images are always tolerated
+// since they are expected on the first run of a pipeline.
+func (s *warmSummary) check(policy string, runErr error) error {
+	for _, image := range s.list(warmMissing) {
+		slog.Info("Image not found, skipping cache", "image", image, "platform", s.platform)
+	}
+
+	var errs []error
+	for _, image := range s.list(warmFailed) {
+		errs = append(errs, fmt.Errorf("%s: %s", image, s.reasons[image]))
+	}
+
+	// the warmer failed without reporting any image, e.g. invalid arguments
+	if runErr != nil && len(s.reasons) == 0 {
+		errs = append(errs, runErr)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	err := errors.Join(errs...)
+
+	switch policy {
+	case WarmerPolicyFail:
+		return fmt.Errorf("failed to warm cache: %w", err)
+	case WarmerPolicyIgnore:
+		slog.Debug("Failed to warm cache", "platform", s.platform, "error", err)
+	default:
+		slog.Warn("Failed to warm cache", "platform", s.platform, "error", err)
+	}
+
+	return nil
+}
+
+func (s *warmSummary) log() {
+	slog.Info("Cache warmer summary",
+		"phase", phaseWarm,
+		"platform", s.platform,
+		"cached", s.list(warmCached),
+		"present", s.list(warmPresent),
+		"missing", s.list(warmMissing),
+		"failed", s.list(warmFailed),
+	)
+}
+
+func isImageNotFound(reason string) bool {
+	return warmerNotFoundRegexp.MatchString(reason)
+}
+
+// warmTracker keeps track of the digests warmed by the warmers of every build. A digest is
+// claimed by a single warmer at a time, as warmers running at the same time would write the same
+// file of the cache directory, and is marked as warmed only if that warmer succeeds.
+type warmTracker struct {
+	mu      sync.Mutex
+	warmed  map[string]bool
+	running map[string]chan struct{}
+}
+
+func newWarmTracker() *warmTracker {
+	return &warmTracker{
+		warmed:  make(map[string]bool),
+		running: make(map[string]chan struct{}),
+	}
+}
+
+// claim reserves the digest for a warmer. It returns false with the channel closed when the
+// warmer of the digest finishes if another warmer has it, or false without a channel if the
+// digest is already warmed.
+func (t *warmTracker) claim(digest string) (bool, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.warmed[digest] {
+		return false, nil
+	}
+
+	if done, ok := t.running[digest]; ok {
+		return false, done
+	}
+
+	t.running[digest] = make(chan struct{})
+
+	return true, nil
+}
+
+// release frees the digest claimed by a warmer, marking it as warmed if the warmer succeeded.
+func (t *warmTracker) release(digest string, warmed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if warmed {
+		t.warmed[digest] = true
+	}
+
+	if done, ok := t.running[digest]; ok {
+		close(done)
+		delete(t.running, digest)
+	}
+}
+
+// warmCache warms the cache for every platform, running up to WarmerConcurrency warmers at once.
+// The images are resolved to their digest first so every digest is warmed by a single warmer.
+// Digests warmed by a previous build, or already present in the cache directory, are skipped.
+func warmCache(ctx context.Context, settings *Settings, platforms []string, tracker *warmTracker) error {
+	images := uniqueStrings(settings.Main.Images)
+
+	// digests of the images per platform, empty if the digest cannot be resolved
+	digests := make([][]string, len(platforms))
+
+	resolver := new(errgroup.Group)
+	resolver.SetLimit(max(settings.Main.WarmerConcurrency, 1))
+
+	for idx, platform := range platforms {
+		digests[idx] = make([]string, len(images))
+		for i, image := range images {
+			resolver.Go(func() error {
+				digests[idx][i] = resolveDigest(ctx, settings, platform, image)
+				return nil
+			})
+		}
+	}
+
+	_ = resolver.Wait()
+
+	group := new(errgroup.Group)
+	group.SetLimit(max(settings.Main.WarmerConcurrency, 1))
+
+	for idx, platform := range platforms {
+		group.Go(func() error {
+			return warmPlatform(ctx, settings, platform, images, digests[idx], tracker)
+		})
+	}
+
+	return group.Wait()
+}
+
+// warmPlatform runs the warmer of the platform with the images it could claim. The images claimed
+// by another warmer are planned again after it finishes, so they are retried if it failed.
+func warmPlatform(ctx context.Context, settings *Settings, platform string, images, digests []string, tracker *warmTracker) error {
+	var errs []error
+
+	for len(images) > 0 || settings.Dockerfile != "" {
+		warmSettings, summary, waiting := planWarmer(settings, platform, images, digests, tracker)
+
+		if len(warmSettings.Main.Images) == 0 && warmSettings.Dockerfile == "" {
+			summary.log()
+		} else {
+			errs = append(errs, runWarmer(ctx, warmSettings, summary))
+		}
+
+		for i, image := range images {
+			if digests[i] != "" && slices.Contains(warmSettings.Main.Images, image) {
+				status := summary.status[image]
+				tracker.release(digests[i], status == warmCached || status == warmPresent)
+			}
+		}
+
+		// the base images of the dockerfile are only warmed once
+		next := *settings
+		next.Dockerfile = ""
+		settings = &next
+
+		images, digests = nil, nil
+		for _, wait := range waiting {
+			<-wait.done
+			images = append(images, wait.image)
+			digests = append(digests, wait.digest)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// warmWait is an image of the platform claimed by another warmer.
+type warmWait struct {
+	image  string
+	digest string
+	done   <-chan struct{}
+}
+
+// planWarmer returns the settings of the warmer of the platform with the images it claimed, and
+// the images claimed by other warmers. Images already warmed, or present in the cache directory,
+// are skipped.
+func planWarmer(settings *Settings, platform string, images, digests []string, tracker *warmTracker) (*Settings, *warmSummary, []warmWait) {
+	warmSettings := *settings
+	warmSettings.CustomPlatform = platform
+	warmSettings.Main.Images = nil
+
+	summary := newWarmSummary(platform)
+
+	var waiting []warmWait
+
+	for i, image := range images {
+		digest := digests[i]
+
+		if digest != "" {
+			claimed, done := tracker.claim(digest)
+			switch {
+			case done != nil:
+				slog.Debug("Image being warmed by another warmer", "image", image, "digest", digest, "platform", platform)
+				waiting = append(waiting, warmWait{image: image, digest: digest, done: done})
+				continue
+			case !claimed:
+				slog.Debug("Image warmed by another warmer", "image", image, "digest", digest, "platform", platform)
+				summary.add(image, warmPresent)
+				continue
+			}
+		}
+
+		if !warmSettings.Main.ForceCache && isDigestCached(&warmSettings, digest) {
+			slog.Debug("Image already in cache", "image", image, "digest", digest, "platform", platform)
+			summary.add(image, warmPresent)
+			tracker.release(digest, true)
+			continue
+		}
+
+		summary.add(image, warmCached)
+		warmSettings.Main.Images = append(warmSettings.Main.Images, image)
+	}
+
+	return &warmSettings, summary, waiting
+}
+
+// resolveDigest returns the digest of the image for the platform, or an empty string if it
+// cannot be resolved.
+func resolveDigest(ctx context.Context, settings *Settings, platform, image string) string {
+	opts := append(craneOptions(settings, image, true), crane.WithContext(ctx), crane.WithPlatform(currentPlatform(platform)))
+
+	digest, err := crane.Digest(image, opts...)
+	if err != nil {
+		slog.Debug("Cannot resolve image digest", "image", image, "platform", platform, "error", err)
+		return ""
+	}
+
+	return digest
+}
+
+// isDigestCached checks if the image with the digest is already in the cache directory.
+func isDigestCached(settings *Settings, digest string) bool {
+	if digest == "" {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(cacheDirectory(settings), digest))
+	if err != nil {
+		return false
+	}
+
+	// kaniko ignores the cached image after the ttl expires
+	if settings.CacheTTL > 0 && time.Since(info.ModTime()) > settings.CacheTTL {
+		return false
+	}
+
+	return true
+}
+
+// currentPlatform parses the given platform, or returns the host platform if empty or invalid.
+func currentPlatform(platform string) *v1.Platform {
+	if platform != "" {
+		if p, err := v1.ParsePlatform(platform); err == nil {
+			return p
+		}
+	}
+
+	return &v1.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// runWarmer runs the kaniko warmer and classifies the outcome of every image.
+func runWarmer(ctx context.Context, settings *Settings, summary *warmSummary) (err error) {
+	_, span := tracing.Start(ctx, phaseWarm, "platform", settings.CustomPlatform, "images", settings.Main.Images)
+	defer func() { span.End(err) }()
+
+	cmd := commandWarmer(ctx, settings)
+
+	attrs := []any{"phase", phaseWarm, "platform", settings.CustomPlatform}
+
+	output := newLineWriter(summary.parseLine)
+	stdout, stderr, flush := newOutputWriters(attrs...)
+	cmd.Stdout = io.MultiWriter(stdout, output)
+	cmd.Stderr = io.MultiWriter(stderr, output)
+	trace(cmd, attrs...)
+
+	err = cmd.Run()
+	output.Flush()
+	flush()
+
+	if err != nil {
+		// the warmer exits with an error only if none of the images could be cached, or if it
+		// couldn't run at all
+		for _, image := range summary.list(warmCached) {
+			summary.add(image, warmFailed)
+			summary.reasons[image] = err.Error()
+		}
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return err
+	}
+
+	summary.log()
+	span.SetAttributes(
+		"cached", summary.list(warmCached),
+		"present", summary.list(warmPresent),
+		"missing", summary.list(warmMissing),
+		"failed", summary.list(warmFailed),
+	)
+
+	return summary.check(settings.Main.WarmerPolicy, err)
+}
@@ -0,0 +1,250 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package kaniko
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlanWarmer(t *testing.T) {
+	settings := &Settings{}
+	settings.CacheDir = t.TempDir()
+
+	// the image is already in the cache directory
+	if err := os.WriteFile(filepath.Join(settings.CacheDir, "sha256:cached"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	images := []string{"alpine:3.20", "golang:1.23", "busybox:latest", "app:latest"}
+	tracker := newWarmTracker()
+
+	// alpine has a single platform, golang an index and the app digest cannot be resolved
+	amd64, summary, waiting := planWarmer(settings, "linux/amd64", images, []string{"sha256:alpine", "sha256:golang-amd64", "sha256:cached", ""}, tracker)
+	if want := []string{"alpine:3.20", "golang:1.23", "app:latest"}; !reflect.DeepEqual(amd64.Main.Images, want) {
+		t.Errorf("amd64 images = %v, want %v", amd64.Main.Images, want)
+	}
+	if want := []string{"busybox:latest"}; !reflect.DeepEqual(summary.list(warmPresent), want) {
+		t.Errorf("amd64 present = %v, want %v", summary.list(warmPresent), want)
+	}
+	if len(waiting) != 0 {
+		t.Errorf("amd64 waiting = %v", waiting)
+	}
+
+	// alpine is being warmed by the amd64 warmer
+	arm64, summary, waiting := planWarmer(settings, "linux/arm64", images, []string{"sha256:alpine", "sha256:golang-arm64", "sha256:cached", ""}, tracker)
+	if want := []string{"golang:1.23", "app:latest"}; !reflect.DeepEqual(arm64.Main.Images, want) {
+		t.Errorf("arm64 images = %v, want %v", arm64.Main.Images, want)
+	}
+	if want := []string{"busybox:latest"}; !reflect.DeepEqual(summary.list(warmPresent), want) {
+		t.Errorf("arm64 present = %v, want %v", summary.list(warmPresent), want)
+	}
+	if len(waiting) != 1 || waiting[0].image != "alpine:3.20" || waiting[0].digest != "sha256:alpine" {
+		t.Fatalf("arm64 waiting = %v", waiting)
+	}
+
+	if amd64.CustomPlatform != "linux/amd64" || arm64.CustomPlatform != "linux/arm64" {
+		t.Errorf("platforms = %s and %s", amd64.CustomPlatform, arm64.CustomPlatform)
+	}
+
+	// the amd64 warmer fails to warm alpine, so the arm64 warmer retries it
+	tracker.release("sha256:alpine", false)
+	tracker.release("sha256:golang-amd64", true)
+
+	select {
+	case <-waiting[0].done:
+	default:
+		t.Fatal("alpine still claimed")
+	}
+
+	retry, _, waiting := planWarmer(settings, "linux/arm64", []string{"alpine:3.20"}, []string{"sha256:alpine"}, tracker)
+	if want := []string{"alpine:3.20"}; !reflect.DeepEqual(retry.Main.Images, want) || len(waiting) != 0 {
+		t.Errorf("arm64 retry images = %v, waiting %v", retry.Main.Images, waiting)
+	}
+
+	tracker.release("sha256:alpine", true)
+	tracker.release("sha256:golang-arm64", true)
+
+	// a later build with the same warmed digests doesn't warm them again
+	next, summary, _ := planWarmer(settings, "linux/amd64", images[:2], []string{"sha256:alpine", "sha256:golang-amd64"}, tracker)
+	if len(next.Main.Images) != 0 {
+		t.Errorf("images warmed again: %v", next.Main.Images)
+	}
+	if want := images[:2]; !reflect.DeepEqual(summary.list(warmPresent), want) {
+		t.Errorf("present = %v, want %v", summary.list(warmPresent), want)
+	}
+
+	if len(settings.Main.Images) != 0 {
+		t.Errorf("planWarmer modified the settings: %v", settings.Main.Images)
+	}
+}
+
+func TestPlanWarmerFailed(t *testing.T) {
+	settings := &Settings{}
+	settings.CacheDir = t.TempDir()
+
+	tracker := newWarmTracker()
+
+	first, _, _ := planWarmer(settings, "linux/amd64", []string{"alpine:3.20"}, []string{"sha256:alpine"}, tracker)
+	tracker.release("sha256:alpine", false)
+
+	// a later build retries the digest the previous build failed to warm
+	next, _, _ := planWarmer(settings, "linux/amd64", []string{"alpine:3.20"}, []string{"sha256:alpine"}, tracker)
+	if !reflect.DeepEqual(first.Main.Images, next.Main.Images) || len(next.Main.Images) != 1 {
+		t.Errorf("images = %v, want %v", next.Main.Images, first.Main.Images)
+	}
+}
+
+func TestPlanWarmerForceCache(t *testing.T) {
+	settings := &Settings{}
+	settings.CacheDir = t.TempDir()
+	settings.Main.ForceCache = true
+
+	if err := os.WriteFile(filepath.Join(settings.CacheDir, "sha256:cached"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	warmSettings, _, _ := planWarmer(settings, "linux/amd64", []string{"alpine:3.20"}, []string{"sha256:cached"}, newWarmTracker())
+	if want := []string{"alpine:3.20"}; !reflect.DeepEqual(warmSettings.Main.Images, want) {
+		t.Errorf("images = %v, want %v", warmSettings.Main.Images, want)
+	}
+}
+
+func TestWarmSummaryParseLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantImage  string
+		wantStatus warmStatus
+	}{
+		{
+			name:       "present",
+			line:       "\x1b[36mINFO\x1b[0m[0000] Image already in cache: alpine:3.20",
+			wantImage:  "alpine:3.20",
+			wantStatus: warmPresent,
+		},
+		{
+			name:       "present json",
+			line:       `{"level":"info","msg":"Image already in cache: alpine:3.20","time":"2024-07-10T12:10:59Z"}`,
+			wantImage:  "alpine:3.20",
+			wantStatus: warmPresent,
+		},
+		{
+			name:       "manifest unknown",
+			line:       "\x1b[33mWARN\x1b[0m[0001] Error while trying to warm image: registry.example.com/app:cache GET https://registry.example.com/v2/app/manifests/cache: MANIFEST_UNKNOWN: manifest unknown; map[Tag:cache]",
+			wantImage:  "registry.example.com/app:cache",
+			wantStatus: warmMissing,
+		},
+		{
+			name:       "name unknown json",
+			line:       `{"level":"warning","msg":"Error while trying to warm image: registry.example.com/new:latest GET https://registry.example.com/v2/new/manifests/latest: NAME_UNKNOWN: repository name not known to registry; map[name:new]","time":"2024-07-10T12:10:59Z"}`,
+			wantImage:  "registry.example.com/new:latest",
+			wantStatus: warmMissing,
+		},
+		{
+			name:       "manifest 404",
+			line:       "WARN[0001] Error while trying to warm image: registry.example.com/app:cache HEAD https://registry.example.com/v2/app/manifests/cache: unexpected status code 404 Not Found (HEAD responses have no body, use GET for details)",
+			wantImage:  "registry.example.com/app:cache",
+			wantStatus: warmMissing,
+		},
+		{
+			name:       "missing platform",
+			line:       "WARN[0001] Error while trying to warm image: registry.example.com/tool:1.0 no child with platform linux/arm64 in index registry.example.com/tool:1.0",
+			wantImage:  "registry.example.com/tool:1.0",
+			wantStatus: warmMissing,
+		},
+		{
+			name:       "credentials not found",
+			line:       "WARN[0001] Error while trying to warm image: registry.example.com/app:cache error getting credentials - err: exit status 1, out: `credentials not found in native keychain`",
+			wantImage:  "registry.example.com/app:cache",
+			wantStatus: warmFailed,
+		},
+		{
+			name:       "token 404",
+			line:       "WARN[0001] Error while trying to warm image: registry.example.com/app:cache GET https://registry.example.com/token?scope=repository%3Aapp%3Apull&service=registry: unexpected status code 404 Not Found",
+			wantImage:  "registry.example.com/app:cache",
+			wantStatus: warmFailed,
+		},
+		{
+			name:       "unauthorized",
+			line:       "WARN[0001] Error while trying to warm image: ghcr.io/org/private:latest GET https://ghcr.io/token?scope=repository%3Aorg%2Fprivate%3Apull&service=ghcr.io: DENIED: requested access to the resource is denied",
+			wantImage:  "ghcr.io/org/private:latest",
+			wantStatus: warmFailed,
+		},
+		{
+			name:       "network",
+			line:       `WARN[0001] Error while trying to warm image: registry.example.com/app:cache Get "https://registry.example.com/v2/": dial tcp: lookup registry.example.com on 127.0.0.11:53: no such host`,
+			wantImage:  "registry.example.com/app:cache",
+			wantStatus: warmFailed,
+		},
+		{
+			name: "other output",
+			line: "\x1b[36mINFO\x1b[0m[0000] Retrieving image manifest alpine:3.20",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := newWarmSummary("linux/amd64")
+			summary.parseLine(tt.line)
+
+			if tt.wantImage == "" {
+				if len(summary.images) != 0 {
+					t.Errorf("images = %v, want none", summary.images)
+				}
+				return
+			}
+
+			if want := []string{tt.wantImage}; !reflect.DeepEqual(summary.images, want) {
+				t.Fatalf("images = %v, want %v", summary.images, want)
+			}
+			if got := summary.status[tt.wantImage]; got != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestWarmSummaryCheck(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	tests := []struct {
+		name    string
+		status  map[string]warmStatus
+		policy  string
+		runErr  error
+		wantErr bool
+	}{
+		{name: "cached", status: map[string]warmStatus{"alpine:3.20": warmCached, "golang:1.23": warmPresent}, policy: WarmerPolicyFail},
+		{name: "missing", status: map[string]warmStatus{"app:cache": warmMissing}, policy: WarmerPolicyFail},
+		{name: "failed", status: map[string]warmStatus{"app:cache": warmFailed}, policy: WarmerPolicyFail, wantErr: true},
+		{name: "failed warn", status: map[string]warmStatus{"app:cache": warmFailed}, policy: WarmerPolicyWarn},
+		{name: "failed ignore", status: map[string]warmStatus{"app:cache": warmFailed}, policy: WarmerPolicyIgnore},
+		{name: "run error", policy: WarmerPolicyFail, runErr: exitErr, wantErr: true},
+		{name: "run error warn", policy: WarmerPolicyWarn, runErr: exitErr},
+		// the exit status comes from the images that failed, already handled by the policy
+		{name: "run error of missing image", status: map[string]warmStatus{"app:cache": warmMissing}, policy: WarmerPolicyFail, runErr: exitErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := newWarmSummary("linux/amd64")
+			for image, status := range tt.status {
+				summary.add(image, status)
+				if status == warmMissing || status == warmFailed {
+					summary.reasons[image] = "GET https://registry.example.com/v2/app/manifests/cache: UNAUTHORIZED"
+				}
+			}
+
+			err := summary.check(tt.policy, tt.runErr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@
 package manifest
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
@@ -12,6 +14,10 @@ import (
 	"github.com/estesp/manifest-tool/v2/pkg/types"
 )
 
+// ErrPushAbandoned is returned when the context is done before the push finished. The push keeps
+// running in the background, so it must not be retried or two pushes would write the same tags.
+var ErrPushAbandoned = errors.New("manifest list push abandoned")
+
 type Config struct {
 	Username      string
 	Password      string
@@ -21,7 +27,9 @@ type Config struct {
 	ConfigDir     string
 }
 
-func Push(target string, tags []string, srcImages []types.ManifestEntry, config Config) error {
+// Push pushes the manifest list to the registry. The push can't be canceled, so if the context
+// is done first the push is abandoned and ErrPushAbandoned is returned with the error of the context.
+func Push(ctx context.Context, target string, tags []string, srcImages []types.ManifestEntry, config Config) (string, error) {
 	yamlInput := types.YAMLInput{
 		Image:     target,
 		Tags:      tags,
@@ -30,21 +38,38 @@ func Push(target string, tags []string, srcImages []types.ManifestEntry, config
 
 	manifestType := types.Docker
 
-	digest, length, err := registry.PushManifestList(
-		config.Username,
-		config.Password,
-		yamlInput,
-		config.IgnoreMissing,
-		config.Insecure,
-		config.PlainHTTP,
-		manifestType,
-		config.ConfigDir,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to push manifest list: %w", err)
+	type result struct {
+		digest string
+		length int
+		err    error
 	}
 
-	slog.Info("Manifest pushed to registry", "digest", digest, "length", length)
+	done := make(chan result, 1)
+
+	go func() {
+		digest, length, err := registry.PushManifestList(
+			config.Username,
+			config.Password,
+			yamlInput,
+			config.IgnoreMissing,
+			config.Insecure,
+			config.PlainHTTP,
+			manifestType,
+			config.ConfigDir,
+		)
+		done <- result{digest: digest, length: length, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", fmt.Errorf("%w: %w", ErrPushAbandoned, ctx.Err())
+	case res := <-done:
+		if res.err != nil {
+			return "", fmt.Errorf("failed to push manifest list: %w", res.err)
+		}
 
-	return nil
+		slog.Info("Manifest pushed to registry", "digest", res.digest, "length", res.length)
+
+		return res.digest, nil
+	}
 }
@@ -0,0 +1,55 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package manifest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/estesp/manifest-tool/v2/pkg/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPushAbandoned(t *testing.T) {
+	// the registry doesn't answer until the test finishes
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	images := []types.ManifestEntry{
+		{Image: host + "/app:1.0-amd64", Platform: ocispec.Platform{OS: "linux", Architecture: "amd64"}},
+		{Image: host + "/app:1.0-arm64", Platform: ocispec.Platform{OS: "linux", Architecture: "arm64"}},
+	}
+
+	start := time.Now()
+	digest, err := Push(ctx, host+"/app:1.0", nil, images, Config{PlainHTTP: true, ConfigDir: t.TempDir()})
+
+	if !errors.Is(err, ErrPushAbandoned) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want push abandoned after the deadline", err)
+	}
+
+	if digest != "" {
+		t.Errorf("digest = %s", digest)
+	}
+
+	// the push is left running instead of waiting for the registry
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("push returned after %s", elapsed)
+	}
+}
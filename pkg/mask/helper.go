@@ -0,0 +1,287 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package mask
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Masked is the text that replaces the secret values.
+const Masked = "********"
+
+// minLength is the minimum length of a value to be masked, shorter values would hide
+// unrelated output.
+const minLength = 4
+
+// DefaultPatterns are the names of the variables that are considered secret by default.
+var DefaultPatterns = []string{"*TOKEN*", "*PASSWORD*", "*KEY*", "*SECRET*"}
+
+// Masker replaces secret values in text. It's safe for concurrent use.
+type Masker struct {
+	mu       sync.RWMutex
+	values   []string
+	replacer *strings.Replacer
+}
+
+// New creates an empty masker.
+func New() *Masker {
+	return &Masker{}
+}
+
+// Add registers secret values to be masked.
+func (m *Masker) Add(values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := false
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) < minLength || slices.Contains(m.values, value) {
+			continue
+		}
+		m.values = append(m.values, value)
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+
+	// longer values first, so a secret containing another one is fully masked
+	slices.SortFunc(m.values, func(a, b string) int {
+		return len(b) - len(a)
+	})
+
+	pairs := make([]string, 0, len(m.values)*2)
+	for _, value := range m.values {
+		pairs = append(pairs, value, Masked)
+	}
+	m.replacer = strings.NewReplacer(pairs...)
+}
+
+// Mask returns the text with every secret value replaced.
+func (m *Masker) Mask(text string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.replacer == nil {
+		return text
+	}
+
+	return m.replacer.Replace(text)
+}
+
+// MaskAll returns a copy of the entries with every secret value replaced.
+func (m *Masker) MaskAll(entries []string) []string {
+	masked := make([]string, len(entries))
+	for i, entry := range entries {
+		masked[i] = m.Mask(entry)
+	}
+
+	return masked
+}
+
+// MaskValue returns a copy of the value with every secret replaced in its strings, including
+// the fields of structs and the entries of slices and maps. Values have to be masked before
+// they are encoded, since the encoding can escape the characters of a secret, like JSON does
+// with quotes or <, > and &.
+func (m *Masker) MaskValue(v any) any {
+	if v == nil {
+		return nil
+	}
+
+	return m.maskValue(reflect.ValueOf(v)).Interface()
+}
+
+func (m *Masker) maskValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		masked := reflect.New(v.Type()).Elem()
+		masked.SetString(m.Mask(v.String()))
+		return masked
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		masked := reflect.New(v.Type().Elem())
+		masked.Elem().Set(m.maskValue(v.Elem()))
+		return masked
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		masked := reflect.New(v.Type()).Elem()
+		masked.Set(m.maskValue(v.Elem()))
+		return masked
+	case reflect.Struct:
+		// the unexported fields are copied as is
+		masked := reflect.New(v.Type()).Elem()
+		masked.Set(v)
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				masked.Field(i).Set(m.maskValue(v.Field(i)))
+			}
+		}
+		return masked
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		masked := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			masked.Index(i).Set(m.maskValue(v.Index(i)))
+		}
+		return masked
+	case reflect.Array:
+		masked := reflect.New(v.Type()).Elem()
+		for i := range v.Len() {
+			masked.Index(i).Set(m.maskValue(v.Index(i)))
+		}
+		return masked
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		masked := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			masked.SetMapIndex(m.maskValue(iter.Key()), m.maskValue(iter.Value()))
+		}
+		return masked
+	default:
+		return v
+	}
+}
+
+// IsSecretName checks if the variable name matches any of the patterns, ignoring the case.
+func IsSecretName(name string, patterns []string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(pattern), name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writer masks the data written to it line by line before passing it to the wrapped writer,
+// so secrets split across writes are still masked. Close writes any pending data.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	masker *Masker
+	buf    []byte
+}
+
+// NewWriter wraps the writer with the masker.
+func (m *Masker) NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, masker: m}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	idx := bytes.LastIndexByte(w.buf, '\n')
+	if idx < 0 {
+		return len(p), nil
+	}
+
+	if _, err := io.WriteString(w.w, w.masker.Mask(string(w.buf[:idx+1]))); err != nil {
+		return 0, err
+	}
+	w.buf = w.buf[idx+1:]
+
+	return len(p), nil
+}
+
+// Close writes the remaining data that wasn't terminated by a newline.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(w.w, w.masker.Mask(string(w.buf)))
+	w.buf = nil
+
+	return err
+}
+
+// handler is a slog.Handler that masks the message and string attributes of every record.
+type handler struct {
+	next   slog.Handler
+	masker *Masker
+}
+
+// NewHandler wraps the slog handler with the masker.
+func (m *Masker) NewHandler(next slog.Handler) slog.Handler {
+	return &handler{next: next, masker: m}
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	masked := slog.NewRecord(record.Time, record.Level, h.masker.Mask(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		masked.AddAttrs(h.maskAttr(attr))
+		return true
+	})
+
+	return h.next.Handle(ctx, masked)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		masked = append(masked, h.maskAttr(attr))
+	}
+
+	return &handler{next: h.next.WithAttrs(masked), masker: h.masker}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name), masker: h.masker}
+}
+
+func (h *handler) maskAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, h.masker.Mask(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		masked := make([]any, 0, len(group))
+		for _, entry := range group {
+			masked = append(masked, h.maskAttr(entry))
+		}
+		return slog.Group(attr.Key, masked...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, h.masker.Mask(v.Error()))
+		case []string:
+			return slog.Any(attr.Key, h.masker.MaskAll(v))
+		}
+	}
+
+	return slog.Attr{Key: attr.Key, Value: value}
+}
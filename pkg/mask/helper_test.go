@@ -0,0 +1,202 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package mask
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	m := New()
+
+	if got := m.Mask("nothing to mask"); got != "nothing to mask" {
+		t.Errorf("Mask() = %s", got)
+	}
+
+	// short values would hide unrelated output, and the spaces around the values are ignored
+	m.Add("abc", "", " s3cr3t\n", "s3cr3t-extended", "p@ss<&>\"word", "s3cr3t")
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"token s3cr3t", "token ********"},
+		{"abc and s3cr3t-extended", "abc and ********"},
+		{"s3cr3ts3cr3t", "****************"},
+		{"login -p p@ss<&>\"word", "login -p ********"},
+		{" s3cr3", " s3cr3"},
+	}
+
+	for _, tt := range tests {
+		if got := m.Mask(tt.text); got != tt.want {
+			t.Errorf("Mask(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	if got, want := m.MaskAll([]string{"--password", "s3cr3t"}), []string{"--password", "********"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MaskAll() = %v, want %v", got, want)
+	}
+}
+
+func TestMaskValue(t *testing.T) {
+	type result struct {
+		Name    string            `json:"name"`
+		Args    []string          `json:"args"`
+		Labels  map[string]string `json:"labels"`
+		Extra   any               `json:"extra"`
+		Next    *result           `json:"next,omitempty"`
+		Size    int               `json:"size"`
+		private string
+	}
+
+	m := New()
+	m.Add(`p@ss<&>"word`)
+
+	value := &result{
+		Name:    `login p@ss<&>"word`,
+		Args:    []string{"--password", `p@ss<&>"word`},
+		Labels:  map[string]string{`p@ss<&>"word`: `p@ss<&>"word`},
+		Extra:   []any{`p@ss<&>"word`, 1},
+		Next:    &result{Name: `p@ss<&>"word`},
+		Size:    10,
+		private: `p@ss<&>"word`,
+	}
+
+	masked, ok := m.MaskValue(value).(*result)
+	if !ok {
+		t.Fatalf("MaskValue() = %T", m.MaskValue(value))
+	}
+
+	want := &result{
+		Name:    "login ********",
+		Args:    []string{"--password", "********"},
+		Labels:  map[string]string{"********": "********"},
+		Extra:   []any{"********", 1},
+		Next:    &result{Name: "********"},
+		Size:    10,
+		private: `p@ss<&>"word`,
+	}
+	if !reflect.DeepEqual(masked, want) {
+		t.Errorf("MaskValue() = %+v, want %+v", masked, want)
+	}
+
+	// the original value is not modified
+	if value.Args[1] != `p@ss<&>"word` || value.Next.Name != `p@ss<&>"word` || value.Labels[`p@ss<&>"word`] == "" {
+		t.Errorf("MaskValue() modified the value: %+v", value)
+	}
+
+	// the encoder escapes the secret, so masking the encoded text misses it
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded := m.Mask(string(data)); strings.Contains(encoded, Masked) {
+		t.Errorf("encoded secret masked: %s", encoded)
+	}
+
+	if m.MaskValue(nil) != nil {
+		t.Error("MaskValue(nil) isn't nil")
+	}
+}
+
+func TestIsSecretName(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     bool
+	}{
+		{"GITHUB_TOKEN", DefaultPatterns, true},
+		{"docker_password", DefaultPatterns, true},
+		{"PLUGIN_API_KEY", DefaultPatterns, true},
+		{"Secret", DefaultPatterns, true},
+		{"PLUGIN_REPO", DefaultPatterns, false},
+		{"NPM_AUTH", []string{"*_AUTH"}, true},
+		{"NPM_AUTH_URL", []string{"*_AUTH"}, false},
+		{"CI_DEPLOY_USER", []string{"CI_?EPLOY_*"}, true},
+		{"GITHUB_TOKEN", nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsSecretName(tt.name, tt.patterns); got != tt.want {
+			t.Errorf("IsSecretName(%s, %v) = %t, want %t", tt.name, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	m := New()
+	m.Add("s3cr3t-token")
+
+	var buf bytes.Buffer
+	w := m.NewWriter(&buf)
+
+	// the secret is split across writes, and the lines are only written when complete
+	for _, chunk := range []string{"login s3c", "r3t-to", "ken\nnext ", "line s3cr3t-", "token"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := buf.String(); got != "login ********\n" {
+		t.Errorf("written = %q before close", got)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), "login ********\nnext line ********"; got != want {
+		t.Errorf("written = %q, want %q", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.Add("s3cr3t-token")
+
+	var buf bytes.Buffer
+	logger := slog.New(m.NewHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	logger.With("auth", "Bearer s3cr3t-token").WithGroup("push").Info("Pushing with s3cr3t-token",
+		"error", errors.New("unauthorized: s3cr3t-token"),
+		"args", []string{"--token", "s3cr3t-token"},
+		slog.Group("registry", "password", "s3cr3t-token", "port", 5000),
+		"attempts", 2,
+	)
+	logger.Debug("Filtered s3cr3t-token")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("unexpected output %s: %v", buf.String(), err)
+	}
+
+	want := map[string]any{
+		"level": "INFO",
+		"msg":   "Pushing with ********",
+		"auth":  "Bearer ********",
+		"push": map[string]any{
+			"error":    "unauthorized: ********",
+			"args":     []any{"--token", "********"},
+			"registry": map[string]any{"password": "********", "port": float64(5000)},
+			"attempts": float64(2),
+		},
+	}
+	delete(record, "time")
+
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("record = %v, want %v", record, want)
+	}
+
+	if !m.NewHandler(slog.NewJSONHandler(&buf, nil)).Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("handler disabled")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.megpoid.dev/drone-kaniko/pkg/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy has the rules checked against the built image. Rules that aren't set are skipped.
+//
+// Example:
+//
+//	non-root: true
+//	required-labels:
+//	  - org.opencontainers.image.source
+//	  - com.example.team=platform
+//	max-layers: 30
+//	max-size: 500MiB
+//	allowed-ports:
+//	  - 8080
+//	  - 9090/tcp
+//	allowed-registries:
+//	  - ghcr.io/example
+//	  - docker.io/library
+type Policy struct {
+	NonRoot           bool     `yaml:"non-root"`
+	RequiredLabels    []string `yaml:"required-labels"`
+	MaxLayers         int      `yaml:"max-layers"`
+	MaxSize           string   `yaml:"max-size"`
+	AllowedPorts      []string `yaml:"allowed-ports"`
+	AllowedRegistries []string `yaml:"allowed-registries"`
+
+	maxSize int64
+}
+
+// Violation is a rule that the image doesn't follow.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Rule + ": " + v.Message
+}
+
+// Load reads the policy file, in YAML or JSON. Unknown rules are rejected so a typo doesn't
+// disable a check silently.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	policy := &Policy{}
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", path, err)
+	}
+
+	if policy.maxSize, err = cache.ParseSize(policy.MaxSize); err != nil {
+		return nil, fmt.Errorf("invalid max-size in policy %s: %w", path, err)
+	}
+
+	if policy.MaxLayers < 0 {
+		return nil, fmt.Errorf("invalid max-layers in policy %s: %d", path, policy.MaxLayers)
+	}
+
+	for _, port := range policy.AllowedPorts {
+		if _, err := normalizePort(port); err != nil {
+			return nil, fmt.Errorf("invalid allowed-ports in policy %s: %w", path, err)
+		}
+	}
+
+	for _, entry := range policy.AllowedRegistries {
+		if _, err := normalizeRegistry(entry); err != nil {
+			return nil, fmt.Errorf("invalid allowed-registries in policy %s: %w", path, err)
+		}
+	}
+
+	return policy, nil
+}
+
+// Evaluate checks the image against the rules. The base images are the references used by the
+// FROM instructions of the build.
+func (p *Policy) Evaluate(img v1.Image, baseImages []string) ([]Violation, error) {
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the image config: %w", err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the image manifest: %w", err)
+	}
+
+	var violations []Violation
+
+	if p.NonRoot && isRoot(config.Config.User) {
+		user := config.Config.User
+		if user == "" {
+			user = "not set"
+		}
+		violations = append(violations, Violation{"non-root", fmt.Sprintf("image runs as root (user %s)", user)})
+	}
+
+	for _, label := range p.RequiredLabels {
+		key, value, hasValue := strings.Cut(label, "=")
+		actual, ok := config.Config.Labels[key]
+
+		switch {
+		case !ok:
+			violations = append(violations, Violation{"required-labels", fmt.Sprintf("missing label %s", key)})
+		case hasValue && actual != value:
+			violations = append(violations, Violation{"required-labels", fmt.Sprintf("label %s is %q, expected %q", key, actual, value)})
+		}
+	}
+
+	if p.MaxLayers > 0 && len(manifest.Layers) > p.MaxLayers {
+		violations = append(violations, Violation{"max-layers", fmt.Sprintf("image has %d layers, the maximum is %d", len(manifest.Layers), p.MaxLayers)})
+	}
+
+	if p.maxSize > 0 {
+		size := manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+
+		if size > p.maxSize {
+			violations = append(violations, Violation{"max-size", fmt.Sprintf("compressed size is %s, the maximum is %s", cache.FormatSize(size), cache.FormatSize(p.maxSize))})
+		}
+	}
+
+	if p.AllowedPorts != nil {
+		allowed := make(map[string]bool, len(p.AllowedPorts))
+		for _, port := range p.AllowedPorts {
+			normalized, _ := normalizePort(port)
+			allowed[normalized] = true
+		}
+
+		ports := make([]string, 0, len(config.Config.ExposedPorts))
+		for port := range config.Config.ExposedPorts {
+			ports = append(ports, port)
+		}
+		sort.Strings(ports)
+
+		for _, port := range ports {
+			if normalized, err := normalizePort(port); err != nil || !allowed[normalized] {
+				violations = append(violations, Violation{"allowed-ports", fmt.Sprintf("port %s is not allowed", port)})
+			}
+		}
+	}
+
+	if p.AllowedRegistries != nil {
+		for _, image := range baseImages {
+			if err := p.checkRegistry(image); err != nil {
+				violations = append(violations, Violation{"allowed-registries", err.Error()})
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+// isRoot checks if the user of the image config is root. An empty user defaults to root.
+func isRoot(user string) bool {
+	user, _, _ = strings.Cut(user, ":")
+	return user == "" || user == "root" || user == "0"
+}
+
+// normalizePort returns the port with its protocol, tcp if not set.
+func normalizePort(port string) (string, error) {
+	number, protocol, found := strings.Cut(strings.TrimSpace(port), "/")
+	if !found {
+		protocol = "tcp"
+	}
+
+	if number == "" || strings.Trim(number, "0123456789") != "" {
+		return "", fmt.Errorf("invalid port: %s", port)
+	}
+
+	return number + "/" + strings.ToLower(protocol), nil
+}
+
+// normalizeRegistry returns the full name of an allowed registry, or repository prefix, so the
+// aliases of Docker Hub match. The prefix isn't parsed as a repository since Docker Hub would
+// add the library namespace to it.
+func normalizeRegistry(entry string) (string, error) {
+	host, path, _ := strings.Cut(strings.TrimSuffix(entry, "/"), "/")
+
+	registry, err := name.NewRegistry(host)
+	if err != nil {
+		return "", err
+	}
+
+	if path == "" {
+		return registry.Name(), nil
+	}
+
+	return registry.Name() + "/" + path, nil
+}
+
+// checkRegistry checks that the image comes from one of the allowed registries or repository
+// prefixes.
+func (p *Policy) checkRegistry(image string) error {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return fmt.Errorf("cannot parse base image %s", image)
+	}
+
+	registry := ref.Context().RegistryStr()
+	repo := ref.Context().Name()
+
+	for _, entry := range p.AllowedRegistries {
+		allowed, err := normalizeRegistry(entry)
+		if err != nil {
+			continue
+		}
+
+		if allowed == registry || allowed == repo || strings.HasPrefix(repo, allowed+"/") {
+			return nil
+		}
+	}
+
+	return errors.New("base image " + image + " is not from an allowed registry")
+}
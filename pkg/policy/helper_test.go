@@ -0,0 +1,208 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+// testImage returns a random image with three layers and the given config.
+func testImage(t *testing.T, config v1.Config) v1.Image {
+	t.Helper()
+
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err = mutate.Config(img, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+func TestEvaluate(t *testing.T) {
+	compliant := v1.Config{
+		User:         "app:app",
+		Labels:       map[string]string{"org.opencontainers.image.source": "https://github.com/example/app", "com.example.team": "platform"},
+		ExposedPorts: map[string]struct{}{"8080/tcp": {}, "9090/udp": {}},
+	}
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		config     v1.Config
+		baseImages []string
+		want       []Violation
+	}{
+		{
+			name:   "no rules",
+			config: v1.Config{},
+		},
+		{
+			name: "compliant",
+			policy: Policy{
+				NonRoot:           true,
+				RequiredLabels:    []string{"org.opencontainers.image.source", "com.example.team=platform"},
+				MaxLayers:         3,
+				AllowedPorts:      []string{"8080", "9090/UDP"},
+				AllowedRegistries: []string{"docker.io/library", "ghcr.io/example/"},
+				maxSize:           1 << 20,
+			},
+			config:     compliant,
+			baseImages: []string{"alpine:3.20", "ghcr.io/example/base:1.0"},
+		},
+		{
+			name:   "root user",
+			policy: Policy{NonRoot: true},
+			config: v1.Config{User: "0:0"},
+			want:   []Violation{{"non-root", "image runs as root (user 0:0)"}},
+		},
+		{
+			name:   "user not set",
+			policy: Policy{NonRoot: true},
+			config: v1.Config{},
+			want:   []Violation{{"non-root", "image runs as root (user not set)"}},
+		},
+		{
+			name:   "labels",
+			policy: Policy{RequiredLabels: []string{"org.opencontainers.image.source", "com.example.team=security", "com.example.owner"}},
+			config: compliant,
+			want: []Violation{
+				{"required-labels", `label com.example.team is "platform", expected "security"`},
+				{"required-labels", "missing label com.example.owner"},
+			},
+		},
+		{
+			name:   "layers and size",
+			policy: Policy{MaxLayers: 2, maxSize: 1024},
+			config: compliant,
+			want: []Violation{
+				{"max-layers", "image has 3 layers, the maximum is 2"},
+				{"max-size", ""},
+			},
+		},
+		{
+			name:   "ports",
+			policy: Policy{AllowedPorts: []string{"8080/tcp"}},
+			config: compliant,
+			want:   []Violation{{"allowed-ports", "port 9090/udp is not allowed"}},
+		},
+		{
+			// an empty list allows no ports
+			name:   "no ports allowed",
+			policy: Policy{AllowedPorts: []string{}},
+			config: v1.Config{ExposedPorts: map[string]struct{}{"443": {}}},
+			want:   []Violation{{"allowed-ports", "port 443 is not allowed"}},
+		},
+		{
+			name:       "registries",
+			policy:     Policy{AllowedRegistries: []string{"ghcr.io/example", "registry.example.com:5000"}},
+			config:     compliant,
+			baseImages: []string{"ghcr.io/example/base:1.0", "registry.example.com:5000/tools/go:1.23", "ghcr.io/example-other/base:1.0", "golang:1.23", "INVALID::"},
+			want: []Violation{
+				{"allowed-registries", "base image ghcr.io/example-other/base:1.0 is not from an allowed registry"},
+				{"allowed-registries", "base image golang:1.23 is not from an allowed registry"},
+				{"allowed-registries", "cannot parse base image INVALID::"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := tt.policy.Evaluate(testImage(t, tt.config), tt.baseImages)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the compressed size of the random layers varies, so only the rule is compared
+			for idx := range violations {
+				if violations[idx].Rule == "max-size" {
+					violations[idx].Message = ""
+				}
+			}
+
+			if !reflect.DeepEqual(violations, tt.want) {
+				t.Errorf("violations = %v, want %v", violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRoot(t *testing.T) {
+	tests := []struct {
+		user string
+		want bool
+	}{
+		{"", true},
+		{"root", true},
+		{"0", true},
+		{"0:0", true},
+		{"root:app", true},
+		{"app", false},
+		{"1000:1000", false},
+		{"app:0", false},
+		{"00", false},
+	}
+
+	for _, tt := range tests {
+		if got := isRoot(tt.user); got != tt.want {
+			t.Errorf("isRoot(%q) = %t, want %t", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePort(t *testing.T) {
+	tests := []struct {
+		port    string
+		want    string
+		wantErr bool
+	}{
+		{port: "8080", want: "8080/tcp"},
+		{port: " 53/UDP ", want: "53/udp"},
+		{port: "9090/tcp", want: "9090/tcp"},
+		{port: "", wantErr: true},
+		{port: "/tcp", wantErr: true},
+		{port: "http", wantErr: true},
+		{port: "8080-8090", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizePort(tt.port)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizePort(%q) = %q, %v, want %q and error %t", tt.port, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalizeRegistry(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{entry: "ghcr.io", want: "ghcr.io"},
+		{entry: "ghcr.io/example/", want: "ghcr.io/example"},
+		{entry: "docker.io", want: "index.docker.io"},
+		{entry: "docker.io/library", want: "index.docker.io/library"},
+		{entry: "index.docker.io/example/app", want: "index.docker.io/example/app"},
+		{entry: "registry.example.com:5000", want: "registry.example.com:5000"},
+		{entry: "registry example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeRegistry(tt.entry)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeRegistry(%q) = %q, %v, want %q and error %t", tt.entry, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Report formats of the command scanner
+const (
+	FormatSARIF = "sarif"
+	FormatJSON  = "json"
+)
+
+// FormatNames are the accepted report formats.
+var FormatNames = []string{FormatSARIF, FormatJSON}
+
+// Placeholders replaced in the arguments of the scanner
+const (
+	PlaceholderImage  = "{image}"
+	PlaceholderOutput = "{output}"
+)
+
+// defaultArgs are the arguments used for the known scanners if none are given.
+var defaultArgs = map[string][]string{
+	"trivy": {"image", "--quiet", "--input", PlaceholderImage, "--format", FormatSARIF, "--output", PlaceholderOutput},
+	"grype": {"docker-archive:" + PlaceholderImage, "--quiet", "--output", FormatSARIF, "--file", PlaceholderOutput},
+}
+
+// DefaultArgs returns the arguments for a known scanner binary, or nil.
+func DefaultArgs(command string) []string {
+	return defaultArgs[filepath.Base(command)]
+}
+
+// CommandScanner runs a scanner binary and parses its report. The report is read from the
+// {output} file, or from the standard output if the arguments don't have that placeholder.
+type CommandScanner struct {
+	Command string
+	Args    []string
+	Format  string
+	// Stderr receives the log of the scanner
+	Stderr io.Writer
+	// NewCommand creates the command, exec.CommandContext if not set
+	NewCommand func(ctx context.Context, name string, args ...string) *exec.Cmd
+}
+
+func (s *CommandScanner) Scan(ctx context.Context, image string) ([]Finding, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(image), "scan-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the report directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "report."+s.Format)
+	useStdout := true
+
+	args := make([]string, 0, len(s.Args))
+	for _, arg := range s.Args {
+		if strings.Contains(arg, PlaceholderOutput) {
+			useStdout = false
+		}
+		arg = strings.ReplaceAll(arg, PlaceholderImage, image)
+		arg = strings.ReplaceAll(arg, PlaceholderOutput, output)
+		args = append(args, arg)
+	}
+
+	var stdout bytes.Buffer
+
+	newCommand := s.NewCommand
+	if newCommand == nil {
+		newCommand = exec.CommandContext
+	}
+
+	cmd := newCommand(ctx, s.Command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = s.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("scanner %s failed: %w", s.Command, err)
+	}
+
+	data := stdout.Bytes()
+	if !useStdout {
+		if data, err = os.ReadFile(output); err != nil {
+			return nil, fmt.Errorf("failed to read the scanner report: %w", err)
+		}
+	}
+
+	if s.Format == FormatJSON {
+		return ParseJSON(data)
+	}
+
+	return ParseSARIF(data)
+}
+
+type sarifReport struct {
+	Runs []struct {
+		Tool struct {
+			Driver struct {
+				Rules []sarifRule `json:"rules"`
+			} `json:"driver"`
+		} `json:"tool"`
+		Results []struct {
+			RuleID string `json:"ruleId"`
+			Level  string `json:"level"`
+		} `json:"results"`
+	} `json:"runs"`
+}
+
+type sarifRule struct {
+	ID               string `json:"id"`
+	ShortDescription struct {
+		Text string `json:"text"`
+	} `json:"shortDescription"`
+	Properties struct {
+		SecuritySeverity string   `json:"security-severity"`
+		Tags             []string `json:"tags"`
+	} `json:"properties"`
+}
+
+// severity returns the severity of the rule, from its tags or its CVSS score.
+func (r sarifRule) severity() Severity {
+	for _, tag := range r.Properties.Tags {
+		if severity := ParseSeverity(tag); severity != SeverityUnknown {
+			return severity
+		}
+	}
+
+	score, err := strconv.ParseFloat(r.Properties.SecuritySeverity, 64)
+	if err != nil {
+		return SeverityUnknown
+	}
+
+	// CVSS v3 qualitative ratings
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	default:
+		return SeverityUnknown
+	}
+}
+
+// vulnerability returns the vulnerability id of the rule and the package, if the rule is per
+// package. grype adds the package to the id, e.g. CVE-2022-3602-libssl3, while the description
+// starts with the vulnerability id.
+func (r sarifRule) vulnerability(ruleID string) (string, string) {
+	id, _, _ := strings.Cut(r.ShortDescription.Text, " ")
+	if pkg, found := strings.CutPrefix(ruleID, id+"-"); found && id != "" && pkg != "" {
+		return id, pkg
+	}
+
+	return ruleID, ""
+}
+
+// sarifLevelSeverity is used for the results of rules without a severity.
+func sarifLevelSeverity(level string) Severity {
+	switch level {
+	case "error":
+		return SeverityHigh
+	case "warning":
+		return SeverityMedium
+	case "note":
+		return SeverityLow
+	default:
+		return SeverityUnknown
+	}
+}
+
+// ParseSARIF reads the findings of a SARIF report, like the ones written by trivy and grype.
+func ParseSARIF(data []byte) ([]Finding, error) {
+	var report sarifReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse the SARIF report: %w", err)
+	}
+
+	var findings []Finding
+
+	for _, run := range report.Runs {
+		rules := make(map[string]sarifRule, len(run.Tool.Driver.Rules))
+		for _, rule := range run.Tool.Driver.Rules {
+			rules[rule.ID] = rule
+		}
+
+		seen := make(map[string]bool)
+
+		for _, result := range run.Results {
+			if seen[result.RuleID] {
+				continue
+			}
+			seen[result.RuleID] = true
+
+			rule := rules[result.RuleID]
+
+			severity := rule.severity()
+			if severity == SeverityUnknown {
+				severity = sarifLevelSeverity(result.Level)
+			}
+
+			id, pkg := rule.vulnerability(result.RuleID)
+			findings = append(findings, Finding{ID: id, Severity: severity, Package: pkg})
+		}
+	}
+
+	return findings, nil
+}
+
+type trivyReport struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			Severity         string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID       string `json:"id"`
+			Severity string `json:"severity"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+// ParseJSON reads the findings of a trivy or grype JSON report.
+func ParseJSON(data []byte) ([]Finding, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse the JSON report: %w", err)
+	}
+
+	var findings []Finding
+
+	switch {
+	case fields["Results"] != nil || fields["SchemaVersion"] != nil:
+		var report trivyReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("failed to parse the trivy report: %w", err)
+		}
+
+		for _, result := range report.Results {
+			for _, vuln := range result.Vulnerabilities {
+				findings = append(findings, Finding{
+					ID:       vuln.VulnerabilityID,
+					Severity: ParseSeverity(vuln.Severity),
+					Package:  vuln.PkgName,
+					Version:  vuln.InstalledVersion,
+				})
+			}
+		}
+	case fields["matches"] != nil:
+		var report grypeReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("failed to parse the grype report: %w", err)
+		}
+
+		for _, match := range report.Matches {
+			findings = append(findings, Finding{
+				ID:       match.Vulnerability.ID,
+				Severity: ParseSeverity(match.Vulnerability.Severity),
+				Package:  match.Artifact.Name,
+				Version:  match.Artifact.Version,
+			})
+		}
+	default:
+		return nil, errors.New("unknown JSON report, only trivy and grype reports are supported")
+	}
+
+	slog.Debug("Parsed scanner report", "findings", len(findings))
+
+	return findings, nil
+}
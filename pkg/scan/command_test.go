@@ -0,0 +1,209 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readReport(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestParseSARIF(t *testing.T) {
+	tests := []struct {
+		report string
+		want   []Finding
+	}{
+		{
+			report: "trivy.sarif",
+			want: []Finding{
+				{ID: "CVE-2023-5363", Severity: SeverityHigh},
+				{ID: "CVE-2023-6129", Severity: SeverityMedium},
+				{ID: "CVE-2024-24790", Severity: SeverityCritical},
+			},
+		},
+		{
+			report: "grype.sarif",
+			want: []Finding{
+				{ID: "CVE-2023-5363", Severity: SeverityHigh, Package: "libcrypto3"},
+				{ID: "CVE-2023-5363", Severity: SeverityHigh, Package: "libssl3"},
+				{ID: "CVE-2023-6129", Severity: SeverityMedium, Package: "libssl3"},
+				{ID: "CVE-2024-24790", Severity: SeverityCritical, Package: "stdlib"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.report, func(t *testing.T) {
+			got, err := ParseSARIF(readReport(t, tt.report))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSARIF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSARIFLevel(t *testing.T) {
+	report := `{"runs": [{"tool": {"driver": {"rules": [{"id": "GHSA-xxxx"}]}}, "results": [{"ruleId": "GHSA-xxxx", "level": "warning"}]}]}`
+
+	got, err := ParseSARIF([]byte(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Finding{{ID: "GHSA-xxxx", Severity: SeverityMedium}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSARIF() = %v, want %v", got, want)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		report string
+		want   []Finding
+	}{
+		{
+			report: "trivy.json",
+			want: []Finding{
+				{ID: "CVE-2023-5363", Severity: SeverityHigh, Package: "libcrypto3", Version: "3.1.3-r0"},
+				{ID: "CVE-2023-6129", Severity: SeverityMedium, Package: "libssl3", Version: "3.1.3-r0"},
+				{ID: "CVE-2024-24790", Severity: SeverityCritical, Package: "stdlib", Version: "v1.22.3"},
+			},
+		},
+		{
+			report: "grype.json",
+			want: []Finding{
+				{ID: "CVE-2023-5363", Severity: SeverityHigh, Package: "libcrypto3", Version: "3.1.3-r0"},
+				{ID: "CVE-2023-6129", Severity: SeverityMedium, Package: "libssl3", Version: "3.1.3-r0"},
+				{ID: "CVE-2024-24790", Severity: SeverityCritical, Package: "stdlib", Version: "go1.22.3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.report, func(t *testing.T) {
+			got, err := ParseJSON(readReport(t, tt.report))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONUnknown(t *testing.T) {
+	if _, err := ParseJSON([]byte(`{"vulnerabilities": []}`)); err == nil {
+		t.Error("expected error for unknown report")
+	}
+}
+
+// TestHelperProcess is the fake scanner run by TestCommandScanner. It copies the report in
+// SCAN_REPORT to the file given with --output, or to the standard output.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	data, err := os.ReadFile(os.Getenv("SCAN_REPORT"))
+	if err != nil {
+		os.Exit(2)
+	}
+
+	args := os.Args
+	for idx, arg := range args {
+		if arg == "--output" && idx+1 < len(args) {
+			if err := os.WriteFile(args[idx+1], data, 0o600); err != nil {
+				os.Exit(2)
+			}
+			os.Exit(0)
+		}
+	}
+
+	os.Stdout.Write(data)
+	os.Exit(0)
+}
+
+func TestCommandScanner(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+		format string
+		args   []string
+		want   int
+	}{
+		{
+			name:   "output file",
+			report: "grype.sarif",
+			format: FormatSARIF,
+			args:   []string{"docker-archive:" + PlaceholderImage, "--output", PlaceholderOutput},
+			want:   4,
+		},
+		{
+			name:   "standard output",
+			report: "trivy.json",
+			format: FormatJSON,
+			args:   []string{"image", "--input", PlaceholderImage},
+			want:   3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := filepath.Abs(filepath.Join("testdata", tt.report))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			image := filepath.Join(t.TempDir(), "image.tar")
+
+			var commandArgs []string
+			scanner := &CommandScanner{
+				Command: "scanner",
+				Args:    tt.args,
+				Format:  tt.format,
+				NewCommand: func(ctx context.Context, name string, args ...string) *exec.Cmd {
+					commandArgs = args
+					cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=TestHelperProcess", "--"}, args...)...)
+					cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "SCAN_REPORT="+report)
+					return cmd
+				},
+			}
+
+			findings, err := scanner.Scan(context.Background(), image)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(findings) != tt.want {
+				t.Errorf("got %d findings, want %d: %v", len(findings), tt.want, findings)
+			}
+
+			if !strings.Contains(strings.Join(commandArgs, " "), image) {
+				t.Errorf("image placeholder not replaced: %q", commandArgs)
+			}
+		})
+	}
+}
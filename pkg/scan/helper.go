@@ -0,0 +1,180 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Severity of a vulnerability, ordered from the least to the most severe.
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"unknown", "low", "medium", "high", "critical"}
+
+// SeverityNames are the accepted severity thresholds.
+var SeverityNames = severityNames[1:]
+
+func (s Severity) String() string {
+	if s < SeverityUnknown || s > SeverityCritical {
+		return severityNames[SeverityUnknown]
+	}
+
+	return severityNames[s]
+}
+
+// ParseSeverity reads a severity name in any case, e.g. HIGH or critical. Names used by some
+// scanners for the same levels, like negligible or moderate, are also accepted.
+func ParseSeverity(name string) Severity {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "critical":
+		return SeverityCritical
+	case "high", "important":
+		return SeverityHigh
+	case "medium", "moderate":
+		return SeverityMedium
+	case "low", "negligible":
+		return SeverityLow
+	default:
+		return SeverityUnknown
+	}
+}
+
+// Finding is a vulnerability found in the image.
+type Finding struct {
+	ID       string
+	Severity Severity
+	Package  string
+	Version  string
+}
+
+func (f Finding) String() string {
+	if f.Package == "" {
+		return fmt.Sprintf("%s (%s)", f.ID, f.Severity)
+	}
+
+	return fmt.Sprintf("%s (%s) in %s %s", f.ID, f.Severity, f.Package, f.Version)
+}
+
+// Scanner finds the vulnerabilities of an image saved as a tarball.
+type Scanner interface {
+	Scan(ctx context.Context, image string) ([]Finding, error)
+}
+
+// Allowlist has the accepted vulnerability ids, with an optional expiration date.
+type Allowlist map[string]time.Time
+
+// LoadAllowlist reads an allowlist file with the same format as .trivyignore, one id per line
+// with an optional expiration like `CVE-2023-1234 exp:2024-01-31`. Lines starting with # are
+// comments.
+func LoadAllowlist(path string) (Allowlist, error) {
+	allowlist := make(Allowlist)
+	if path == "" {
+		return allowlist, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowlist: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var expiration time.Time
+		for _, field := range fields[1:] {
+			value, found := strings.CutPrefix(field, "exp:")
+			if !found {
+				continue
+			}
+
+			if expiration, err = time.Parse(time.DateOnly, value); err != nil {
+				return nil, fmt.Errorf("invalid expiration in allowlist line %d: %s", lineNumber, value)
+			}
+		}
+
+		allowlist[fields[0]] = expiration
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read allowlist: %w", err)
+	}
+
+	return allowlist, nil
+}
+
+// Allows checks if the vulnerability is in the allowlist and hasn't expired.
+func (a Allowlist) Allows(id string, now time.Time) bool {
+	expiration, ok := a[id]
+	if !ok {
+		return false
+	}
+
+	// the expiration date is inclusive
+	return expiration.IsZero() || now.Before(expiration.AddDate(0, 0, 1))
+}
+
+// Policy decides which findings block the image.
+type Policy struct {
+	Threshold Severity
+	Allowlist Allowlist
+}
+
+// Result is the outcome of checking the findings against the policy.
+type Result struct {
+	Findings []Finding
+	// findings at or above the threshold that aren't allowed
+	Blocking []Finding
+	// findings at or above the threshold that are in the allowlist
+	Allowed []Finding
+}
+
+// Counts returns the number of findings per severity.
+func (r *Result) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, finding := range r.Findings {
+		counts[finding.Severity.String()]++
+	}
+
+	return counts
+}
+
+// Check classifies the findings. Findings with an unknown severity never block the image since
+// the lowest threshold is low.
+func (p Policy) Check(findings []Finding, now time.Time) *Result {
+	result := &Result{Findings: findings}
+
+	for _, finding := range findings {
+		if finding.Severity < p.Threshold {
+			continue
+		}
+
+		if p.Allowlist.Allows(finding.ID, now) {
+			result.Allowed = append(result.Allowed, finding)
+		} else {
+			result.Blocking = append(result.Blocking, finding)
+		}
+	}
+
+	return result
+}
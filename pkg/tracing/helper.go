@@ -0,0 +1,282 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const scopeName = "go.megpoid.dev/drone-kaniko"
+
+// OTLP protocols
+const (
+	protocolGRPC         = "grpc"
+	protocolHTTPProtobuf = "http/protobuf"
+	protocolHTTPJSON     = "http/json"
+)
+
+type contextKey struct{}
+
+// Tracer collects the spans of the run and exports them with OTLP, over HTTP or gRPC. A nil
+// tracer records nothing.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	traceID  trace.TraceID
+	// span of the caller that started the run, if any
+	parent trace.SpanContext
+	mask   func(string) string
+}
+
+// Config of the tracer that isn't read from the environment.
+type Config struct {
+	ServiceName string
+	// TraceID is used when the TRACEPARENT variable isn't set, so the spans of every step of
+	// a build share the same trace.
+	TraceID [16]byte
+	// Mask hides the secrets of the span attributes and errors.
+	Mask func(string) string
+}
+
+// NewFromEnv creates a tracer configured with the standard OpenTelemetry variables, like
+// OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_PROTOCOL and OTEL_EXPORTER_OTLP_HEADERS, which
+// are read by the exporter. Tracing is enabled only if an endpoint is set, or OTEL_TRACES_EXPORTER
+// is otlp, and returns nil otherwise.
+func NewFromEnv(cfg Config) *Tracer {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil
+	}
+
+	// the exporter can be enabled explicitly to use the default collector
+	if os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" &&
+		os.Getenv("OTEL_TRACES_EXPORTER") != "otlp" {
+		return nil
+	}
+
+	exporter, err := newExporter(protocolFromEnv())
+	if err != nil {
+		slog.Warn("Tracing disabled", "error", err)
+		return nil
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", cfg.ServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		slog.Warn("Invalid tracing resource attributes", "error", err)
+	}
+
+	t := &Tracer{traceID: cfg.TraceID, mask: cfg.Mask}
+	if t.mask == nil {
+		t.mask = func(text string) string { return text }
+	}
+
+	carrier := propagation.MapCarrier{"traceparent": os.Getenv("TRACEPARENT")}
+	if parent := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier)); parent.IsValid() {
+		t.parent = parent
+		t.traceID = parent.TraceID()
+	}
+
+	if !t.traceID.IsValid() {
+		_, _ = rand.Read(t.traceID[:])
+	}
+
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithIDGenerator(&idGenerator{traceID: t.traceID}),
+	)
+	t.tracer = t.provider.Tracer(scopeName)
+
+	return t
+}
+
+// protocolFromEnv returns the OTLP protocol of the traces, http/protobuf by default.
+func protocolFromEnv() string {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol == "" {
+		protocol = protocolHTTPProtobuf
+	}
+
+	return protocol
+}
+
+// newExporter returns the OTLP exporter of the protocol. The JSON encoding isn't implemented by
+// the exporter, so the spans are sent with protobuf to the same endpoint.
+func newExporter(protocol string) (sdktrace.SpanExporter, error) {
+	switch protocol {
+	case protocolGRPC:
+		return otlptracegrpc.New(context.Background())
+	case protocolHTTPJSON:
+		slog.Warn("The http/json OTLP protocol isn't supported, using http/protobuf")
+		fallthrough
+	case protocolHTTPProtobuf:
+		return otlptracehttp.New(context.Background())
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %s", protocol)
+	}
+}
+
+// idGenerator gives the same trace id to every root span of the run.
+type idGenerator struct {
+	traceID trace.TraceID
+}
+
+func (g *idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	return g.traceID, g.NewSpanID(ctx, g.traceID)
+}
+
+func (g *idGenerator) NewSpanID(context.Context, trace.TraceID) trace.SpanID {
+	var spanID trace.SpanID
+	for !spanID.IsValid() {
+		_, _ = rand.Read(spanID[:])
+	}
+
+	return spanID
+}
+
+// TraceID returns the trace id as hex, or an empty string if tracing is disabled.
+func (t *Tracer) TraceID() string {
+	if t == nil {
+		return ""
+	}
+
+	return t.traceID.String()
+}
+
+// ContextWithTracer returns a context that carries the tracer, used by Start.
+func ContextWithTracer(ctx context.Context, t *Tracer) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	if t.parent.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, t.parent)
+	}
+
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// Span is an operation of the run. A nil span records nothing.
+type Span struct {
+	tracer *Tracer
+	span   trace.Span
+}
+
+// Start creates a span as child of the span in the context, if the context has a tracer.
+func Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	t, _ := ctx.Value(contextKey{}).(*Tracer)
+	if t == nil {
+		return ctx, nil
+	}
+
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+
+	s := &Span{tracer: t, span: span}
+	s.SetAttributes(attrs...)
+
+	return ctx, s
+}
+
+// IsRecording checks if the span is exported, to skip collecting attributes otherwise.
+func (s *Span) IsRecording() bool {
+	return s != nil && s.span.IsRecording()
+}
+
+// SetAttributes adds attributes to the span, as key and value pairs. Values can be strings,
+// booleans, integers or string slices, anything else is formatted as a string.
+func (s *Span) SetAttributes(attrs ...any) {
+	if s == nil {
+		return
+	}
+
+	kvs := make([]attribute.KeyValue, 0, len(attrs)/2)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok {
+			continue
+		}
+		kvs = append(kvs, s.tracer.attribute(key, attrs[i+1]))
+	}
+
+	s.span.SetAttributes(kvs...)
+}
+
+// End finishes the span, with an error status if err isn't nil.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	if err != nil {
+		s.span.SetStatus(codes.Error, s.tracer.mask(err.Error()))
+	} else {
+		s.span.SetStatus(codes.Ok, "")
+	}
+
+	s.span.End()
+}
+
+// Shutdown exports the finished spans.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	if err := t.provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to export traces: %w", err)
+	}
+
+	slog.Debug("Traces exported", "trace_id", t.TraceID())
+
+	return nil
+}
+
+// attribute converts the value to an attribute, with the strings masked.
+func (t *Tracer) attribute(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, t.mask(v))
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case []string:
+		masked := make([]string, len(v))
+		for i, entry := range v {
+			masked[i] = t.mask(entry)
+		}
+		return attribute.StringSlice(key, masked)
+	case error:
+		return attribute.String(key, t.mask(v.Error()))
+	default:
+		return attribute.String(key, t.mask(fmt.Sprint(v)))
+	}
+}
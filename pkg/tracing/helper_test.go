@@ -0,0 +1,284 @@
+// Copyright 2023 codestation. All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// setEnv clears the OpenTelemetry variables read by the tracer and sets the given ones.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for _, key := range []string{
+		"OTEL_SDK_DISABLED",
+		"OTEL_TRACES_EXPORTER",
+		"OTEL_EXPORTER_OTLP_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_PROTOCOL",
+		"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL",
+		"OTEL_EXPORTER_OTLP_HEADERS",
+		"OTEL_EXPORTER_OTLP_TRACES_HEADERS",
+		"OTEL_SERVICE_NAME",
+		"OTEL_RESOURCE_ATTRIBUTES",
+		"TRACEPARENT",
+	} {
+		t.Setenv(key, env[key])
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantEnabled bool
+	}{
+		{name: "disabled"},
+		{name: "endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, wantEnabled: true},
+		{name: "traces endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/custom"}, wantEnabled: true},
+		{name: "default endpoint", env: map[string]string{"OTEL_TRACES_EXPORTER": "otlp"}, wantEnabled: true},
+		{name: "sdk disabled", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_SDK_DISABLED": "true"}},
+		{name: "exporter disabled", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_TRACES_EXPORTER": "none"}},
+		{name: "grpc", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4317", "OTEL_EXPORTER_OTLP_PROTOCOL": "grpc"}, wantEnabled: true},
+		{name: "http/json", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_PROTOCOL": "http/json"}, wantEnabled: true},
+		{name: "unknown protocol", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL": "thrift"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+
+			tracer := NewFromEnv(Config{ServiceName: "drone-kaniko", TraceID: [16]byte{1}})
+			if (tracer != nil) != tt.wantEnabled {
+				t.Fatalf("enabled = %t, want %t", tracer != nil, tt.wantEnabled)
+			}
+			if tracer == nil {
+				return
+			}
+
+			if got := tracer.TraceID(); got != "01000000000000000000000000000000" {
+				t.Errorf("trace id = %s", got)
+			}
+			if err := tracer.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// collector stores the export requests received over HTTP or gRPC.
+type collector struct {
+	coltracepb.UnimplementedTraceServiceServer
+	mu       sync.Mutex
+	requests []*coltracepb.ExportTraceServiceRequest
+	apiKey   string
+}
+
+func (c *collector) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("api-key")) > 0 {
+		c.apiKey = md.Get("api-key")[0]
+	}
+	c.requests = append(c.requests, req)
+
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+
+	var req coltracepb.ExportTraceServiceRequest
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" || proto.Unmarshal(data, &req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.apiKey = r.Header.Get("api-key")
+	c.requests = append(c.requests, &req)
+	c.mu.Unlock()
+
+	data, _ = proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(data)
+}
+
+// startCollector starts a collector for the protocol and returns its endpoint.
+func startCollector(t *testing.T, c *collector, protocol string) string {
+	t.Helper()
+
+	if protocol != protocolGRPC {
+		server := httptest.NewServer(c)
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(server, c)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	return "http://" + listener.Addr().String()
+}
+
+func TestShutdown(t *testing.T) {
+	for _, protocol := range []string{protocolHTTPProtobuf, protocolHTTPJSON, protocolGRPC} {
+		t.Run(protocol, func(t *testing.T) {
+			c := &collector{}
+
+			setEnv(t, map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT": startCollector(t, c, protocol),
+				"OTEL_EXPORTER_OTLP_PROTOCOL": protocol,
+				"OTEL_EXPORTER_OTLP_HEADERS":  "api-key=secret",
+				"OTEL_RESOURCE_ATTRIBUTES":    "deployment.environment=ci",
+				"TRACEPARENT":                 "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			})
+
+			mask := func(text string) string { return strings.ReplaceAll(text, "s3cr3t", "********") }
+
+			tracer := NewFromEnv(Config{ServiceName: "drone-kaniko", Mask: mask})
+			if tracer == nil {
+				t.Fatal("tracing disabled")
+			}
+
+			ctx := ContextWithTracer(context.Background(), tracer)
+			buildCtx, build := Start(ctx, "build", "platform", "linux/amd64", "attempts", 2, "size", int64(1024), "cached", true)
+			_, push := Start(buildCtx, "push", "tags", []string{"1.0", "latest"}, "command", "login -p s3cr3t")
+			push.End(errors.New("unauthorized: token s3cr3t expired"))
+			build.End(nil)
+
+			if err := tracer.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if c.apiKey != "secret" {
+				t.Errorf("api key = %s", c.apiKey)
+			}
+			if len(c.requests) != 1 || len(c.requests[0].ResourceSpans) != 1 || len(c.requests[0].ResourceSpans[0].ScopeSpans) != 1 {
+				t.Fatalf("unexpected requests %v", c.requests)
+			}
+
+			resource := map[string]string{}
+			for _, attr := range c.requests[0].ResourceSpans[0].Resource.Attributes {
+				resource[attr.Key] = attr.Value.GetStringValue()
+			}
+			if resource["service.name"] != "drone-kaniko" || resource["deployment.environment"] != "ci" {
+				t.Errorf("resource = %v", resource)
+			}
+
+			spans := c.requests[0].ResourceSpans[0].ScopeSpans[0].Spans
+			if len(spans) != 2 {
+				t.Fatalf("spans = %d, want 2", len(spans))
+			}
+
+			pushSpan, buildSpan := spans[0], spans[1]
+
+			for _, span := range spans {
+				if got := hex.EncodeToString(span.TraceId); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+					t.Errorf("%s trace id = %s", span.Name, got)
+				}
+			}
+
+			if got := hex.EncodeToString(buildSpan.ParentSpanId); got != "00f067aa0ba902b7" {
+				t.Errorf("build parent = %s", got)
+			}
+			if !reflect.DeepEqual(pushSpan.ParentSpanId, buildSpan.SpanId) {
+				t.Errorf("push parent = %x, want %x", pushSpan.ParentSpanId, buildSpan.SpanId)
+			}
+
+			if buildSpan.Status.GetCode() != tracepb.Status_STATUS_CODE_OK {
+				t.Errorf("build status = %v", buildSpan.Status)
+			}
+			// the errors of the commands can have the secrets of the arguments
+			if pushSpan.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR || pushSpan.Status.GetMessage() != "unauthorized: token ******** expired" {
+				t.Errorf("push status = %v", pushSpan.Status)
+			}
+
+			wantAttrs := []*commonpb.KeyValue{
+				{Key: "platform", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "linux/amd64"}}},
+				{Key: "attempts", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 2}}},
+				{Key: "size", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 1024}}},
+				{Key: "cached", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}},
+			}
+			if !equalAttributes(buildSpan.Attributes, wantAttrs) {
+				t.Errorf("build attributes = %v, want %v", buildSpan.Attributes, wantAttrs)
+			}
+
+			wantAttrs = []*commonpb.KeyValue{
+				{Key: "tags", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: []*commonpb.AnyValue{
+					{Value: &commonpb.AnyValue_StringValue{StringValue: "1.0"}},
+					{Value: &commonpb.AnyValue_StringValue{StringValue: "latest"}},
+				}}}}},
+				{Key: "command", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "login -p ********"}}},
+			}
+			if !equalAttributes(pushSpan.Attributes, wantAttrs) {
+				t.Errorf("push attributes = %v, want %v", pushSpan.Attributes, wantAttrs)
+			}
+
+			// the exported spans are not sent again
+			if err := tracer.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if len(c.requests) != 1 {
+				t.Errorf("requests = %d, want 1", len(c.requests))
+			}
+		})
+	}
+}
+
+func equalAttributes(got, want []*commonpb.KeyValue) bool {
+	if len(got) != len(want) {
+		return false
+	}
+
+	for i := range got {
+		if !proto.Equal(got[i], want[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	ctx := ContextWithTracer(context.Background(), tracer)
+	_, span := Start(ctx, "build")
+	if span.IsRecording() {
+		t.Error("span recorded without a tracer")
+	}
+
+	span.SetAttributes("platform", "linux/amd64")
+	span.End(nil)
+
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}